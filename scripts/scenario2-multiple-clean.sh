@@ -38,9 +38,9 @@ docker exec tcp-client3 ls -la /root/test-files/ || echo "Client3 test-files not
 
 # Run clients concurrently with simplified commands and proper paths
 echo "Starting client transfers..."
-docker exec -d tcp-client1 /bin/sh -c "cd /root && timeout 900s bash -c 'echo \"put test-files/test_200MB_scenario2-multiple-clean_client1.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
-docker exec -d tcp-client2 /bin/sh -c "cd /root && timeout 900s bash -c 'echo \"put test-files/test_200MB_scenario2-multiple-clean_client2.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
-docker exec -d tcp-client3 /bin/sh -c "cd /root && timeout 900s bash -c 'echo \"put test-files/test_200MB_scenario2-multiple-clean_client3.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
+docker exec -d tcp-client1 /bin/sh -c "cd /root && timeout 900s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario2-multiple-clean_client1.bin scripts/sessions/upload.session"
+docker exec -d tcp-client2 /bin/sh -c "cd /root && timeout 900s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario2-multiple-clean_client2.bin scripts/sessions/upload.session"
+docker exec -d tcp-client3 /bin/sh -c "cd /root && timeout 900s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario2-multiple-clean_client3.bin scripts/sessions/upload.session"
 
 # Wait until TCP transfers on port 8080 fully quiesce, to avoid stopping captures too early
 TIMEOUT=1200 CHECK_INTERVAL=3 STABLE_CYCLES=2 ./scripts/wait_transfers.sh 8080 tcp-client1 tcp-client2 tcp-client3
@@ -27,7 +27,7 @@ sleep 5
 # Apply packet loss with retry and run client with timeout guard
 # Apply netem on server as well for bidirectional emulation
 docker exec tcp-server /bin/sh -c "for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done"
-docker exec tcp-client1 /bin/sh -c "for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done && timeout 900s sh -c \"echo 'put test-files/test_200MB.bin' | ./client --host=server --port=8080 --log-dir=./logs\""
+docker exec tcp-client1 /bin/sh -c "for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done && timeout 900s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB.bin scripts/sessions/upload.session"
 
 # Wait until TCP transfers on port 8080 fully quiesce, to avoid stopping captures too early
 TIMEOUT=1200 CHECK_INTERVAL=3 STABLE_CYCLES=2 ./scripts/wait_transfers.sh 8080 tcp-client1
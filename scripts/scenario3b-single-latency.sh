@@ -30,7 +30,7 @@ sleep 5
 # Use timeout inside the container; 900s (15min) should be sufficient for the transfer under emulation.
 # Apply netem on server as well for bidirectional emulation
 docker exec tcp-server /bin/sh -c "tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 10ms 2ms && break || sleep 1; done"
-docker exec tcp-client1 /bin/sh -c "tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 50ms 2ms && break || sleep 1; done && timeout 1200s sh -c \"echo 'put test-files/test_200MB.bin' | ./client --host=server --port=8080 --log-dir=./logs\""
+docker exec tcp-client1 /bin/sh -c "tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 50ms 2ms && break || sleep 1; done && timeout 1200s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB.bin scripts/sessions/upload.session"
 
 # Wait until TCP transfers on port 8080 fully quiesce, to avoid stopping captures too early (latency scenario)
 TIMEOUT=1500 CHECK_INTERVAL=3 STABLE_CYCLES=2 ./scripts/wait_transfers.sh 8080 tcp-client1
@@ -41,9 +41,9 @@ docker exec tcp-client3 /bin/sh -c "mkdir -p /root/logs/${SCENARIO_NAME} && prin
 
 # Run clients concurrently with packet loss applied inside each client container
 echo "Starting client transfers with packet loss..."
-docker exec -d tcp-client1 /bin/sh -c "cd /root && for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done && timeout 900s bash -c 'echo \"put test-files/test_200MB_scenario4a-multiple-loss_client1.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
-docker exec -d tcp-client2 /bin/sh -c "cd /root && for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done && timeout 900s bash -c 'echo \"put test-files/test_200MB_scenario4a-multiple-loss_client2.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
-docker exec -d tcp-client3 /bin/sh -c "cd /root && for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done && timeout 900s bash -c 'echo \"put test-files/test_200MB_scenario4a-multiple-loss_client3.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
+docker exec -d tcp-client1 /bin/sh -c "cd /root && for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done && timeout 900s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario4a-multiple-loss_client1.bin scripts/sessions/upload.session"
+docker exec -d tcp-client2 /bin/sh -c "cd /root && for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done && timeout 900s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario4a-multiple-loss_client2.bin scripts/sessions/upload.session"
+docker exec -d tcp-client3 /bin/sh -c "cd /root && for i in 1 2 3; do tc qdisc add dev eth0 root netem loss 1% && break || sleep 1; done && timeout 900s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario4a-multiple-loss_client3.bin scripts/sessions/upload.session"
 
 # Wait until TCP transfers on port 8080 fully quiesce, to avoid stopping captures too early
 TIMEOUT=1500 CHECK_INTERVAL=3 STABLE_CYCLES=2 ./scripts/wait_transfers.sh 8080 tcp-client1 tcp-client2 tcp-client3
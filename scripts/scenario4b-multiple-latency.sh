@@ -49,9 +49,9 @@ docker exec tcp-client3 ls -la /root/test-files/ || echo "Client3 test-files not
 echo "Starting client transfers with variable latency..."
 # Apply netem on server as well for bidirectional emulation
 docker exec tcp-server /bin/sh -c "tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 50ms 10ms && break || sleep 1; done"
-docker exec -d tcp-client1 /bin/sh -c "cd /root && tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 10ms 5ms && break || sleep 1; done && timeout 1200s bash -c 'echo \"put test-files/test_200MB_scenario4b-multiple-latency_client1.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
-docker exec -d tcp-client2 /bin/sh -c "cd /root && tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 10ms 5ms && break || sleep 1; done && timeout 1200s bash -c 'echo \"put test-files/test_200MB_scenario4b-multiple-latency_client2.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
-docker exec -d tcp-client3 /bin/sh -c "cd /root && tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 10ms 5ms && break || sleep 1; done && timeout 1200s bash -c 'echo \"put test-files/test_200MB_scenario4b-multiple-latency_client3.bin\" | ./client --host=server --port=8080 --log-dir=./logs'"
+docker exec -d tcp-client1 /bin/sh -c "cd /root && tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 10ms 5ms && break || sleep 1; done && timeout 1200s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario4b-multiple-latency_client1.bin scripts/sessions/upload.session"
+docker exec -d tcp-client2 /bin/sh -c "cd /root && tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 10ms 5ms && break || sleep 1; done && timeout 1200s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario4b-multiple-latency_client2.bin scripts/sessions/upload.session"
+docker exec -d tcp-client3 /bin/sh -c "cd /root && tc qdisc del dev eth0 root 2>/dev/null || true; for i in 1 2 3; do tc qdisc add dev eth0 root netem delay 10ms 5ms && break || sleep 1; done && timeout 1200s ./client --host=server --port=8080 --log-dir=./logs run -var FILE=test-files/test_200MB_scenario4b-multiple-latency_client3.bin scripts/sessions/upload.session"
 
 # Wait until TCP transfers on port 8080 fully quiesce, to avoid stopping captures too early (latency scenario)
 TIMEOUT=1800 CHECK_INTERVAL=3 STABLE_CYCLES=2 ./scripts/wait_transfers.sh 8080 tcp-client1 tcp-client2 tcp-client3
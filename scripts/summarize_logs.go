@@ -26,6 +26,7 @@ type ConnectionLog struct {
 	Operation            string      `json:"operation"`
 	Scenario             string      `json:"scenario"`
 	ContainerName        string      `json:"container_name"`
+	CongestionControl    string      `json:"congestion_control"`
 	InitialRTTMs         float64     `json:"initial_rtt_ms"`
 	FinalRTTMs           float64     `json:"final_rtt_ms"`
 	InitialCwnd          uint32      `json:"initial_cwnd"`
@@ -86,7 +87,7 @@ func main() {
 	defer out.Close()
 	w := csv.NewWriter(out)
 	defer w.Flush()
-	head := []string{"scenario", "container", "operation", "start_time", "end_time", "duration_s", "bytes_sent", "bytes_received", "throughput_Bps", "init_rtt_ms", "final_rtt_ms", "init_cwnd", "final_cwnd", "init_ssthresh", "final_ssthresh", "total_retrans"}
+	head := []string{"scenario", "container", "operation", "congestion_control", "start_time", "end_time", "duration_s", "bytes_sent", "bytes_received", "throughput_Bps", "init_rtt_ms", "final_rtt_ms", "init_cwnd", "final_cwnd", "init_ssthresh", "final_ssthresh", "total_retrans"}
 	if *includeSamples {
 		head = append(head, "mean_rtt_ms", "mean_cwnd", "mean_ssthresh", "mean_retrans_rate_per_s")
 	}
@@ -149,6 +150,7 @@ func main() {
 			cl.Scenario,
 			cl.ContainerName,
 			cl.Operation,
+			cl.CongestionControl,
 			cl.StartTime.Format(time.RFC3339),
 			cl.EndTime.Format(time.RFC3339),
 			fmt.Sprintf("%.3f", cl.Duration),
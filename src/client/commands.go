@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// Exit codes of the non-interactive commands
+const (
+	exitOK       = 0
+	exitUsage    = 1 // Invalid flags or arguments, or a local file could not be used
+	exitConnect  = 2 // The server could not be reached
+	exitProtocol = 3 // The connection failed or the server sent something unexpected
+	exitServer   = 4 // The server answered with an ERROR frame
+)
+
+// opError is a failed operation with the exit code it maps to
+type opError struct {
+	code int
+	err  error
+}
+
+func (e *opError) Error() string { return e.err.Error() }
+
+func (e *opError) Unwrap() error { return e.err }
+
+// failure returns an opError with a formatted message
+func failure(code int, format string, args ...interface{}) error {
+	return &opError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode maps an operation error to the process exit code
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var opErr *opError
+	if errors.As(err, &opErr) {
+		return opErr.code
+	}
+	return exitProtocol
+}
+
+// result is the JSON document a command prints on stdout
+type result struct {
+	Op                       string              `json:"op"`
+	Step                     int                 `json:"step,omitempty"` // Script step number
+	Line                     int                 `json:"line,omitempty"` // Script line of the step
+	OK                       bool                `json:"ok"`
+	ExitCode                 int                 `json:"exit_code"`
+	Error                    string              `json:"error,omitempty"`
+	ConnID                   string              `json:"conn_id,omitempty"`
+	File                     string              `json:"file,omitempty"`
+	Remote                   string              `json:"remote,omitempty"`
+	Bytes                    int64               `json:"bytes,omitempty"`
+	DurationSeconds          float64             `json:"duration_seconds,omitempty"`
+	ThroughputBps            float64             `json:"throughput_bps,omitempty"`              // Payload bytes per second
+	SteadyStateThroughputBps float64             `json:"steady_state_throughput_bps,omitempty"` // Between warm-up and cool-down, from TCP_INFO
+	FinalCwnd                uint32              `json:"final_cwnd,omitempty"`
+	TotalRetransmissions     uint32              `json:"total_retransmissions,omitempty"`
+	Message                  string              `json:"message,omitempty"`
+	Files                    []string            `json:"files,omitempty"`
+	Streams                  []common.StreamStat `json:"streams,omitempty"` // Per-stream breakdown of a parallel upload
+	IdleGaps                 []common.IdleGap    `json:"idle_gaps,omitempty"`
+	Runs                     []result            `json:"runs,omitempty"`
+	Summary                  *benchSummary       `json:"summary,omitempty"`
+	Sweep                    []sweepRow          `json:"sweep,omitempty"`
+	Flows                    *flowsSummary       `json:"flows,omitempty"`
+	Session                  *sessionSummary     `json:"session,omitempty"`
+}
+
+// benchSummary aggregates the successful runs of a bench command
+type benchSummary struct {
+	Runs                int     `json:"runs"`
+	MinThroughputBps    float64 `json:"min_throughput_bps"`
+	MeanThroughputBps   float64 `json:"mean_throughput_bps"`
+	MaxThroughputBps    float64 `json:"max_throughput_bps"`
+	MeanDurationSeconds float64 `json:"mean_duration_seconds"`
+}
+
+// finish fills in the outcome of an operation
+func (r *result) finish(log *common.ConnectionLog, bytes int64, err error) {
+	r.ExitCode = exitCode(err)
+	r.OK = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.Bytes = bytes
+	}
+	if log == nil {
+		return
+	}
+	r.ConnID = log.ConnID
+	r.FinalCwnd = log.FinalCwnd
+	r.TotalRetransmissions = log.TotalRetransmissions
+	r.DurationSeconds = log.EndTime.Sub(log.StartTime).Seconds()
+	if err == nil && r.DurationSeconds > 0 {
+		r.ThroughputBps = float64(bytes) / r.DurationSeconds
+	}
+}
+
+// usage prints the command line help
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [command flags] [args]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(out, "Commands:\n")
+	fmt.Fprintf(out, "  put [-as name] <file>                 Upload a file\n")
+	fmt.Fprintf(out, "  get [-o path] <name>                  Download a file\n")
+	fmt.Fprintf(out, "  list                                  List the files on the server\n")
+	fmt.Fprintf(out, "  send [-duration d] [-warmup d] [-cooldown d] [file]  Upload for a fixed time, repeating file or zeros\n")
+	fmt.Fprintf(out, "  onoff [-duration d] [-on size|d] [-off dist] [-seed n] [file]  Bursty upload with random OFF periods\n")
+	fmt.Fprintf(out, "  ping [-n count]                       Measure application-level round trips\n")
+	fmt.Fprintf(out, "  idle [-gaps list] [-burst size] [-chunk n]  Send bursts around idle gaps and record the cwnd\n")
+	fmt.Fprintf(out, "  run [-var NAME=value] [-keep-going] <script>  Run a session script\n")
+	fmt.Fprintf(out, "  bench [-n runs] [-interval d] <file>  Upload a file repeatedly and summarize the throughput\n")
+	fmt.Fprintf(out, "  sweep [-min size] [-max size] [-factor f] [-n runs] [-o table.csv]  Throughput versus transfer size\n")
+	fmt.Fprintf(out, "  flows [-rate n] [-duration d] [-pool n] [-link-rate bps] <cdf>  Poisson short-flow workload with FCT statistics\n")
+	fmt.Fprintf(out, "  shell                                 Interactive shell (the default)\n\n")
+	fmt.Fprintf(out, "Commands print a JSON result on stdout and exit with 0 on success, %d for usage or\n", exitUsage)
+	fmt.Fprintf(out, "local file errors, %d for connect errors, %d for protocol errors and %d for server errors.\n\n", exitConnect, exitProtocol, exitServer)
+	fmt.Fprintf(out, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+// run executes a command and returns the process exit code
+func (c *client) run(command string, args []string) int {
+	if command == "shell" {
+		return c.shell()
+	}
+
+	var res *result
+	switch command {
+	case "put":
+		res = c.putCommand(args)
+	case "get":
+		res = c.getCommand(args)
+	case "list":
+		res = c.listCommand(args)
+	case "send":
+		res = c.sendCommand(args)
+	case "onoff":
+		res = c.onOffCommand(args)
+	case "ping":
+		res = c.pingCommand(args)
+	case "idle":
+		res = c.idleCommand(args)
+	case "bench":
+		res = c.benchCommand(args)
+	case "sweep":
+		res = c.sweepCommand(args)
+	case "flows":
+		res = c.flowsCommand(args)
+	case "run":
+		res = c.runCommand(args)
+	default:
+		res = &result{Op: command}
+		res.finish(nil, 0, failure(exitUsage, "unknown command %q", command))
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		flag.Usage()
+	}
+
+	// After JSON interval lines the result is a line of its own too
+	encoder := json.NewEncoder(os.Stdout)
+	if c.reports == nil || c.reports.format != reportJSON {
+		encoder.SetIndent("", "  ")
+	}
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(res)
+	return res.ExitCode
+}
+
+// commandFlags parses the flags of a command, which must leave between min and
+// max positional arguments behind
+func commandFlags(res *result, fs *flag.FlagSet, args []string, min, max int, usage string) ([]string, bool) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n", usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		res.finish(nil, 0, failure(exitUsage, "%w", err))
+		return nil, false
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		fs.Usage()
+		res.finish(nil, 0, failure(exitUsage, "usage: %s", usage))
+		return nil, false
+	}
+	return fs.Args(), true
+}
+
+func (c *client) putCommand(args []string) *result {
+	res := &result{Op: "put"}
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	as := fs.String("as", "", "Name to store the file under (default: the local file name)")
+	args, ok := commandFlags(res, fs, args, 1, 1, "put [-as name] <file>")
+	if !ok {
+		return res
+	}
+
+	return c.runPut(args[0], *as)
+}
+
+// runPut uploads a file under remote, by default its base name
+func (c *client) runPut(file, remote string) *result {
+	res := &result{Op: "put", File: file, Remote: remote}
+	if res.Remote == "" {
+		res.Remote = filepath.Base(file)
+	}
+	log, message, err := c.put(res.File, res.Remote)
+	c.settle(err)
+	res.Message = message
+	bytes, _ := c.uploadSize(res.File)
+	if log != nil && len(log.Streams) > 0 {
+		bytes = 0
+		for _, st := range log.Streams {
+			bytes += st.Bytes
+		}
+		res.Streams = log.Streams
+	}
+	res.finish(log, bytes, err)
+	return res
+}
+
+func (c *client) getCommand(args []string) *result {
+	res := &result{Op: "get"}
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	output := fs.String("o", "", "Local path to write the file to (default: the remote name in the current directory)")
+	args, ok := commandFlags(res, fs, args, 1, 1, "get [-o path] <name>")
+	if !ok {
+		return res
+	}
+
+	return c.runGet(args[0], *output)
+}
+
+// runGet downloads remote into file, by default its base name in the current directory
+func (c *client) runGet(remote, file string) *result {
+	res := &result{Op: "get", File: file, Remote: remote}
+	if res.File == "" {
+		res.File = filepath.Base(remote)
+	}
+	log, received, err := c.get(res.Remote, res.File)
+	c.settle(err)
+	res.finish(log, received, err)
+	return res
+}
+
+func (c *client) listCommand(args []string) *result {
+	res := &result{Op: "list"}
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	if _, ok := commandFlags(res, fs, args, 0, 0, "list"); !ok {
+		return res
+	}
+	return c.runList()
+}
+
+// runList fetches the file listing
+func (c *client) runList() *result {
+	res := &result{Op: "list"}
+	log, listing, err := c.list()
+	c.settle(err)
+	res.finish(log, 0, err)
+	// The server answers an empty listing with a message instead of file lines
+	if err == nil && listing != "No files found" {
+		res.Files = strings.Split(listing, "\n")
+	}
+	return res
+}
+
+func (c *client) sendCommand(args []string) *result {
+	res := &result{Op: "send"}
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	var window common.SteadyWindow
+	fs.DurationVar(&window.Duration, "duration", 10*time.Second, "How long to send")
+	fs.DurationVar(&window.Warmup, "warmup", 0, "Start of the run left out of the steady-state throughput")
+	fs.DurationVar(&window.Cooldown, "cooldown", 0, "End of the run left out of the steady-state throughput")
+	chunk := fs.Int("chunk", 64*1024, "Payload bytes per DATA frame")
+	args, ok := commandFlags(res, fs, args, 0, 1, "send [-duration d] [-warmup d] [-cooldown d] [-chunk n] [file]")
+	if !ok {
+		return res
+	}
+	if _, err := common.ParseSteadyWindow(window.String()); err != nil {
+		res.finish(nil, 0, failure(exitUsage, "%w", err))
+		return res
+	}
+	file := ""
+	if len(args) > 0 {
+		file = args[0]
+	}
+	return c.runSend(file, window, *chunk)
+}
+
+func (c *client) pingCommand(args []string) *result {
+	res := &result{Op: "ping"}
+	fs := flag.NewFlagSet("ping", flag.ContinueOnError)
+	count := fs.Int("n", 1, "Number of PING frames")
+	if _, ok := commandFlags(res, fs, args, 0, 0, "ping [-n count]"); !ok {
+		return res
+	}
+	return c.runPing(*count)
+}
+
+// runPing sends count PING frames and reports their round trips
+func (c *client) runPing(count int) *result {
+	res := &result{Op: "ping"}
+	if count < 1 {
+		res.finish(nil, 0, failure(exitUsage, "ping count must be at least 1"))
+		return res
+	}
+
+	log, rtts, err := c.ping(count)
+	c.settle(err)
+	res.finish(log, 0, err)
+	if len(rtts) > 0 {
+		min, max, sum := rtts[0], rtts[0], time.Duration(0)
+		for _, rtt := range rtts {
+			if rtt < min {
+				min = rtt
+			}
+			if rtt > max {
+				max = rtt
+			}
+			sum += rtt
+		}
+		ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+		res.Message = fmt.Sprintf("%d round trips, rtt min/avg/max = %.3f/%.3f/%.3f ms",
+			len(rtts), ms(min), ms(sum/time.Duration(len(rtts))), ms(max))
+	}
+	return res
+}
+
+func (c *client) idleCommand(args []string) *result {
+	res := &result{Op: "idle"}
+	fs := flag.NewFlagSet("idle", flag.ContinueOnError)
+	gapSpec := fs.String("gaps", "100ms,500ms,1s,2s,5s", "Comma-separated idle gaps, each followed by a burst")
+	burstSpec := fs.String("burst", "1MB", "Bytes per burst, with an optional K, M or G suffix")
+	chunk := fs.Int("chunk", 64*1024, "Payload bytes per DATA frame")
+	if _, ok := commandFlags(res, fs, args, 0, 0, "idle [-gaps list] [-burst size] [-chunk n]"); !ok {
+		return res
+	}
+	gaps, err := parseGaps(*gapSpec)
+	if err != nil {
+		res.finish(nil, 0, failure(exitUsage, "%w", err))
+		return res
+	}
+	burst, err := common.ParseSize(*burstSpec)
+	if err != nil {
+		res.finish(nil, 0, failure(exitUsage, "%w", err))
+		return res
+	}
+	return c.runIdle(gaps, burst, *chunk)
+}
+
+func (c *client) benchCommand(args []string) *result {
+	res := &result{Op: "bench"}
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	runs := fs.Int("n", 5, "Number of uploads")
+	interval := fs.Duration("interval", 0, "Pause between uploads")
+	args, ok := commandFlags(res, fs, args, 1, 1, "bench [-n runs] [-interval d] <file>")
+	if !ok {
+		return res
+	}
+	if *runs < 1 {
+		res.finish(nil, 0, failure(exitUsage, "-n must be at least 1"))
+		return res
+	}
+
+	// Every upload gets a name of its own since the server refuses to overwrite files
+	res.File = args[0]
+	size, _ := c.uploadSize(res.File)
+	base := fmt.Sprintf("%s.%d", filepath.Base(res.File), time.Now().Unix())
+	summary := &benchSummary{}
+	var err error
+	for i := 1; i <= *runs; i++ {
+		if i > 1 && *interval > 0 {
+			time.Sleep(*interval)
+		}
+
+		run := c.runPut(res.File, fmt.Sprintf("%s-%d", base, i))
+		res.Runs = append(res.Runs, *run)
+		if !run.OK {
+			err = &opError{code: run.ExitCode, err: errors.New(run.Error)}
+			break
+		}
+
+		if summary.Runs == 0 || run.ThroughputBps < summary.MinThroughputBps {
+			summary.MinThroughputBps = run.ThroughputBps
+		}
+		if run.ThroughputBps > summary.MaxThroughputBps {
+			summary.MaxThroughputBps = run.ThroughputBps
+		}
+		summary.MeanThroughputBps += run.ThroughputBps
+		summary.MeanDurationSeconds += run.DurationSeconds
+		summary.Runs++
+	}
+
+	if summary.Runs > 0 {
+		summary.MeanThroughputBps /= float64(summary.Runs)
+		summary.MeanDurationSeconds /= float64(summary.Runs)
+		res.Summary = summary
+	}
+	res.finish(nil, size*int64(summary.Runs), err)
+	return res
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{failure(exitUsage, "bad flag"), exitUsage},
+		{failure(exitConnect, "failed to connect: %w", errors.New("refused")), exitConnect},
+		{failure(exitServer, "server error: %s", "File not found"), exitServer},
+		{fmt.Errorf("run 3: %w", failure(exitServer, "quota")), exitServer},
+		{errors.New("unclassified"), exitProtocol},
+		{io.ErrUnexpectedEOF, exitProtocol},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+
+	// The cause stays reachable through the exit code wrapper
+	cause := errors.New("refused")
+	if err := failure(exitConnect, "failed to connect: %w", cause); !errors.Is(err, cause) || err.Error() != "failed to connect: refused" {
+		t.Errorf("failure() = %v, does not wrap its cause", err)
+	}
+}
+
+func TestResultFinish(t *testing.T) {
+	start := time.Now()
+	log := &common.ConnectionLog{ConnID: "3", StartTime: start, EndTime: start.Add(2 * time.Second)}
+
+	var ok result
+	ok.finish(log, 1000, nil)
+	if !ok.OK || ok.ExitCode != exitOK || ok.Bytes != 1000 || ok.ConnID != "3" || ok.DurationSeconds != 2 || ok.ThroughputBps != 500 {
+		t.Errorf("finish() of a success = %+v", ok)
+	}
+
+	var failed result
+	failed.finish(log, 1000, failure(exitServer, "server error: full"))
+	if failed.OK || failed.ExitCode != exitServer || failed.Error != "server error: full" || failed.Bytes != 0 || failed.ThroughputBps != 0 {
+		t.Errorf("finish() of a failure = %+v", failed)
+	}
+}
+
+func TestCommandFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		min, max int
+		wantOK   bool
+		wantArgs []string
+	}{
+		{args: []string{"-as", "b", "a.bin"}, min: 1, max: 1, wantOK: true, wantArgs: []string{"a.bin"}},
+		{args: []string{"a.bin", "b.bin"}, min: 1, max: 1},
+		{args: nil, min: 1, max: 1},
+		{args: []string{"-bogus", "a.bin"}, min: 1, max: 1},
+		{args: nil, min: 0, max: 1, wantOK: true, wantArgs: []string{}},
+		{args: []string{"a.bin"}, min: 0, max: 1, wantOK: true, wantArgs: []string{"a.bin"}},
+		{args: []string{"a.bin", "b.bin"}, min: 0, max: 1},
+	}
+	for _, tt := range tests {
+		res := &result{Op: "put"}
+		fs := flag.NewFlagSet("put", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.String("as", "", "")
+		args, ok := commandFlags(res, fs, tt.args, tt.min, tt.max, "put [-as name] <file>")
+		if ok != tt.wantOK || fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+			t.Errorf("commandFlags(%q, %d, %d) = %q, %t, want %q, %t", tt.args, tt.min, tt.max, args, ok, tt.wantArgs, tt.wantOK)
+		}
+		if !ok && res.ExitCode != exitUsage {
+			t.Errorf("commandFlags(%q, %d, %d) exit code = %d, want %d", tt.args, tt.min, tt.max, res.ExitCode, exitUsage)
+		}
+	}
+}
+
+// fakeServer answers every request frame with respond's frame, closing the
+// connection when respond returns nil. It returns the server address.
+func fakeServer(t *testing.T, respond func(*protocol.Frame) *protocol.Frame) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					request, err := protocol.ReadFrame(conn)
+					if err != nil {
+						return
+					}
+					response := respond(request)
+					if response == nil {
+						return
+					}
+					protocol.WriteFrame(conn, response)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// testClient returns a client of address that logs into a temporary directory
+func testClient(t *testing.T, address string) *client {
+	return &client{address: address, logger: common.NewLogger(t.TempDir()), profile: &common.SocketProfile{}}
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	answer := func(frame *protocol.Frame) func(*protocol.Frame) *protocol.Frame {
+		return func(request *protocol.Frame) *protocol.Frame {
+			if request.OpCode == protocol.OpOption {
+				key, value, _ := protocol.ParseOptionFrame(request)
+				if value == "reject" {
+					return protocol.CreateErrorFrame("unsupported")
+				}
+				return protocol.CreateOptionFrame(key, value)
+			}
+			return frame
+		}
+	}
+	closed := func(t *testing.T) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.Close()
+		return ln.Addr().String()
+	}
+	file := filepath.Join(t.TempDir(), "a.bin")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		address func(t *testing.T) string
+		options []serverOption
+		command func(c *client) *result
+		want    int
+	}{
+		{
+			name: "list",
+			address: func(t *testing.T) string {
+				return fakeServer(t, answer(&protocol.Frame{OpCode: protocol.OpList, PayloadLen: 5, Payload: []byte("a.bin")}))
+			},
+			command: func(c *client) *result { return c.listCommand(nil) },
+			want:    exitOK,
+		},
+		{
+			name:    "usage",
+			address: closed,
+			command: func(c *client) *result { return c.listCommand([]string{"extra"}) },
+			want:    exitUsage,
+		},
+		{
+			name:    "missing local file",
+			address: func(t *testing.T) string { return fakeServer(t, answer(protocol.CreateErrorFrame("unexpected"))) },
+			command: func(c *client) *result { return c.putCommand([]string{filepath.Join(t.TempDir(), "missing")}) },
+			want:    exitUsage,
+		},
+		{
+			name:    "connect",
+			address: closed,
+			command: func(c *client) *result { return c.listCommand(nil) },
+			want:    exitConnect,
+		},
+		{
+			name:    "protocol",
+			address: func(t *testing.T) string { return fakeServer(t, answer(nil)) },
+			command: func(c *client) *result { return c.putCommand([]string{file}) },
+			want:    exitProtocol,
+		},
+		{
+			name:    "unexpected response",
+			address: func(t *testing.T) string { return fakeServer(t, answer(protocol.CreateQuitFrame())) },
+			command: func(c *client) *result { return c.listCommand(nil) },
+			want:    exitProtocol,
+		},
+		{
+			name:    "server error",
+			address: func(t *testing.T) string { return fakeServer(t, answer(protocol.CreateErrorFrame("File not found"))) },
+			command: func(c *client) *result { return c.getCommand([]string{"-o", filepath.Join(t.TempDir(), "x"), "x"}) },
+			want:    exitServer,
+		},
+		{
+			name:    "option rejected",
+			address: func(t *testing.T) string { return fakeServer(t, answer(protocol.CreateListFrame())) },
+			options: []serverOption{{protocol.OptCongestionControl, "cubic"}, {protocol.OptNamespace, "reject"}},
+			command: func(c *client) *result { return c.listCommand(nil) },
+			want:    exitServer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testClient(t, tt.address(t))
+			c.options = tt.options
+			res := tt.command(c)
+			if res.ExitCode != tt.want || res.OK != (tt.want == exitOK) {
+				t.Errorf("exit code = %d (%s), want %d", res.ExitCode, res.Error, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+// flowSizes is an empirical flow size distribution read from a CDF file with
+// one "<size_bytes> <cumulative_probability>" point per line, in increasing order
+// and ending at probability 1. Lines starting with '#' are comments. Draws
+// interpolate linearly between the points.
+type flowSizes struct {
+	sizes []float64
+	cdf   []float64
+}
+
+func loadFlowSizes(path string) (*flowSizes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := &flowSizes{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want <size_bytes> <cumulative_probability>", line)
+		}
+		size, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("line %d: invalid size %q", line, fields[0])
+		}
+		p, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("line %d: invalid probability %q", line, fields[1])
+		}
+		if n := len(d.sizes); n > 0 && (size < d.sizes[n-1] || p < d.cdf[n-1]) {
+			return nil, fmt.Errorf("line %d: sizes and probabilities must not decrease", line)
+		}
+		d.sizes = append(d.sizes, size)
+		d.cdf = append(d.cdf, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(d.cdf) == 0 || d.cdf[len(d.cdf)-1] != 1 {
+		return nil, fmt.Errorf("the last point must have probability 1")
+	}
+	return d, nil
+}
+
+// draw returns a flow size
+func (d *flowSizes) draw(rng *rand.Rand) int64 {
+	u := rng.Float64()
+	i := sort.SearchFloat64s(d.cdf, u)
+	if i == 0 {
+		return int64(d.sizes[0])
+	}
+	lo, hi := d.cdf[i-1], d.cdf[i]
+	size := d.sizes[i]
+	if hi > lo {
+		size = d.sizes[i-1] + (d.sizes[i]-d.sizes[i-1])*(u-lo)/(hi-lo)
+	}
+	return int64(size)
+}
+
+// flowRecord is one flow of a short-flow workload
+type flowRecord struct {
+	ID             int     `json:"id"`
+	Size           int64   `json:"size_bytes"`
+	ArrivalSeconds float64 `json:"arrival_seconds"` // Since the workload started
+	WaitSeconds    float64 `json:"wait_seconds"`    // Queued until a pool connection was free
+	FCTSeconds     float64 `json:"fct_seconds"`     // From arrival to the server's answer
+	Slowdown       float64 `json:"slowdown,omitempty"`
+	Conn           int     `json:"conn,omitempty"` // Pool connection that carried the flow, counting from 1
+	Error          string  `json:"error,omitempty"`
+
+	arrival time.Time
+}
+
+// flowBucket summarizes the successful flows of a size range; the last bucket of
+// a summary covers all flows
+type flowBucket struct {
+	MinSize      int64   `json:"min_size_bytes"`           // Exclusive, except for the first bucket
+	MaxSize      int64   `json:"max_size_bytes,omitempty"` // Inclusive, 0 for no limit
+	Flows        int     `json:"flows"`
+	MeanFCTMs    float64 `json:"mean_fct_ms"`
+	P50FCTMs     float64 `json:"p50_fct_ms"`
+	P95FCTMs     float64 `json:"p95_fct_ms"`
+	P99FCTMs     float64 `json:"p99_fct_ms"`
+	MeanSlowdown float64 `json:"mean_slowdown"`
+	P50Slowdown  float64 `json:"p50_slowdown"`
+	P99Slowdown  float64 `json:"p99_slowdown"`
+}
+
+// flowsSummary is the outcome of a short-flow workload
+type flowsSummary struct {
+	CDF            string       `json:"cdf"`
+	Rate           float64      `json:"rate"` // Offered flow arrivals per second
+	Pool           int          `json:"pool"`
+	Seed           int64        `json:"seed"`
+	Flows          int          `json:"flows"`
+	Failed         int          `json:"failed,omitempty"`
+	OfferedLoadBps float64      `json:"offered_load_bps"` // Bytes of all flows over the arrival window
+	BaseRTTMs      float64      `json:"base_rtt_ms"`      // Lowest PING round trip on the pool connections
+	LinkRateBps    float64      `json:"link_rate_bps"`    // Bottleneck rate of the ideal transfer time
+	Buckets        []flowBucket `json:"buckets"`
+	Records        []flowRecord `json:"records,omitempty"` // Only in the saved summary
+}
+
+func (c *client) flowsCommand(args []string) *result {
+	res := &result{Op: "flows"}
+	fs := flag.NewFlagSet("flows", flag.ContinueOnError)
+	rate := fs.Float64("rate", 50, "Mean flow arrivals per second (Poisson)")
+	duration := fs.Duration("duration", 30*time.Second, "Arrival window; flows still running when it ends are completed")
+	count := fs.Int("count", 0, "Stop after this many flows (0 for no limit within -duration)")
+	pool := fs.Int("pool", 8, "Number of connections carrying the flows")
+	seed := fs.Int64("seed", 1, "Seed of the arrival times and flow sizes")
+	linkRate := fs.Float64("link-rate", 0, "Bottleneck rate in bytes/sec for the ideal transfer time (0 to use the highest delivery rate seen)")
+	bucketSpec := fs.String("buckets", "10KB,100KB,1MB,10MB", "Upper bounds of the flow size buckets")
+	args, ok := commandFlags(res, fs, args, 1, 1, "flows [-rate n] [-duration d] [-count n] [-pool n] [-seed n] [-link-rate bps] [-buckets list] <cdf file>")
+	if !ok {
+		return res
+	}
+
+	var bounds []int64
+	for _, part := range strings.Split(*bucketSpec, ",") {
+		bound, err := common.ParseSize(part)
+		if err != nil || bound <= 0 || len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			res.finish(nil, 0, failure(exitUsage, "invalid bucket bound %q (want increasing sizes)", part))
+			return res
+		}
+		bounds = append(bounds, bound)
+	}
+	if *rate <= 0 || *duration <= 0 || *count < 0 || *pool < 1 || *linkRate < 0 {
+		res.finish(nil, 0, failure(exitUsage, "flows needs a positive rate, duration and pool size"))
+		return res
+	}
+	sizes, err := loadFlowSizes(args[0])
+	if err != nil {
+		res.finish(nil, 0, failure(exitUsage, "invalid CDF %s: %w", args[0], err))
+		return res
+	}
+
+	summary := &flowsSummary{CDF: args[0], Rate: *rate, Pool: *pool, Seed: *seed, LinkRateBps: *linkRate}
+	return c.runFlows(sizes, summary, *duration, *count, bounds)
+}
+
+// runFlows runs a short-flow workload: flows arrive as a Poisson process with
+// sizes drawn from the CDF, queue for the next free connection of the pool and
+// are sent as PUTs of the synthetic payload, which the server verifies and drops.
+// Every pool connection writes a connection log, and the summary with every
+// flow is saved as a flows summary log. Flow completion times are bucketed by
+// size, with the slowdown against an ideal transfer of one base RTT plus the
+// size at the bottleneck rate.
+func (c *client) runFlows(sizes *flowSizes, summary *flowsSummary, duration time.Duration, count int, bounds []int64) *result {
+	res := &result{Op: "flows", File: summary.CDF}
+	start := time.Now()
+
+	// The arrivals are drawn up front, so a seed always gives the same workload
+	rng := rand.New(rand.NewSource(summary.Seed))
+	var flows []*flowRecord
+	var largest, total int64
+	for at := rng.ExpFloat64() / summary.Rate; at < duration.Seconds(); at += rng.ExpFloat64() / summary.Rate {
+		if count > 0 && len(flows) == count {
+			break
+		}
+		f := &flowRecord{ID: len(flows) + 1, Size: sizes.draw(rng), ArrivalSeconds: at}
+		largest = max(largest, f.Size)
+		total += f.Size
+		flows = append(flows, f)
+	}
+	summary.Flows = len(flows)
+	summary.OfferedLoadBps = float64(total) / duration.Seconds()
+	if len(flows) == 0 {
+		res.finish(nil, 0, failure(exitUsage, "no flow arrives within %v at %g flows/s", duration, summary.Rate))
+		return res
+	}
+
+	payload, restore := c.syntheticUploads()
+	defer restore()
+	payload.Size = largest
+	data := make([]byte, largest)
+	if _, err := io.ReadFull(payload.Reader(), data); err != nil {
+		res.finish(nil, 0, failure(exitUsage, "failed to generate payload: %w", err))
+		return res
+	}
+
+	workers := make([]*flowConn, summary.Pool)
+	for i := range workers {
+		w, err := c.openFlowConn(i + 1)
+		if err != nil {
+			for _, w := range workers[:i] {
+				w.close(c)
+			}
+			res.finish(nil, 0, err)
+			return res
+		}
+		workers[i] = w
+	}
+	baseRTT := workers[0].baseRTT
+	for _, w := range workers {
+		baseRTT = min(baseRTT, w.baseRTT)
+	}
+	summary.BaseRTTMs = float64(baseRTT) / float64(time.Millisecond)
+
+	queue := make(chan *flowRecord, len(flows))
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *flowConn) {
+			defer wg.Done()
+			w.run(queue, data)
+		}(w)
+	}
+	workloadStart := time.Now()
+	for _, f := range flows {
+		f.arrival = workloadStart.Add(time.Duration(f.ArrivalSeconds * float64(time.Second)))
+		time.Sleep(time.Until(f.arrival))
+		queue <- f
+	}
+	close(queue)
+	wg.Wait()
+
+	// Flows left behind when every connection failed
+	for f := range queue {
+		f.Error = "no pool connection left"
+	}
+
+	var samples []common.TCPInfo
+	for _, w := range workers {
+		samples = append(samples, w.close(c).TCPSamples...)
+	}
+	if summary.LinkRateBps == 0 {
+		for _, s := range samples {
+			summary.LinkRateBps = max(summary.LinkRateBps, float64(s.DeliveryRate))
+		}
+	}
+
+	var err error
+	var delivered int64
+	for _, f := range flows {
+		if f.Error != "" {
+			summary.Failed++
+			if err == nil {
+				err = failure(exitProtocol, "flow %d: %s", f.ID, f.Error)
+			}
+			continue
+		}
+		if summary.LinkRateBps > 0 {
+			ideal := baseRTT.Seconds() + float64(f.Size)/summary.LinkRateBps
+			f.Slowdown = f.FCTSeconds / ideal
+		}
+		delivered += f.Size
+	}
+	summary.Buckets = flowBuckets(flows, bounds)
+
+	for _, f := range flows {
+		summary.Records = append(summary.Records, *f)
+	}
+	res.Flows = summary
+	res.finish(nil, delivered, err)
+	res.DurationSeconds = time.Since(start).Seconds()
+	if err := c.logger.LogSummary("flows", strconv.FormatInt(start.Unix(), 10), start, res); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save flows summary: %v\n", err)
+	}
+
+	// The per-flow records only go to the saved summary
+	printed := *summary
+	printed.Records = nil
+	res.Flows = &printed
+	return res
+}
+
+// flowBuckets summarizes the successful flows per size bucket, followed by a
+// bucket of all flows
+func flowBuckets(flows []*flowRecord, bounds []int64) []flowBucket {
+	buckets := make([]flowBucket, len(bounds)+2)
+	fcts, slowdowns := make([][]float64, len(buckets)), make([][]float64, len(buckets))
+	for i := range buckets[:len(bounds)+1] {
+		if i > 0 {
+			buckets[i].MinSize = bounds[i-1]
+		}
+		if i < len(bounds) {
+			buckets[i].MaxSize = bounds[i]
+		}
+	}
+	all := len(buckets) - 1
+	for _, f := range flows {
+		if f.Error != "" {
+			continue
+		}
+		i := sort.Search(len(bounds), func(i int) bool { return f.Size <= bounds[i] })
+		for _, b := range []int{i, all} {
+			fcts[b] = append(fcts[b], f.FCTSeconds*1000)
+			slowdowns[b] = append(slowdowns[b], f.Slowdown)
+		}
+	}
+
+	var summarized []flowBucket
+	for i, b := range buckets {
+		if len(fcts[i]) == 0 && i != all {
+			continue
+		}
+		sort.Float64s(fcts[i])
+		sort.Float64s(slowdowns[i])
+		b.Flows = len(fcts[i])
+		b.MeanFCTMs, b.P50FCTMs, b.P95FCTMs, b.P99FCTMs = mean(fcts[i]), percentile(fcts[i], 50), percentile(fcts[i], 95), percentile(fcts[i], 99)
+		b.MeanSlowdown, b.P50Slowdown, b.P99Slowdown = mean(slowdowns[i]), percentile(slowdowns[i], 50), percentile(slowdowns[i], 99)
+		summarized = append(summarized, b)
+	}
+	return summarized
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// flowConn is a pool connection of a short-flow workload
+type flowConn struct {
+	id            int
+	conn          net.Conn
+	serverOptions map[string]string
+	connID        string
+	events        *slog.Logger
+	start         time.Time
+	baseRTT       time.Duration
+	collector     *common.TCPInfoCollector
+	stopSampling  func()
+	flows         int
+	bytesSent     int64
+	bytesReceived int64
+}
+
+// openFlowConn dials a pool connection and measures its base RTT with a few PINGs
+func (c *client) openFlowConn(id int) (*flowConn, error) {
+	w := &flowConn{id: id, start: time.Now()}
+	w.connID, w.events = c.newConnID("FLOWS")
+	w.events = w.events.With("pool_conn", id)
+
+	conn, serverOptions, err := c.dial()
+	if err != nil {
+		w.events.Error("failed to connect", "err", err)
+		return nil, err
+	}
+	w.conn, w.serverOptions = conn, serverOptions
+	w.collector = common.NewTCPInfoCollector()
+	w.collector.CollectSample(conn)
+	w.stopSampling = w.collector.StartSampling(conn, 100*time.Millisecond, nil)
+
+	for i := 0; i < 3; i++ {
+		payload := []byte(strconv.Itoa(i + 1))
+		sent := time.Now()
+		if err := protocol.WriteFrame(conn, protocol.CreatePingFrame(payload)); err != nil {
+			w.stopSampling()
+			conn.Close()
+			return nil, failure(exitProtocol, "failed to send PING: %w", err)
+		}
+		response, err := protocol.ReadFrame(conn)
+		if err != nil || response.OpCode != protocol.OpPing {
+			w.stopSampling()
+			conn.Close()
+			return nil, failure(exitProtocol, "PING on pool connection %d failed", id)
+		}
+		if rtt := time.Since(sent); w.baseRTT == 0 || rtt < w.baseRTT {
+			w.baseRTT = rtt
+		}
+		w.bytesSent += int64(5 + len(payload))
+		w.bytesReceived += int64(5 + len(response.Payload))
+	}
+	w.events.Info("connected", "local", conn.LocalAddr().String(), "base_rtt", w.baseRTT)
+	return w, nil
+}
+
+// run sends queued flows until the queue is closed or the connection fails
+func (w *flowConn) run(queue <-chan *flowRecord, data []byte) {
+	for f := range queue {
+		f.Conn = w.id
+		f.WaitSeconds = time.Since(f.arrival).Seconds()
+		name := fmt.Sprintf("flow-%d", f.ID)
+
+		// Header and data go out in one writev, so small flows fit a single segment
+		var header bytes.Buffer
+		protocol.WritePutHeader(&header, name, f.Size)
+		buffers := net.Buffers{header.Bytes(), data[:f.Size]}
+		if _, err := buffers.WriteTo(w.conn); err != nil {
+			f.Error = fmt.Sprintf("failed to send PUT: %v", err)
+			w.events.Error("flow failed", "flow", f.ID, "err", err)
+			return
+		}
+		response, err := protocol.ReadFrame(w.conn)
+		if err != nil {
+			f.Error = fmt.Sprintf("failed to read response: %v", err)
+			w.events.Error("flow failed", "flow", f.ID, "err", err)
+			return
+		}
+		f.FCTSeconds = time.Since(f.arrival).Seconds()
+		w.flows++
+		w.bytesSent += int64(header.Len()) + f.Size
+		w.bytesReceived += int64(5 + len(response.Payload))
+		if response.OpCode != protocol.OpPut {
+			f.Error = fmt.Sprintf("server error: %s", response.Payload)
+			w.events.Error("server error", "flow", f.ID, "message", string(response.Payload))
+			continue
+		}
+		w.events.Debug("flow complete", "flow", f.ID, "bytes", f.Size, "fct", f.FCTSeconds)
+	}
+}
+
+// close ends the pool connection with a QUIT and saves its connection log
+func (w *flowConn) close(c *client) *common.ConnectionLog {
+	if err := protocol.WriteFrame(w.conn, protocol.CreateQuitFrame()); err == nil {
+		protocol.ReadFrame(w.conn)
+	}
+	w.stopSampling()
+	w.collector.CollectSample(w.conn)
+
+	log := c.connectionLog(w.conn, w.serverOptions, w.connID, fmt.Sprintf("FLOWS %d", w.flows), w.start)
+	log.EndTime = time.Now()
+	log.BytesSent = w.bytesSent
+	log.BytesReceived = w.bytesReceived
+	log.TCPSamples = w.collector.GetSamples()
+	log.StreamID = w.id
+	w.conn.Close()
+	c.logger.LogConnection(log)
+	return log
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeCDF(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sizes.cdf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFlowSizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *flowSizes
+		wantErr string
+	}{
+		{
+			name:    "points and comments",
+			content: "# web search\n100 0.2\n\n  1000   0.7\n100000 1\n",
+			want:    &flowSizes{sizes: []float64{100, 1000, 100000}, cdf: []float64{0.2, 0.7, 1}},
+		},
+		{name: "single point", content: "1500 1\n", want: &flowSizes{sizes: []float64{1500}, cdf: []float64{1}}},
+		{name: "empty", content: "# nothing\n", wantErr: "last point"},
+		{name: "incomplete", content: "100 0.5\n1000 0.9\n", wantErr: "last point"},
+		{name: "decreasing size", content: "1000 0.5\n100 1\n", wantErr: "line 2: sizes and probabilities"},
+		{name: "decreasing probability", content: "100 0.5\n1000 0.4\n2000 1\n", wantErr: "line 2: sizes and probabilities"},
+		{name: "missing field", content: "100 0.5\n1000\n", wantErr: "line 2: want"},
+		{name: "bad size", content: "0 0.5\n", wantErr: "line 1: invalid size"},
+		{name: "bad probability", content: "100 1.5\n", wantErr: "line 1: invalid probability"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadFlowSizes(writeCDF(t, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadFlowSizes() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadFlowSizes() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadFlowSizes(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("loadFlowSizes() of a missing file succeeded")
+	}
+}
+
+func TestFlowSizesDraw(t *testing.T) {
+	// Half the flows are 100 bytes, the rest spread evenly up to 1100
+	d := &flowSizes{sizes: []float64{100, 1100}, cdf: []float64{0.5, 1}}
+	rng := rand.New(rand.NewSource(1))
+	const draws = 100000
+	var small, upperHalf int
+	for i := 0; i < draws; i++ {
+		size := d.draw(rng)
+		if size < 100 || size > 1100 {
+			t.Fatalf("drew %d, outside [100, 1100]", size)
+		}
+		if size == 100 {
+			small++
+		}
+		if size > 600 {
+			upperHalf++
+		}
+	}
+	if frac := float64(small) / draws; frac < 0.49 || frac > 0.51 {
+		t.Errorf("%.3f of the draws are the smallest size, want 0.5", frac)
+	}
+	if frac := float64(upperHalf) / draws; frac < 0.24 || frac > 0.26 {
+		t.Errorf("%.3f of the draws are above 600, want 0.25", frac)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{values, 0, 1},
+		{values, 10, 1},
+		{values, 11, 2},
+		{values, 50, 5},
+		{values, 95, 10},
+		{values, 99, 10},
+		{values, 100, 10},
+		{[]float64{42}, 50, 42},
+		{nil, 50, 0},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %g) = %g, want %g", tt.sorted, tt.p, got, tt.want)
+		}
+	}
+
+	if got := mean(values); got != 5.5 {
+		t.Errorf("mean(%v) = %g, want 5.5", values, got)
+	}
+	if got := mean(nil); got != 0 {
+		t.Errorf("mean(nil) = %g, want 0", got)
+	}
+}
+
+func TestFlowBuckets(t *testing.T) {
+	flows := []*flowRecord{
+		{Size: 500, FCTSeconds: 0.25, Slowdown: 1},
+		{Size: 1000, FCTSeconds: 0.5, Slowdown: 2},
+		{Size: 5000, FCTSeconds: 10, Error: "connection reset"},
+		{Size: 20000, FCTSeconds: 2, Slowdown: 4},
+	}
+	got := flowBuckets(flows, []int64{1000, 10000})
+	want := []flowBucket{
+		// Bounds are inclusive above; the empty middle bucket is left out
+		{MinSize: 0, MaxSize: 1000, Flows: 2, MeanFCTMs: 375, P50FCTMs: 250, P95FCTMs: 500, P99FCTMs: 500,
+			MeanSlowdown: 1.5, P50Slowdown: 1, P99Slowdown: 2},
+		{MinSize: 10000, MaxSize: 0, Flows: 1, MeanFCTMs: 2000, P50FCTMs: 2000, P95FCTMs: 2000, P99FCTMs: 2000,
+			MeanSlowdown: 4, P50Slowdown: 4, P99Slowdown: 4},
+		// All flows, errored ones excluded
+		{Flows: 3, MeanFCTMs: 2750.0 / 3, P50FCTMs: 500, P95FCTMs: 2000, P99FCTMs: 2000,
+			MeanSlowdown: 7.0 / 3, P50Slowdown: 2, P99Slowdown: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flowBuckets() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	// Without successful flows only the empty all-flows bucket remains
+	got = flowBuckets([]*flowRecord{{Size: 1, Error: "refused"}}, []int64{1000})
+	if want := []flowBucket{{}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("flowBuckets() of failed flows = %+v, want %+v", got, want)
+	}
+}
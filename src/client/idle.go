@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+// parseGaps parses a comma-separated list of idle gaps, e.g. 100ms,1s,5s
+func parseGaps(spec string) ([]time.Duration, error) {
+	var gaps []time.Duration
+	for _, part := range strings.Split(spec, ",") {
+		gap, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || gap < 0 {
+			return nil, fmt.Errorf("invalid idle gap %q", part)
+		}
+		gaps = append(gaps, gap)
+	}
+	return gaps, nil
+}
+
+// idle runs an idle experiment on one connection: a first burst opens the cwnd,
+// then for every gap the connection stays quiet for that long before the next
+// burst. Each gap records the cwnd once the previous burst was acknowledged and
+// right after the first bytes of the next one went out, when the kernel restarts
+// slow start if tcp_slow_start_after_idle is set and the gap exceeded the RTO.
+// Bursts are DATA frames ended by an empty one, which the server reads, drops and
+// answers, so a burst is fully delivered before its gap begins. The connection
+// log is returned, and saved, whenever the server answered.
+func (c *client) idle(gaps []time.Duration, burst int64, chunk int) (*common.ConnectionLog, error) {
+	startTime := time.Now()
+	connID, events := c.newConnID("IDLE")
+
+	conn, serverOptions, err := c.open()
+	if err != nil {
+		events.Error("failed to connect", "err", err)
+		return nil, err
+	}
+	defer c.release(conn)
+	events.Info("connected", "local", conn.LocalAddr().String())
+
+	tcpCollector := common.NewTCPInfoCollector()
+	tcpCollector.CollectSample(conn)
+	stopSampling := tcpCollector.StartSampling(conn, 100*time.Millisecond, nil)
+	defer stopSampling()
+
+	log := c.connectionLog(conn, serverOptions, connID, "IDLE", startTime)
+	if on, err := common.SlowStartAfterIdle(); err != nil {
+		events.Warn("failed to read slow start after idle setting", "err", err)
+	} else {
+		log.SlowStartAfterIdle = &on
+	}
+	save := func() {
+		stopSampling()
+		tcpCollector.CollectSample(conn)
+		log.EndTime = time.Now()
+		log.TCPSamples = tcpCollector.GetSamples()
+		c.logger.LogConnection(log)
+	}
+
+	// sample adds a TCP_INFO sample to the log and returns it
+	sample := func() common.TCPInfo {
+		tcpCollector.CollectSample(conn)
+		if latest := tcpCollector.Latest(); latest != nil {
+			return *latest
+		}
+		return common.TCPInfo{}
+	}
+
+	buf := make([]byte, 5+chunk)
+	buf[0] = protocol.OpData
+	// sendBurst sends one burst and returns the sample taken after its first
+	// bytes and the time until the server answered
+	sendBurst := func() (common.TCPInfo, time.Duration, error) {
+		var first common.TCPInfo
+		start := time.Now()
+		for sent := int64(0); sent < burst; {
+			n := int(min(int64(chunk), burst-sent))
+			binary.BigEndian.PutUint32(buf[1:5], uint32(n))
+			frame := buf[:5+n]
+			if sent == 0 {
+				// The frame header alone ends the gap, so the sample catches the
+				// cwnd the kernel restarted from before the burst's ACKs grow it
+				if _, err := conn.Write(frame[:5]); err != nil {
+					return first, 0, failure(exitProtocol, "failed to send DATA: %w", err)
+				}
+				first = sample()
+				frame = frame[5:]
+			}
+			if _, err := conn.Write(frame); err != nil {
+				return first, 0, failure(exitProtocol, "failed to send DATA: %w", err)
+			}
+			sent += int64(n)
+			log.BytesSent += int64(5 + n)
+		}
+		if err := protocol.WriteFrame(conn, &protocol.Frame{OpCode: protocol.OpData}); err != nil {
+			return first, 0, failure(exitProtocol, "failed to send final DATA: %w", err)
+		}
+		log.BytesSent += 5
+		response, err := protocol.ReadFrame(conn)
+		if err != nil {
+			return first, 0, failure(exitProtocol, "failed to read response: %w", err)
+		}
+		log.BytesReceived += int64(5 + len(response.Payload))
+		switch response.OpCode {
+		case protocol.OpSend:
+			return first, time.Since(start), nil
+		case protocol.OpError:
+			return first, 0, failure(exitServer, "server error: %s", response.Payload)
+		default:
+			return first, 0, failure(exitProtocol, "unexpected response opcode %d", response.OpCode)
+		}
+	}
+
+	if _, _, err := sendBurst(); err != nil {
+		events.Error("first burst failed", "err", err)
+		save()
+		return log, err
+	}
+	for _, gap := range gaps {
+		before := sample()
+		time.Sleep(gap)
+		first, elapsed, err := sendBurst()
+		if err != nil {
+			events.Error("burst failed", "gap", gap, "err", err)
+			save()
+			return log, err
+		}
+		end := sample()
+
+		g := common.IdleGap{
+			Gap:           gap.Seconds(),
+			RTOMs:         float64(before.RTO) / 1000.0,
+			CwndBefore:    before.SndCwnd,
+			CwndAfter:     first.SndCwnd,
+			SsthreshAfter: first.SndSsthresh,
+			CwndEnd:       end.SndCwnd,
+			BurstBytes:    burst,
+			BurstDuration: elapsed.Seconds(),
+		}
+		if elapsed > 0 {
+			g.BurstThroughput = float64(burst) / elapsed.Seconds()
+		}
+		log.IdleGaps = append(log.IdleGaps, g)
+		events.Info("idle gap", "gap", gap, "rto_ms", g.RTOMs, "cwnd_before", g.CwndBefore, "cwnd_after", g.CwndAfter)
+	}
+
+	save()
+	events.Info("idle experiment complete", "gaps", len(gaps), "duration", log.EndTime.Sub(startTime))
+	return log, nil
+}
+
+// runIdle runs an idle experiment with bursts of burst bytes around each gap
+func (c *client) runIdle(gaps []time.Duration, burst int64, chunk int) *result {
+	res := &result{Op: "idle"}
+	if len(gaps) == 0 || burst <= 0 || chunk <= 0 {
+		res.finish(nil, 0, failure(exitUsage, "idle needs at least one gap and a positive burst and chunk size"))
+		return res
+	}
+
+	log, err := c.idle(gaps, burst, chunk)
+	c.settle(err)
+	res.finish(log, burst*int64(len(gaps)+1), err)
+	if log != nil {
+		res.IdleGaps = log.IdleGaps
+		if log.SlowStartAfterIdle != nil {
+			res.Message = fmt.Sprintf("tcp_slow_start_after_idle=%t", *log.SlowStartAfterIdle)
+		}
+	}
+	return res
+}
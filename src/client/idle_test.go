@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseGaps(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []time.Duration
+		wantErr bool
+	}{
+		{spec: "1s", want: []time.Duration{time.Second}},
+		{spec: "100ms, 1s,5s", want: []time.Duration{100 * time.Millisecond, time.Second, 5 * time.Second}},
+		{spec: "0s", want: []time.Duration{0}},
+		{spec: "", wantErr: true},
+		{spec: "1s,", wantErr: true},
+		{spec: "-1s", wantErr: true},
+		{spec: "1s,x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseGaps(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseGaps(%q) = %v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseGaps(%q) = %v, %v, want %v", tt.spec, got, err, tt.want)
+		}
+	}
+}
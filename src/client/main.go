@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
-	"strings"
+	"strconv"
 	"time"
 
 	"tcp-congestion-benchmark/src/common"
@@ -18,176 +18,419 @@ func main() {
 	host := flag.String("host", "localhost", "Server host")
 	port := flag.String("port", "8080", "Server port")
 	logDir := flag.String("log-dir", "./logs", "Log directory")
+	serverCC := flag.String("server-cc", "", "TCP congestion control algorithm requested for the server side of each connection")
+	serverReadThrottle := flag.String("server-read-throttle", "", "Slow-reader spec requested from the server, e.g. rate=1048576,pause=1s/200ms")
+	serverPacing := flag.String("server-pacing", "", "Download rate limit requested from the server, e.g. rate=1048576,step=10s:524288")
+	namespace := flag.String("namespace", "", "Server storage namespace for this run (e.g. the scenario name)")
+	pacingSpec := flag.String("pacing", "", "Application-level upload rate limit, e.g. rate=1048576,burst=65536,step=10s:524288")
+	streams := flag.Int("P", 1, "Number of parallel streams (connections) per upload")
+	streamMode := flag.String("stream-mode", streamShare, "With -P: share (each stream sends a slice of the file) or copy (each stream sends the whole file)")
+	persistent := flag.Bool("persistent", false, "Reuse one connection for all operations of a shell, script or bench session instead of one per operation")
+	zeroCopy := flag.Bool("zero-copy", false, "Send files with sendfile(2) instead of reading them into memory, and splice(2) downloads into files")
+	report := flag.String("report", "", "Print live interval reports of every transfer on stdout: text or json (JSON lines)")
+	reportInterval := flag.Duration("report-interval", time.Second, "Interval of the -report lines")
+	payloadSpec := flag.String("payload", "", "Upload synthetic data from a seeded PRNG instead of files, e.g. random,seed=42,size=200MB, zeros,size=1GB or text:6,size=50MB; the server verifies it instead of storing it")
+	sockFlags := common.RegisterSocketFlags(flag.CommandLine)
+	logFlags := common.RegisterEventLogFlags(flag.CommandLine)
+	configFlags := common.RegisterConfigFlags(flag.CommandLine)
+	flag.Usage = usage
 	flag.Parse()
 
+	if err := configFlags.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
 	logger := common.NewLogger(*logDir)
-	address := fmt.Sprintf("%s:%s", *host, *port)
+	logger.SetMetadata(configFlags.Metadata())
+	logger.SetConfig(configFlags.Effective())
+	closeEventLog, err := logFlags.Setup("client", logger.Scenario())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid event log: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeEventLog()
 
-	fmt.Printf("TCP File Transfer Client\n")
-	fmt.Printf("Server: %s\n", address)
-	fmt.Printf("Commands: list, put <filename>, quit\n\n")
+	profile, err := sockFlags.Resolve()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid socket profile: %v\n", err)
+		os.Exit(1)
+	}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Print("> ")
-		if !scanner.Scan() {
-			break
+	pacing, err := common.ParsePacing(*pacingSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid pacing: %v\n", err)
+		os.Exit(1)
+	}
+	if *zeroCopy && pacing.Enabled() {
+		fmt.Fprintf(os.Stderr, "Invalid flags: -zero-copy cannot be combined with -pacing\n")
+		os.Exit(1)
+	}
+	var payload *common.Payload
+	if *payloadSpec != "" {
+		if payload, err = common.ParsePayload(*payloadSpec); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid payload: %v\n", err)
+			os.Exit(1)
 		}
-
-		command := strings.TrimSpace(scanner.Text())
-		if command == "" {
-			continue
+		if *zeroCopy {
+			fmt.Fprintf(os.Stderr, "Invalid flags: -zero-copy cannot be combined with -payload\n")
+			os.Exit(1)
 		}
+	}
+	if *report != "" && *report != reportText && *report != reportJSON || *reportInterval <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid flags: -report must be %s or %s and -report-interval positive\n", reportText, reportJSON)
+		os.Exit(1)
+	}
+	if *streams < 1 || *streamMode != streamShare && *streamMode != streamCopy {
+		fmt.Fprintf(os.Stderr, "Invalid flags: -P must be at least 1 and -stream-mode %s or %s\n", streamShare, streamCopy)
+		os.Exit(1)
+	}
 
-		parts := strings.Fields(command)
-		switch parts[0] {
-		case "list":
-			handleList(address, logger)
-		case "put":
-			if len(parts) < 2 {
-				fmt.Println("Usage: put <filename>")
-				continue
-			}
-			handlePut(address, parts[1], logger)
-		case "quit":
-			fmt.Println("Goodbye!")
-			return
-		default:
-			fmt.Printf("Unknown command: %s\n", parts[0])
+	address := fmt.Sprintf("%s:%s", *host, *port)
+	c := &client{
+		address:    address,
+		logger:     logger,
+		profile:    profile,
+		pacing:     pacing,
+		zeroCopy:   *zeroCopy,
+		payload:    payload,
+		persistent: *persistent,
+		streams:    *streams,
+		streamMode: *streamMode,
+	}
+	if *report != "" {
+		c.reports = &intervalReports{format: *report, interval: *reportInterval}
+	}
+	if *serverCC != "" {
+		c.options = append(c.options, serverOption{protocol.OptCongestionControl, *serverCC})
+	}
+	if *namespace != "" {
+		c.options = append(c.options, serverOption{protocol.OptNamespace, *namespace})
+	}
+	if *serverReadThrottle != "" {
+		c.options = append(c.options, serverOption{protocol.OptReadThrottle, *serverReadThrottle})
+	}
+	if *serverPacing != "" {
+		c.options = append(c.options, serverOption{protocol.OptPacing, *serverPacing})
+	}
+	if payload != nil {
+		c.options = append(c.options, serverOption{protocol.OptPayload, payload.String()})
+	}
+
+	// Without a command the client runs the interactive shell, as it always did
+	command, args := "shell", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	code := c.run(command, args)
+	c.hangUp()
+	closeEventLog()
+	os.Exit(code)
+}
+
+// client holds the connection settings shared by all commands
+type client struct {
+	address  string
+	logger   *common.Logger
+	profile  *common.SocketProfile
+	options  []serverOption  // Connection options negotiated with the server on every dial
+	connID   int             // Last connection id, each command uses a connection of its own
+	pacing   *common.Pacing  // Application-level rate limit for uploads
+	zeroCopy bool            // Send files with sendfile(2)
+	payload  *common.Payload // Synthetic upload data used instead of files, nil to read files
+
+	sampleAlways bool             // Sample TCP_INFO during every upload, not only those over 1 MB
+	reports      *intervalReports // Live interval reports on stdout, nil without -report
+
+	streams    int    // Parallel connections per upload
+	streamMode string // How parallel streams divide the file: streamShare or streamCopy
+
+	persistent bool            // Reuse one connection across operations
+	session    *persistentConn // The reused connection, nil until the first operation
+
+	sessionID string // Script session the next connections belong to, empty outside scripts
+	step      int    // Script step the next connections belong to
+}
+
+// serverOption is a connection option sent to the server in an OPTION frame
+type serverOption struct {
+	key   string
+	value string
+}
+
+// newConnID numbers the next connection and returns it with an event logger
+// carrying the connection attributes
+func (c *client) newConnID(op string) (string, *slog.Logger) {
+	c.connID++
+	connID := strconv.Itoa(c.connID)
+	return connID, slog.With("conn_id", connID, "remote", c.address, "op", op)
+}
+
+// dial connects to the server, applies the socket profile and negotiates the
+// connection options. It returns the effective option values reported by the server.
+func (c *client) dial() (net.Conn, map[string]string, error) {
+	dialer := net.Dialer{Control: c.profile.Control}
+	conn, err := dialer.Dial("tcp", c.address)
+	if err != nil {
+		return nil, nil, failure(exitConnect, "failed to connect: %w", err)
+	}
+
+	if err := c.profile.ApplyConn(conn); err != nil {
+		conn.Close()
+		return nil, nil, failure(exitConnect, "failed to apply socket profile: %w", err)
+	}
+
+	effective := make(map[string]string)
+	for _, opt := range c.options {
+		if err := protocol.WriteFrame(conn, protocol.CreateOptionFrame(opt.key, opt.value)); err != nil {
+			conn.Close()
+			return nil, nil, failure(exitProtocol, "failed to send OPTION: %w", err)
 		}
+		response, err := protocol.ReadFrame(conn)
+		if err != nil {
+			conn.Close()
+			return nil, nil, failure(exitProtocol, "failed to read OPTION response: %w", err)
+		}
+		if response.OpCode == protocol.OpError {
+			conn.Close()
+			return nil, nil, failure(exitServer, "server rejected option %s=%q: %s", opt.key, opt.value, string(response.Payload))
+		}
+		key, value, err := protocol.ParseOptionFrame(response)
+		if err != nil {
+			conn.Close()
+			return nil, nil, failure(exitProtocol, "%w", err)
+		}
+		effective[key] = value
+	}
+
+	return conn, effective, nil
+}
+
+// connectionLog starts the connection log of an operation with the negotiated settings
+func (c *client) connectionLog(conn net.Conn, serverOptions map[string]string, connID, operation string, startTime time.Time) *common.ConnectionLog {
+	log := &common.ConnectionLog{
+		ConnID:     connID,
+		StartTime:  startTime,
+		RemoteAddr: c.address,
+		Operation:  operation,
+		SessionID:  c.sessionID,
+		Step:       c.step,
+	}
+	log.CongestionControl, _ = common.GetCongestionControl(conn)
+	log.PeerCongestionControl = serverOptions[protocol.OptCongestionControl]
+	log.ReadThrottle = serverOptions[protocol.OptReadThrottle]
+	log.Namespace = serverOptions[protocol.OptNamespace]
+	log.Payload = serverOptions[protocol.OptPayload]
+	if c.session != nil && conn == c.session.conn && c.session.ops > 1 {
+		log.PersistentConn = c.session.connID
+		log.ConnReuse = c.session.ops - 1
 	}
+	log.SocketProfile = c.profile.Name
+	log.SocketOptions, _ = common.ReadSocketProfile(conn)
+	return log
 }
 
-func handleList(address string, logger *common.Logger) {
+// list fetches the file listing. The connection log is returned, and saved,
+// whenever the server answered, even with an error.
+func (c *client) list() (*common.ConnectionLog, string, error) {
 	startTime := time.Now()
+	connID, events := c.newConnID("LIST")
 
-	conn, err := net.Dial("tcp", address)
+	conn, serverOptions, err := c.open()
 	if err != nil {
-		fmt.Printf("Failed to connect: %v\n", err)
-		return
+		events.Error("failed to connect", "err", err)
+		return nil, "", err
 	}
-	defer conn.Close()
+	defer c.release(conn)
+	events.Info("connected", "local", conn.LocalAddr().String())
 
 	// Send LIST frame
 	frame := protocol.CreateListFrame()
 	if err := protocol.WriteFrame(conn, frame); err != nil {
-		fmt.Printf("Failed to send LIST: %v\n", err)
-		return
+		events.Error("failed to send LIST", "err", err)
+		return nil, "", failure(exitProtocol, "failed to send LIST: %w", err)
 	}
 
 	// Read response
 	response, err := protocol.ReadFrame(conn)
 	if err != nil {
-		fmt.Printf("Failed to read response: %v\n", err)
-		return
+		events.Error("failed to read response", "err", err)
+		return nil, "", failure(exitProtocol, "failed to read response: %w", err)
 	}
 
-	endTime := time.Now()
+	// Log connection
+	log := c.connectionLog(conn, serverOptions, connID, "LIST", startTime)
+	log.EndTime = time.Now()
+	log.BytesSent = 5 // opcode + payload length
+	log.BytesReceived = int64(5 + len(response.Payload))
+	c.logger.LogConnection(log)
+
+	switch response.OpCode {
+	case protocol.OpError:
+		events.Error("server error", "message", string(response.Payload))
+		return log, "", failure(exitServer, "server error: %s", response.Payload)
+	case protocol.OpList:
+		return log, string(response.Payload), nil
+	default:
+		return log, "", failure(exitProtocol, "unexpected response opcode %d", response.OpCode)
+	}
+}
 
-	if response.OpCode == protocol.OpError {
-		fmt.Printf("Server error: %s\n", string(response.Payload))
-	} else {
-		fmt.Printf("Files on server:\n%s\n", string(response.Payload))
+// put uploads a local file under the remote name and returns the server's message.
+// With more than one stream it goes through putParallel. The connection log is
+// returned, and saved, whenever the server answered.
+func (c *client) put(filename, remote string) (*common.ConnectionLog, string, error) {
+	if c.streams > 1 {
+		return c.putParallel(filename, remote)
 	}
+	connID, events := c.newConnID("PUT")
+	return c.putRange(filename, 0, -1, remote, connID, events.With("file", filename), 0)
+}
 
-	// Log connection
-	log := &common.ConnectionLog{
-		StartTime:     startTime,
-		EndTime:       endTime,
-		BytesSent:     5, // opcode + payload length
-		BytesReceived: int64(5 + len(response.Payload)),
-		RemoteAddr:    address,
-		Operation:     "LIST",
-	}
-	logger.LogConnection(log)
-	logger.PrintSummary(log)
+// uploadSize returns how many bytes an upload of filename sends: the size of the
+// synthetic payload with -payload, the size of the file otherwise
+func (c *client) uploadSize(filename string) (int64, error) {
+	if c.payload != nil {
+		return c.payload.Size, nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// syntheticUploads switches uploads to a synthetic payload, the one of -payload
+// or zeros, and makes sure the server verifies and drops them instead of storing
+// them. The returned function restores the previous settings.
+func (c *client) syntheticUploads() (common.Payload, func()) {
+	payload := common.Payload{Pattern: common.PatternZeros, Seed: 1}
+	saved, savedOptions := c.payload, c.options
+	if c.payload != nil {
+		payload = *c.payload
+	} else {
+		c.options = append(c.options[:len(c.options):len(c.options)], serverOption{protocol.OptPayload, payload.String()})
+	}
+	return payload, func() {
+		c.payload, c.options = saved, savedOptions
+	}
 }
 
-func handlePut(address string, filename string, logger *common.Logger) {
+// putRange uploads length bytes of a local file from offset, or the rest of the
+// file if length is negative, in one PUT of its own. With -payload the data is
+// generated instead, always from the start of the payload so that the server can
+// verify every PUT on its own. stream numbers the connection within a parallel
+// upload, 0 outside one.
+func (c *client) putRange(filename string, offset, length int64, remote, connID string, events *slog.Logger, stream int) (*common.ConnectionLog, string, error) {
 	startTime := time.Now()
 
-	// Open file for streaming
-	f, err := os.Open(filename)
-	if err != nil {
-		fmt.Printf("Failed to open file %s: %v\n", filename, err)
-		return
+	var f io.Reader
+	filesize := length
+	if c.payload != nil {
+		if c.payload.Size <= 0 {
+			events.Error("payload has no size")
+			return nil, "", failure(exitUsage, "-payload needs a size for uploads, e.g. %s,size=200MB", c.payload.Pattern)
+		}
+		f = c.payload.Reader()
+		if filesize < 0 {
+			filesize = c.payload.Size
+		}
+	} else {
+		// Open file for streaming
+		file, err := os.Open(filename)
+		if err != nil {
+			events.Error("failed to open file", "err", err)
+			return nil, "", failure(exitUsage, "failed to open file: %w", err)
+		}
+		defer file.Close()
+
+		fi, err := file.Stat()
+		if err != nil {
+			events.Error("failed to stat file", "err", err)
+			return nil, "", failure(exitUsage, "failed to stat file: %w", err)
+		}
+		if filesize < 0 {
+			filesize = fi.Size() - offset
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			events.Error("failed to seek file", "err", err)
+			return nil, "", failure(exitUsage, "failed to seek file: %w", err)
+		}
+		f = file
 	}
-	defer f.Close()
 
-	fi, err := f.Stat()
-	if err != nil {
-		fmt.Printf("Failed to stat file %s: %v\n", filename, err)
-		return
+	// Validate payload fits in uint32
+	if filesize > int64(^uint32(0))-int64(4+len(remote)) {
+		events.Error("file is too large to send", "bytes", filesize)
+		return nil, "", failure(exitUsage, "file is too large to send (%d bytes)", filesize)
 	}
-	filesize := fi.Size()
+	payloadLen := uint32(4 + len(remote) + int(filesize))
 
-	conn, err := net.Dial("tcp", address)
+	// Parallel streams always need connections of their own
+	open := c.open
+	if stream > 0 {
+		open = c.dial
+	}
+	conn, serverOptions, err := open()
 	if err != nil {
-		fmt.Printf("Failed to connect: %v\n", err)
-		return
+		events.Error("failed to connect", "err", err)
+		return nil, "", err
 	}
-	defer conn.Close()
+	defer c.release(conn)
+	events.Info("connected", "local", conn.LocalAddr().String())
 
 	// Initialize TCP_INFO collector
 	tcpCollector := common.NewTCPInfoCollector()
 	tcpCollector.CollectSample(conn)
-
-	filenameBytes := []byte(filename)
-	shouldSample := filesize > 1024*1024
-	done := make(chan bool)
-
-	// Validate payload fits in uint32
-	if filesize > int64(^uint32(0))-int64(4+len(filenameBytes)) {
-		fmt.Printf("File %s is too large to send\n", filename)
-		if shouldSample {
-			close(done)
-		}
-		return
-	}
-	payloadLen := uint32(4 + len(filenameBytes) + int(filesize))
+	reporter := c.reporter(connID, stream, startTime, bytesAcked, tcpCollector.Latest())
+	shouldSample := filesize > 1024*1024 || c.pacing.Enabled() || c.sampleAlways || reporter != nil
 
 	// Start sampling goroutine for large files
+	stopSampling := func() {}
 	if shouldSample {
-		go func() {
-			ticker := time.NewTicker(100 * time.Millisecond) // Sample every 100ms
-			defer ticker.Stop()
-
-			for {
-				select {
-				case <-ticker.C:
-					tcpCollector.CollectSample(conn)
-				case <-done:
-					return
-				}
-			}
-		}()
+		stopSampling = tcpCollector.StartSampling(conn, 100*time.Millisecond, reporter.Sample) // Sample every 100ms
 	}
-
-	// Read entire file into memory
-	fileData := make([]byte, filesize)
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		fmt.Printf("Failed to seek file: %v\n", err)
-		if shouldSample {
-			close(done)
+	defer stopSampling()
+
+	var pacer *common.PacedWriter
+	cpu := common.StartCPUTimer()
+	defer cpu.Stop()
+	switch {
+	case c.pacing.Enabled():
+		// Stream the file through the token bucket
+		pacer = c.pacing.Writer(conn)
+		if err := protocol.WritePutHeader(conn, remote, filesize); err != nil {
+			events.Error("failed to send PUT header", "err", err)
+			return nil, "", failure(exitProtocol, "failed to send PUT header: %w", err)
 		}
-		return
-	}
-	if _, err := io.ReadFull(f, fileData); err != nil {
-		fmt.Printf("Failed to read file into memory: %v\n", err)
-		if shouldSample {
-			close(done)
+		if _, err := io.CopyN(pacer, f, filesize); err != nil {
+			events.Error("failed to send file", "err", err)
+			return nil, "", failure(exitProtocol, "failed to send file: %w", err)
+		}
+	case c.zeroCopy:
+		// Copying from the file straight to the socket uses sendfile(2)
+		if err := protocol.WritePutHeader(conn, remote, filesize); err != nil {
+			events.Error("failed to send PUT header", "err", err)
+			return nil, "", failure(exitProtocol, "failed to send PUT header: %w", err)
+		}
+		if _, err := io.CopyN(conn, f, filesize); err != nil {
+			events.Error("failed to send file", "err", err)
+			return nil, "", failure(exitProtocol, "failed to send file: %w", err)
+		}
+	default:
+		// Read entire file into memory
+		fileData := make([]byte, filesize)
+		if _, err := io.ReadFull(f, fileData); err != nil {
+			events.Error("failed to read file into memory", "err", err)
+			return nil, "", failure(exitUsage, "failed to read file: %w", err)
 		}
-		return
-	}
 
-	// Create and send PUT frame in a single transfer
-	frame := protocol.CreatePutFrame(filename, fileData)
-	if err := protocol.WriteFrame(conn, frame); err != nil {
-		fmt.Printf("Failed to send PUT frame: %v\n", err)
-		if shouldSample {
-			close(done)
+		// Create and send PUT frame in a single transfer
+		frame := protocol.CreatePutFrame(remote, fileData)
+		if err := protocol.WriteFrame(conn, frame); err != nil {
+			events.Error("failed to send PUT frame", "err", err)
+			return nil, "", failure(exitProtocol, "failed to send PUT frame: %w", err)
 		}
-		return
 	}
 
 	// Collect sample after sending
@@ -196,39 +439,201 @@ func handlePut(address string, filename string, logger *common.Logger) {
 	// Read response
 	response, err := protocol.ReadFrame(conn)
 	if err != nil {
-		fmt.Printf("Failed to read response: %v\n", err)
-		if shouldSample {
-			close(done)
-		}
-		return
+		events.Error("failed to read response", "err", err)
+		return nil, "", failure(exitProtocol, "failed to read response: %w", err)
 	}
 
 	// Stop sampling goroutine
-	if shouldSample {
-		close(done)
-	}
+	stopSampling()
+	cpuUser, cpuSystem := cpu.Stop()
 
 	// Collect final sample
 	tcpCollector.CollectSample(conn)
 
 	endTime := time.Now()
 
-	if response.OpCode == protocol.OpError {
-		fmt.Printf("Server error: %s\n", string(response.Payload))
-	} else {
-		fmt.Printf("File %s uploaded successfully\n", filename)
+	// Log connection with TCP_INFO samples
+	log := c.connectionLog(conn, serverOptions, connID, fmt.Sprintf("PUT %s", remote), startTime)
+	log.EndTime = endTime
+	log.BytesSent = int64(5) + int64(payloadLen) // opcode + length (5) + payload
+	log.BytesReceived = int64(5) + int64(len(response.Payload))
+	log.TCPSamples = tcpCollector.GetSamples()
+	log.Intervals = reporter.Finish(tcpCollector.Latest())
+	log.StreamID = stream
+	log.ZeroCopy = c.zeroCopy
+	log.CPUUserMs = float64(cpuUser) / float64(time.Millisecond)
+	log.CPUSystemMs = float64(cpuSystem) / float64(time.Millisecond)
+	if pacer != nil {
+		log.Pacing = c.pacing.String()
+		log.PacingWaitMs = float64(pacer.Waited()) / float64(time.Millisecond)
 	}
+	c.logger.LogConnection(log)
+
+	switch response.OpCode {
+	case protocol.OpError:
+		events.Error("server error", "message", string(response.Payload))
+		return log, "", failure(exitServer, "server error: %s", response.Payload)
+	case protocol.OpPut:
+		events.Info("upload complete", "bytes", filesize, "duration", endTime.Sub(startTime))
+		return log, string(response.Payload), nil
+	default:
+		return log, "", failure(exitProtocol, "unexpected response opcode %d", response.OpCode)
+	}
+}
 
-	// Log connection with TCP_INFO samples
-	log := &common.ConnectionLog{
-		StartTime:     startTime,
-		EndTime:       endTime,
-		BytesSent:     int64(5) + int64(payloadLen), // opcode + length (5) + payload
-		BytesReceived: int64(5) + int64(len(response.Payload)),
-		RemoteAddr:    address,
-		Operation:     fmt.Sprintf("PUT %s", filename),
-		TCPSamples:    tcpCollector.GetSamples(),
-	}
-	logger.LogConnection(log)
-	logger.PrintSummary(log)
+// get downloads a remote file into a local one and returns the bytes received.
+// The local file is only created once the server started sending the file, and
+// removed again if the transfer fails. The connection log is returned, and saved,
+// whenever the server answered.
+func (c *client) get(remote, filename string) (*common.ConnectionLog, int64, error) {
+	startTime := time.Now()
+	connID, events := c.newConnID("GET")
+	events = events.With("file", remote)
+
+	conn, serverOptions, err := c.open()
+	if err != nil {
+		events.Error("failed to connect", "err", err)
+		return nil, 0, err
+	}
+	defer c.release(conn)
+	events.Info("connected", "local", conn.LocalAddr().String())
+
+	tcpCollector := common.NewTCPInfoCollector()
+	tcpCollector.CollectSample(conn)
+
+	frame := protocol.CreateGetFrame(remote)
+	if err := protocol.WriteFrame(conn, frame); err != nil {
+		events.Error("failed to send GET", "err", err)
+		return nil, 0, failure(exitProtocol, "failed to send GET: %w", err)
+	}
+
+	// The file follows the frame header
+	response, err := protocol.ReadFrameHeader(conn)
+	if err != nil {
+		events.Error("failed to read response", "err", err)
+		return nil, 0, failure(exitProtocol, "failed to read response: %w", err)
+	}
+	log := c.connectionLog(conn, serverOptions, connID, fmt.Sprintf("GET %s", remote), startTime)
+	log.BytesSent = int64(5 + len(frame.Payload))
+	log.BytesReceived = 5
+
+	if response.OpCode != protocol.OpGet {
+		if err := protocol.ReadFramePayload(conn, response); err != nil {
+			events.Error("failed to read response", "err", err)
+			return nil, 0, failure(exitProtocol, "failed to read response: %w", err)
+		}
+		log.EndTime = time.Now()
+		log.BytesReceived += int64(len(response.Payload))
+		c.logger.LogConnection(log)
+		if response.OpCode == protocol.OpError {
+			events.Error("server error", "message", string(response.Payload))
+			return log, 0, failure(exitServer, "server error: %s", response.Payload)
+		}
+		return log, 0, failure(exitProtocol, "unexpected response opcode %d", response.OpCode)
+	}
+	size := int64(response.PayloadLen)
+
+	f, err := os.Create(filename)
+	if err != nil {
+		events.Error("failed to create file", "err", err)
+		return nil, 0, failure(exitUsage, "failed to create file: %w", err)
+	}
+
+	reporter := c.reporter(connID, 0, startTime, bytesReceived, tcpCollector.Latest())
+	stopSampling := func() {}
+	if size > 1024*1024 || reporter != nil {
+		stopSampling = tcpCollector.StartSampling(conn, 100*time.Millisecond, reporter.Sample)
+	}
+	defer stopSampling()
+
+	// Copying from the socket straight to the file uses splice(2); hiding the
+	// file's ReadFrom forces the copy through a user-space buffer
+	var dst io.Writer = struct{ io.Writer }{f}
+	if c.zeroCopy {
+		dst = f
+	}
+	cpu := common.StartCPUTimer()
+	received, err := io.CopyN(dst, conn, size)
+	cpuUser, cpuSystem := cpu.Stop()
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		os.Remove(filename)
+		events.Error("failed to write file", "err", closeErr)
+		return nil, received, failure(exitUsage, "failed to write file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(filename)
+		events.Error("failed to receive file", "err", err, "bytes", received)
+		return nil, received, failure(exitProtocol, "failed to receive file: %w", err)
+	}
+
+	stopSampling()
+	tcpCollector.CollectSample(conn)
+	log.EndTime = time.Now()
+	log.BytesReceived += received
+	log.TCPSamples = tcpCollector.GetSamples()
+	log.Intervals = reporter.Finish(tcpCollector.Latest())
+	log.ZeroCopy = c.zeroCopy
+	log.CPUUserMs = float64(cpuUser) / float64(time.Millisecond)
+	log.CPUSystemMs = float64(cpuSystem) / float64(time.Millisecond)
+	c.logger.LogConnection(log)
+
+	events.Info("download complete", "bytes", received, "duration", log.EndTime.Sub(startTime))
+	return log, received, nil
+}
+
+// ping sends count PING frames one after another on one connection and returns
+// the round trip of each. The connection log is returned, and saved, whenever
+// the server answered.
+func (c *client) ping(count int) (*common.ConnectionLog, []time.Duration, error) {
+	startTime := time.Now()
+	connID, events := c.newConnID("PING")
+
+	conn, serverOptions, err := c.open()
+	if err != nil {
+		events.Error("failed to connect", "err", err)
+		return nil, nil, err
+	}
+	defer c.release(conn)
+	events.Info("connected", "local", conn.LocalAddr().String())
+
+	tcpCollector := common.NewTCPInfoCollector()
+	tcpCollector.CollectSample(conn)
+
+	log := c.connectionLog(conn, serverOptions, connID, "PING", startTime)
+	var rtts []time.Duration
+	for i := 0; i < count; i++ {
+		payload := []byte(strconv.Itoa(i + 1))
+		sent := time.Now()
+		if err := protocol.WriteFrame(conn, protocol.CreatePingFrame(payload)); err != nil {
+			events.Error("failed to send PING", "err", err)
+			return nil, nil, failure(exitProtocol, "failed to send PING: %w", err)
+		}
+		response, err := protocol.ReadFrame(conn)
+		if err != nil {
+			events.Error("failed to read response", "err", err)
+			return nil, nil, failure(exitProtocol, "failed to read response: %w", err)
+		}
+		log.BytesSent += int64(5 + len(payload))
+		log.BytesReceived += int64(5 + len(response.Payload))
+
+		if response.OpCode != protocol.OpPing || string(response.Payload) != string(payload) {
+			log.EndTime = time.Now()
+			log.TCPSamples = tcpCollector.GetSamples()
+			c.logger.LogConnection(log)
+			if response.OpCode == protocol.OpError {
+				events.Error("server error", "message", string(response.Payload))
+				return log, rtts, failure(exitServer, "server error: %s", response.Payload)
+			}
+			return log, rtts, failure(exitProtocol, "unexpected PING response (opcode %d)", response.OpCode)
+		}
+		rtt := time.Since(sent)
+		rtts = append(rtts, rtt)
+		log.PingRTTsMs = append(log.PingRTTsMs, float64(rtt)/float64(time.Millisecond))
+	}
+
+	tcpCollector.CollectSample(conn)
+	log.EndTime = time.Now()
+	log.TCPSamples = tcpCollector.GetSamples()
+	c.logger.LogConnection(log)
+	return log, rtts, nil
 }
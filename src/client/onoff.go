@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// OFF period distributions
+const (
+	offFixed  = "fixed"  // fixed:<period>
+	offExp    = "exp"    // exp:<mean>
+	offPareto = "pareto" // pareto:<mean>:<shape>, shape above 1
+)
+
+// offPeriod draws the length of the OFF periods of an on/off upload
+type offPeriod struct {
+	dist  string
+	mean  time.Duration
+	shape float64 // Pareto only
+}
+
+func parseOffPeriod(spec string) (offPeriod, error) {
+	parts := strings.Split(spec, ":")
+	p := offPeriod{dist: parts[0]}
+	if len(parts) < 2 {
+		return p, fmt.Errorf("invalid OFF period %q (want %s:<d>, %s:<mean> or %s:<mean>:<shape>)", spec, offFixed, offExp, offPareto)
+	}
+	mean, err := time.ParseDuration(parts[1])
+	if err != nil || mean < 0 {
+		return p, fmt.Errorf("invalid OFF period %q", parts[1])
+	}
+	p.mean = mean
+
+	switch {
+	case (p.dist == offFixed || p.dist == offExp) && len(parts) == 2:
+	case p.dist == offPareto && len(parts) == 3:
+		p.shape, err = strconv.ParseFloat(parts[2], 64)
+		if err != nil || p.shape <= 1 {
+			return p, fmt.Errorf("invalid Pareto shape %q (must be above 1)", parts[2])
+		}
+	default:
+		return p, fmt.Errorf("invalid OFF period %q (want %s:<d>, %s:<mean> or %s:<mean>:<shape>)", spec, offFixed, offExp, offPareto)
+	}
+	return p, nil
+}
+
+func (p offPeriod) String() string {
+	if p.dist == offPareto {
+		return fmt.Sprintf("%s:%v:%g", p.dist, p.mean, p.shape)
+	}
+	return fmt.Sprintf("%s:%v", p.dist, p.mean)
+}
+
+// draw returns the next OFF period
+func (p offPeriod) draw(rng *rand.Rand) time.Duration {
+	switch p.dist {
+	case offExp:
+		return time.Duration(rng.ExpFloat64() * float64(p.mean))
+	case offPareto:
+		// The scale that gives the requested mean; 1-Float64 is in (0, 1]
+		scale := float64(p.mean) * (p.shape - 1) / p.shape
+		return time.Duration(scale / math.Pow(1-rng.Float64(), 1/p.shape))
+	default:
+		return p.mean
+	}
+}
+
+// onOff describes a bursty upload: ON periods of onBytes bytes or onTime at full
+// speed alternate with OFF periods drawn from off, for duration in total
+type onOff struct {
+	duration time.Duration
+	onBytes  int64
+	onTime   time.Duration
+	off      offPeriod
+	seed     int64
+}
+
+// parseOnOff parses a spec such as "duration=30s,on=1MB,off=exp:500ms,seed=7".
+// on is a byte count or a duration; seed defaults to 1.
+func parseOnOff(spec string) (*onOff, error) {
+	o := &onOff{seed: 1}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid on/off entry %q", part)
+		}
+		var err error
+		switch key {
+		case "duration":
+			o.duration, err = time.ParseDuration(value)
+		case "on":
+			if o.onTime, err = time.ParseDuration(value); err != nil {
+				o.onTime = 0
+				o.onBytes, err = common.ParseSize(value)
+			}
+		case "off":
+			o.off, err = parseOffPeriod(value)
+		case "seed":
+			o.seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return nil, fmt.Errorf("unknown on/off key %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid on/off %s %q: %w", key, value, err)
+		}
+	}
+	if o.duration <= 0 || o.onBytes <= 0 && o.onTime <= 0 || o.off.dist == "" {
+		return nil, fmt.Errorf("on/off needs a positive duration and on period, and an off period")
+	}
+	return o, nil
+}
+
+func (o *onOff) String() string {
+	on := strconv.FormatInt(o.onBytes, 10)
+	if o.onTime > 0 {
+		on = o.onTime.String()
+	}
+	return fmt.Sprintf("duration=%v,on=%s,off=%s,seed=%d", o.duration, on, o.off, o.seed)
+}
+
+// burstOver reports whether a burst that started at start and sent sent bytes is done
+func (o *onOff) burstOver(start time.Time, sent int64) bool {
+	if o.onTime > 0 {
+		return time.Since(start) >= o.onTime
+	}
+	return sent >= o.onBytes
+}
+
+func (c *client) onOffCommand(args []string) *result {
+	res := &result{Op: "onoff"}
+	fs := flag.NewFlagSet("onoff", flag.ContinueOnError)
+	duration := fs.Duration("duration", 30*time.Second, "How long to run")
+	on := fs.String("on", "1MB", "ON period: bytes per burst (e.g. 1MB) or a duration (e.g. 200ms)")
+	off := fs.String("off", "exp:500ms", "OFF period distribution: fixed:<d>, exp:<mean> or pareto:<mean>:<shape>")
+	seed := fs.Int64("seed", 1, "Seed of the OFF period draws")
+	chunk := fs.Int("chunk", 64*1024, "Payload bytes per DATA frame")
+	args, ok := commandFlags(res, fs, args, 0, 1, "onoff [-duration d] [-on size|d] [-off dist] [-seed n] [-chunk n] [file]")
+	if !ok {
+		return res
+	}
+	schedule, err := parseOnOff(fmt.Sprintf("duration=%v,on=%s,off=%s,seed=%d", *duration, *on, *off, *seed))
+	if err != nil {
+		res.finish(nil, 0, failure(exitUsage, "%w", err))
+		return res
+	}
+	file := ""
+	if len(args) > 0 {
+		file = args[0]
+	}
+	return c.runOnOff(file, schedule, *chunk)
+}
+
+// runOnOff runs a bursty upload from file, the synthetic payload or zeros, as runSend
+func (c *client) runOnOff(file string, schedule *onOff, chunk int) *result {
+	return c.runUpload("onoff", file, common.SteadyWindow{Duration: schedule.duration}, chunk, schedule)
+}
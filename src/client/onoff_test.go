@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseOffPeriod(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    offPeriod
+		wantErr bool
+	}{
+		{spec: "fixed:500ms", want: offPeriod{dist: offFixed, mean: 500 * time.Millisecond}},
+		{spec: "fixed:0s", want: offPeriod{dist: offFixed}},
+		{spec: "exp:2s", want: offPeriod{dist: offExp, mean: 2 * time.Second}},
+		{spec: "pareto:100ms:1.5", want: offPeriod{dist: offPareto, mean: 100 * time.Millisecond, shape: 1.5}},
+		{spec: "pareto:100ms:1", wantErr: true},
+		{spec: "pareto:100ms:x", wantErr: true},
+		{spec: "pareto:100ms", wantErr: true},
+		{spec: "exp:1s:2", wantErr: true},
+		{spec: "fixed:-1s", wantErr: true},
+		{spec: "fixed:soon", wantErr: true},
+		{spec: "fixed", wantErr: true},
+		{spec: "uniform:1s", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOffPeriod(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseOffPeriod(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseOffPeriod(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+		if s := got.String(); s != tt.spec {
+			t.Errorf("parseOffPeriod(%q).String() = %q", tt.spec, s)
+		}
+	}
+}
+
+func TestOffPeriodDraw(t *testing.T) {
+	tests := []struct {
+		spec string
+		min  time.Duration // Smallest possible draw
+	}{
+		{spec: "fixed:300ms", min: 300 * time.Millisecond},
+		{spec: "exp:300ms"},
+		{spec: "pareto:300ms:3", min: 200 * time.Millisecond},
+	}
+	const draws = 50000
+	for _, tt := range tests {
+		p, err := parseOffPeriod(tt.spec)
+		if err != nil {
+			t.Fatalf("parseOffPeriod(%q) failed: %v", tt.spec, err)
+		}
+		rng := rand.New(rand.NewSource(1))
+		var total time.Duration
+		for i := 0; i < draws; i++ {
+			d := p.draw(rng)
+			if d < tt.min {
+				t.Fatalf("%s: drew %v, below %v", tt.spec, d, tt.min)
+			}
+			total += d
+		}
+		if mean := total / draws; mean < p.mean*95/100 || mean > p.mean*105/100 {
+			t.Errorf("%s: mean of %d draws is %v, want about %v", tt.spec, draws, mean, p.mean)
+		}
+	}
+}
+
+func TestParseOnOff(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    onOff
+		wantErr bool
+	}{
+		{
+			spec: "duration=30s,on=1MB,off=exp:500ms",
+			want: onOff{duration: 30 * time.Second, onBytes: 1 << 20, off: offPeriod{dist: offExp, mean: 500 * time.Millisecond}, seed: 1},
+		},
+		{
+			spec: "duration=10s, on=200ms, off=fixed:1s, seed=7",
+			want: onOff{duration: 10 * time.Second, onTime: 200 * time.Millisecond, off: offPeriod{dist: offFixed, mean: time.Second}, seed: 7},
+		},
+		{spec: "duration=10s,on=0,off=fixed:1s", wantErr: true},
+		{spec: "duration=0s,on=1MB,off=fixed:1s", wantErr: true},
+		{spec: "duration=10s,on=1MB", wantErr: true},
+		{spec: "duration=10s,on=lots,off=fixed:1s", wantErr: true},
+		{spec: "duration=10s,on=1MB,off=fixed:1s,seed=x", wantErr: true},
+		{spec: "duration=10s,on=1MB,off=fixed:1s,rate=1", wantErr: true},
+		{spec: "duration=10s,on", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOnOff(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseOnOff(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || *got != tt.want {
+			t.Errorf("parseOnOff(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+			continue
+		}
+		if again, err := parseOnOff(got.String()); err != nil || *again != *got {
+			t.Errorf("parseOnOff(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// Parallel stream modes
+const (
+	streamShare = "share" // Every stream sends its own slice of the file
+	streamCopy  = "copy"  // Every stream sends the whole file
+)
+
+// putParallel uploads a file over c.streams concurrent connections, each with
+// its own PUT, TCP_INFO sampling and connection log. In share mode stream N
+// stores its slice of the file as <remote>.partN; in copy mode it stores a full
+// copy as <remote>.streamN. An aggregate record with the per-stream breakdown is
+// logged as well and returned.
+func (c *client) putParallel(filename, remote string) (*common.ConnectionLog, string, error) {
+	startTime := time.Now()
+	size, err := c.uploadSize(filename)
+	if err != nil {
+		return nil, "", failure(exitUsage, "failed to stat file: %w", err)
+	}
+
+	type stream struct {
+		remote  string
+		offset  int64
+		length  int64
+		connID  string
+		events  *slog.Logger
+		log     *common.ConnectionLog
+		message string
+		err     error
+	}
+	streams := make([]*stream, c.streams)
+	aggregateID, events := c.newConnID("PUT")
+	events = events.With("file", filename, "streams", c.streams, "stream_mode", c.streamMode)
+	share := size / int64(c.streams)
+	for i := range streams {
+		st := &stream{remote: fmt.Sprintf("%s.stream%d", remote, i+1), length: -1}
+		if c.streamMode == streamShare {
+			// The last stream also takes the remainder
+			st.remote = fmt.Sprintf("%s.part%d", remote, i+1)
+			st.offset = int64(i) * share
+			st.length = share
+			if i == len(streams)-1 {
+				st.length = size - st.offset
+			}
+		}
+		st.connID, st.events = c.newConnID("PUT")
+		st.events = st.events.With("file", filename, "stream_id", i+1)
+		streams[i] = st
+	}
+
+	events.Info("starting parallel upload")
+	var wg sync.WaitGroup
+	for i, st := range streams {
+		wg.Add(1)
+		go func(id int, st *stream) {
+			defer wg.Done()
+			st.log, st.message, st.err = c.putRange(filename, st.offset, st.length, st.remote, st.connID, st.events, id)
+		}(i+1, st)
+	}
+	wg.Wait()
+
+	aggregate := &common.ConnectionLog{
+		ConnID:     aggregateID,
+		StartTime:  startTime,
+		EndTime:    time.Now(),
+		RemoteAddr: c.address,
+		Operation:  fmt.Sprintf("PUT %s", remote),
+		SessionID:  c.sessionID,
+		Step:       c.step,
+		StreamMode: c.streamMode,
+		Pacing:     c.pacing.String(),
+		ZeroCopy:   c.zeroCopy,
+	}
+	var firstErr error
+	var payload int64
+	var throughputs []float64
+	for i, st := range streams {
+		stat := common.StreamStat{StreamID: i + 1, ConnID: st.connID}
+		if st.err != nil {
+			stat.Error = st.err.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("stream %d: %w", i+1, st.err)
+			}
+		}
+		if log := st.log; log != nil {
+			aggregate.BytesSent += log.BytesSent
+			aggregate.BytesReceived += log.BytesReceived
+			aggregate.CPUUserMs += log.CPUUserMs
+			aggregate.CPUSystemMs += log.CPUSystemMs
+			aggregate.CongestionControl = log.CongestionControl
+			aggregate.PeerCongestionControl = log.PeerCongestionControl
+			aggregate.Namespace = log.Namespace
+			aggregate.SocketProfile = log.SocketProfile
+
+			stat.Duration = log.EndTime.Sub(log.StartTime).Seconds()
+			stat.FinalCwnd = log.FinalCwnd
+			stat.TotalRetransmissions = log.TotalRetransmissions
+			if st.err == nil {
+				stat.Bytes = st.length
+				if stat.Bytes < 0 {
+					stat.Bytes = size
+				}
+				if stat.Duration > 0 {
+					stat.Throughput = float64(stat.Bytes) / stat.Duration
+				}
+				payload += stat.Bytes
+				throughputs = append(throughputs, stat.Throughput)
+			}
+		}
+		aggregate.Streams = append(aggregate.Streams, stat)
+	}
+	aggregate.Fairness = common.JainFairness(throughputs)
+
+	c.logger.LogConnection(aggregate)
+	if firstErr != nil {
+		events.Error("parallel upload failed", "err", firstErr)
+		return aggregate, "", &opError{code: exitCode(firstErr), err: firstErr}
+	}
+	events.Info("parallel upload complete", "bytes", payload, "duration", aggregate.EndTime.Sub(startTime), "fairness", aggregate.Fairness)
+	return aggregate, fmt.Sprintf("%d bytes uploaded over %d streams (%s)", payload, c.streams, c.streamMode), nil
+}
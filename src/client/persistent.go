@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"strconv"
+
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+// persistentConn is the connection reused across operations with -persistent,
+// the way the server's connection loop allows
+type persistentConn struct {
+	conn          net.Conn
+	serverOptions map[string]string
+	connID        string // Conn id of the operation that opened it
+	ops           int    // Operations carried so far, including the current one
+}
+
+// open returns the connection for the next operation: the persistent one,
+// dialed by its first operation, with -persistent and a new one otherwise.
+// Operations hand it back with release.
+func (c *client) open() (net.Conn, map[string]string, error) {
+	if !c.persistent {
+		return c.dial()
+	}
+	if c.session == nil {
+		conn, serverOptions, err := c.dial()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.session = &persistentConn{conn: conn, serverOptions: serverOptions, connID: strconv.Itoa(c.connID)}
+	}
+	c.session.ops++
+	return c.session.conn, c.session.serverOptions, nil
+}
+
+// release ends an operation's use of a connection from open, closing it unless
+// it is the persistent one
+func (c *client) release(conn net.Conn) {
+	if c.session == nil || conn != c.session.conn {
+		conn.Close()
+	}
+}
+
+// settle drops the persistent connection after an operation failed on it. Only
+// an ERROR frame from the server leaves the frames aligned; after anything else
+// the connection may be mid-frame, and the next operation dials a new one.
+func (c *client) settle(err error) {
+	if c.session == nil || err == nil || exitCode(err) == exitServer {
+		return
+	}
+	c.session.conn.Close()
+	c.session = nil
+}
+
+// hangUp closes the persistent connection with a QUIT
+func (c *client) hangUp() {
+	if c.session == nil {
+		return
+	}
+	if err := protocol.WriteFrame(c.session.conn, protocol.CreateQuitFrame()); err == nil {
+		protocol.ReadFrame(c.session.conn)
+	}
+	c.session.conn.Close()
+	c.session = nil
+}
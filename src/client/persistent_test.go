@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+func TestPersistentConnection(t *testing.T) {
+	var fail atomic.Value
+	fail.Store("")
+	address := fakeServer(t, func(request *protocol.Frame) *protocol.Frame {
+		switch {
+		case fail.Load() == "server":
+			return protocol.CreateErrorFrame("refused")
+		case fail.Load() == "protocol":
+			return nil
+		case request.OpCode == protocol.OpQuit:
+			return protocol.CreateQuitFrame()
+		}
+		return &protocol.Frame{OpCode: protocol.OpList, PayloadLen: 5, Payload: []byte("a.bin")}
+	})
+	c := testClient(t, address)
+	c.persistent = true
+
+	for i := 0; i < 2; i++ {
+		if res := c.runList(); !res.OK {
+			t.Fatalf("runList() failed: %s", res.Error)
+		}
+	}
+	if c.session == nil || c.session.ops != 2 {
+		t.Fatalf("session after two operations = %+v, want 2 operations", c.session)
+	}
+	conn := c.session.conn
+
+	// An ERROR frame keeps the frames aligned and the connection open
+	fail.Store("server")
+	if res := c.runList(); res.ExitCode != exitServer {
+		t.Fatalf("runList() exit code = %d, want %d", res.ExitCode, exitServer)
+	}
+	if c.session == nil || c.session.conn != conn {
+		t.Fatal("ERROR frame dropped the persistent connection")
+	}
+
+	fail.Store("protocol")
+	if res := c.runList(); res.ExitCode != exitProtocol {
+		t.Fatalf("runList() exit code = %d, want %d", res.ExitCode, exitProtocol)
+	}
+	if c.session != nil {
+		t.Fatal("protocol error kept the persistent connection")
+	}
+
+	fail.Store("")
+	if res := c.runList(); !res.OK {
+		t.Fatalf("runList() after a protocol error failed: %s", res.Error)
+	}
+	if c.session == nil || c.session.conn == conn || c.session.ops != 1 {
+		t.Fatalf("session after redialing = %+v, want a new connection", c.session)
+	}
+	c.hangUp()
+	if c.session != nil {
+		t.Error("hangUp() kept the persistent connection")
+	}
+}
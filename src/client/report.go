@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// Formats of the live interval reports
+const (
+	reportText = "text" // One human-readable line per interval
+	reportJSON = "json" // One JSON object per line; the command result follows as the last line
+)
+
+// intervalReports prints the interval reports of every transfer on stdout as
+// they end. Parallel streams report from their own goroutines, so lines are
+// written under a lock.
+type intervalReports struct {
+	format   string
+	interval time.Duration
+	mu       sync.Mutex
+}
+
+// intervalLine is a JSON line of a live interval report
+type intervalLine struct {
+	ConnID string `json:"conn_id"`
+	Stream int    `json:"stream_id,omitempty"`
+	common.IntervalReport
+}
+
+// reporter returns the interval reporter of a transfer, or nil without -report.
+// The counter is bytes_acked for uploads and bytes_received for downloads;
+// baseline is the sample taken when the transfer started.
+func (c *client) reporter(connID string, stream int, start time.Time, counter func(common.TCPInfo) uint64, baseline *common.TCPInfo) *common.IntervalReporter {
+	if c.reports == nil {
+		return nil
+	}
+	r := common.NewIntervalReporter(start, c.reports.interval, counter, func(report common.IntervalReport) {
+		c.reports.print(connID, stream, report)
+	})
+	if baseline != nil {
+		r.Sample(*baseline)
+	}
+	return r
+}
+
+func (r *intervalReports) print(connID string, stream int, report common.IntervalReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.format == reportJSON {
+		line, _ := json.Marshal(intervalLine{ConnID: connID, Stream: stream, IntervalReport: report})
+		fmt.Fprintf(os.Stdout, "%s\n", line)
+		return
+	}
+	label := fmt.Sprintf("[conn %s]", connID)
+	if stream > 0 {
+		label = fmt.Sprintf("[conn %s stream %d]", connID, stream)
+	}
+	fmt.Fprintf(os.Stdout, "%s %6.2f-%6.2f s  %12d bytes  %14.2f bytes/sec  rtt %7.2f ms  cwnd %6d  retrans %d\n",
+		label, report.Start, report.End, report.Bytes, report.Throughput, report.RTTMs, report.Cwnd, report.Retransmits)
+}
+
+func bytesAcked(s common.TCPInfo) uint64 { return s.BytesAcked }
+
+func bytesReceived(s common.TCPInfo) uint64 { return s.BytesReceived }
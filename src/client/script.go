@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// A session script describes a multi-step client session, one step per line:
+//
+//	# Upload the same file three times, then check the listing
+//	set FILE test-files/test_200MB.bin
+//	repeat 3
+//	    put ${FILE} run-${ITER}.bin
+//	    sleep 2s
+//	end
+//	ping 5
+//	send duration=30s,warmup=5s,cooldown=2s
+//	idle 100ms,1s,5s 4MB
+//	onoff duration=30s,on=1MB,off=pareto:500ms:1.5
+//	list
+//	get run-1.bin /tmp/run-1.bin
+//
+// Lines starting with '#' are comments. ${NAME} expands a variable when the step
+// runs; ${ITER} is the iteration of the innermost repeat, counting from 1. A '$'
+// not followed by '{' is taken literally. Every step other than set, repeat and
+// end is numbered, and each connection it opens is logged with the session id
+// and step number.
+
+// scriptCommands maps each script command to its minimum and maximum number of
+// arguments, -1 for no maximum
+var scriptCommands = map[string][2]int{
+	"put":    {1, 2},
+	"get":    {1, 2},
+	"list":   {0, 0},
+	"ping":   {0, 1},
+	"send":   {1, 2},
+	"idle":   {1, 2},
+	"onoff":  {1, 2},
+	"sleep":  {1, 1},
+	"set":    {2, -1},
+	"repeat": {1, 1},
+	"end":    {0, 0},
+}
+
+// scriptStep is a parsed script line; a repeat step holds the steps of its body
+type scriptStep struct {
+	line int
+	cmd  string
+	args []string
+	body []*scriptStep
+}
+
+// parseScript reads a script into its top-level steps
+func parseScript(r io.Reader) ([]*scriptStep, error) {
+	root := &scriptStep{}
+	stack := []*scriptStep{root}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		step := &scriptStep{line: line, cmd: fields[0], args: fields[1:]}
+		limits, ok := scriptCommands[step.cmd]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown command %q", line, step.cmd)
+		}
+		if len(step.args) < limits[0] || limits[1] >= 0 && len(step.args) > limits[1] {
+			return nil, fmt.Errorf("line %d: wrong number of arguments for %s", line, step.cmd)
+		}
+
+		switch step.cmd {
+		case "set":
+			if !validVarName(step.args[0]) {
+				return nil, fmt.Errorf("line %d: invalid variable name %q", line, step.args[0])
+			}
+			// The value is the rest of the line
+			step.args = []string{step.args[0], strings.Join(step.args[1:], " ")}
+		case "end":
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("line %d: end without repeat", line)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		parent := stack[len(stack)-1]
+		parent.body = append(parent.body, step)
+		if step.cmd == "repeat" {
+			stack = append(stack, step)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("line %d: repeat without end", stack[len(stack)-1].line)
+	}
+	return root.body, nil
+}
+
+// validVarName reports whether name can be used as a script variable
+func validVarName(name string) bool {
+	if name == "" || name == "ITER" {
+		return false
+	}
+	for i, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// varFlags collects repeated -var NAME=value flags
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	var parts []string
+	for name, value := range v {
+		parts = append(parts, name+"="+value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v varFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || !validVarName(name) {
+		return fmt.Errorf("invalid variable %q (want NAME=value)", s)
+	}
+	v[name] = value
+	return nil
+}
+
+// sessionSummary totals the steps of a script session
+type sessionSummary struct {
+	SessionID       string    `json:"session_id"`
+	StartTime       time.Time `json:"start_time"`
+	EndTime         time.Time `json:"end_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Steps           int       `json:"steps"`
+	Failed          int       `json:"failed"`
+	Bytes           int64     `json:"bytes"` // Payload bytes moved by successful steps
+}
+
+// scriptRun is the state of a running script
+type scriptRun struct {
+	c         *client
+	vars      map[string]string
+	fixed     map[string]bool // Set with -var; the script's set does not override them
+	keepGoing bool
+	iter      []int // Iteration of each enclosing repeat
+	results   []result
+	err       error // First failed step
+}
+
+func (c *client) runCommand(args []string) *result {
+	res := &result{Op: "run"}
+	vars := make(varFlags)
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.Var(vars, "var", "Set a script variable, NAME=value (repeatable); overrides the script's own set")
+	keepGoing := fs.Bool("keep-going", false, "Run the remaining steps after a step fails")
+	args, ok := commandFlags(res, fs, args, 1, 1, "run [-var NAME=value] [-keep-going] <script>")
+	if !ok {
+		return res
+	}
+
+	res.File = args[0]
+	f, err := os.Open(res.File)
+	if err != nil {
+		res.finish(nil, 0, failure(exitUsage, "failed to open script: %w", err))
+		return res
+	}
+	steps, err := parseScript(f)
+	f.Close()
+	if err != nil {
+		res.finish(nil, 0, failure(exitUsage, "invalid script %s: %w", res.File, err))
+		return res
+	}
+
+	start := time.Now()
+	summary := &sessionSummary{
+		SessionID: fmt.Sprintf("%s-%d", start.Format("20060102-150405"), os.Getpid()),
+		StartTime: start,
+	}
+	run := &scriptRun{c: c, vars: make(map[string]string), fixed: make(map[string]bool), keepGoing: *keepGoing}
+	for name, value := range vars {
+		run.vars[name] = value
+		run.fixed[name] = true
+	}
+
+	c.sessionID = summary.SessionID
+	run.run(steps)
+	c.sessionID, c.step = "", 0
+
+	summary.EndTime = time.Now()
+	summary.DurationSeconds = summary.EndTime.Sub(start).Seconds()
+	summary.Steps = len(run.results)
+	for _, r := range run.results {
+		if r.OK {
+			summary.Bytes += r.Bytes
+		} else {
+			summary.Failed++
+		}
+	}
+	res.Runs = run.results
+	res.Session = summary
+	res.finish(nil, summary.Bytes, run.err)
+
+	if err := c.logger.LogSummary("session", summary.SessionID, start, res); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save session summary: %v\n", err)
+	}
+	return res
+}
+
+// run executes steps in order and reports whether the script should go on
+func (s *scriptRun) run(steps []*scriptStep) bool {
+	for _, step := range steps {
+		switch step.cmd {
+		case "set":
+			value, err := s.expand(step.args[1])
+			if err != nil {
+				if !s.record(step, &result{Op: "set"}, err) {
+					return false
+				}
+				continue
+			}
+			if !s.fixed[step.args[0]] {
+				s.vars[step.args[0]] = value
+			}
+		case "repeat":
+			arg, err := s.expand(step.args[0])
+			n, convErr := strconv.Atoi(arg)
+			if err == nil && (convErr != nil || n < 0) {
+				err = failure(exitUsage, "invalid repeat count %q", arg)
+			}
+			if err != nil {
+				if !s.record(step, &result{Op: "repeat"}, err) {
+					return false
+				}
+				continue
+			}
+
+			s.iter = append(s.iter, 0)
+			for i := 1; i <= n; i++ {
+				s.iter[len(s.iter)-1] = i
+				if !s.run(step.body) {
+					return false
+				}
+			}
+			s.iter = s.iter[:len(s.iter)-1]
+		default:
+			if !s.step(step) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// step executes a numbered step
+func (s *scriptRun) step(step *scriptStep) bool {
+	args := make([]string, len(step.args))
+	for i, arg := range step.args {
+		expanded, err := s.expand(arg)
+		if err != nil {
+			return s.record(step, &result{Op: step.cmd}, err)
+		}
+		args[i] = expanded
+	}
+	optional := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+
+	s.c.step = len(s.results) + 1
+	var res *result
+	switch step.cmd {
+	case "put":
+		res = s.c.runPut(args[0], optional(1))
+	case "get":
+		res = s.c.runGet(args[0], optional(1))
+	case "list":
+		res = s.c.runList()
+	case "ping":
+		count := 1
+		if len(args) > 0 {
+			var err error
+			if count, err = strconv.Atoi(args[0]); err != nil {
+				return s.record(step, &result{Op: "ping"}, failure(exitUsage, "invalid ping count %q", args[0]))
+			}
+		}
+		res = s.c.runPing(count)
+	case "send":
+		window, err := common.ParseSteadyWindow(args[0])
+		if err != nil {
+			return s.record(step, &result{Op: "send"}, failure(exitUsage, "%w", err))
+		}
+		res = s.c.runSend(optional(1), window, 64*1024)
+	case "onoff":
+		schedule, err := parseOnOff(args[0])
+		if err != nil {
+			return s.record(step, &result{Op: "onoff"}, failure(exitUsage, "%w", err))
+		}
+		res = s.c.runOnOff(optional(1), schedule, 64*1024)
+	case "idle":
+		gaps, err := parseGaps(args[0])
+		burst := int64(1 << 20)
+		if err == nil && len(args) > 1 {
+			burst, err = common.ParseSize(args[1])
+		}
+		if err != nil {
+			return s.record(step, &result{Op: "idle"}, failure(exitUsage, "%w", err))
+		}
+		res = s.c.runIdle(gaps, burst, 64*1024)
+	case "sleep":
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d < 0 {
+			return s.record(step, &result{Op: "sleep"}, failure(exitUsage, "invalid sleep duration %q", args[0]))
+		}
+		time.Sleep(d)
+		res = &result{Op: "sleep", OK: true, DurationSeconds: d.Seconds()}
+	}
+
+	var err error
+	if !res.OK {
+		err = &opError{code: res.ExitCode, err: errors.New(res.Error)}
+	}
+	return s.record(step, res, err)
+}
+
+// record adds the result of a step and reports whether the script should go on
+func (s *scriptRun) record(step *scriptStep, res *result, err error) bool {
+	if err != nil && res.Error == "" {
+		res.finish(nil, 0, err)
+	}
+	res.Step = len(s.results) + 1
+	res.Line = step.line
+	s.results = append(s.results, *res)
+
+	if err == nil {
+		return true
+	}
+	if s.err == nil {
+		s.err = fmt.Errorf("step %d (line %d, %s): %w", res.Step, step.line, step.cmd, err)
+	}
+	return s.keepGoing
+}
+
+// expand replaces ${NAME} with the value of a variable. Other '$' characters are
+// left alone, so file names may contain them.
+func (s *scriptRun) expand(arg string) (string, error) {
+	var b strings.Builder
+	var missing []string
+	for {
+		i := strings.Index(arg, "${")
+		if i < 0 {
+			break
+		}
+		end := strings.IndexByte(arg[i:], '}')
+		if end < 0 {
+			return "", failure(exitUsage, "unterminated variable in %q", arg)
+		}
+		b.WriteString(arg[:i])
+		name := arg[i+2 : i+end]
+		if name == "ITER" && len(s.iter) > 0 {
+			b.WriteString(strconv.Itoa(s.iter[len(s.iter)-1]))
+		} else if v, ok := s.vars[name]; ok {
+			b.WriteString(v)
+		} else {
+			missing = append(missing, name)
+		}
+		arg = arg[i+end+1:]
+	}
+	if len(missing) > 0 {
+		return "", failure(exitUsage, "undefined variable %s", strings.Join(missing, ", "))
+	}
+	b.WriteString(arg)
+	return b.String(), nil
+}
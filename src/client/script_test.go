@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// stepTree renders parsed steps compactly, with repeat bodies in brackets
+func stepTree(steps []*scriptStep) string {
+	var parts []string
+	for _, s := range steps {
+		part := strings.Join(append([]string{s.cmd}, s.args...), " ")
+		if s.cmd == "repeat" {
+			part += " [" + stepTree(s.body) + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "steps and comments",
+			script: "# comment\n\nlist\nping 5\n  put a.bin b.bin\n",
+			want:   "list; ping 5; put a.bin b.bin",
+		},
+		{
+			name:   "set keeps the rest of the line",
+			script: "set NAME two  words\nsleep 1s\n",
+			want:   "set NAME two words; sleep 1s",
+		},
+		{
+			name:   "nested repeat",
+			script: "repeat 2\n  put x\n  repeat 3\n    sleep 1s\n  end\nend\nlist\n",
+			want:   "repeat 2 [put x; repeat 3 [sleep 1s]]; list",
+		},
+		{name: "unknown command", script: "fly away\n", wantErr: `line 1: unknown command "fly"`},
+		{name: "too few arguments", script: "list\nput\n", wantErr: "line 2: wrong number of arguments for put"},
+		{name: "too many arguments", script: "list now\n", wantErr: "line 1: wrong number of arguments for list"},
+		{name: "invalid variable", script: "set 1X a\n", wantErr: `line 1: invalid variable name "1X"`},
+		{name: "ITER is reserved", script: "set ITER 3\n", wantErr: `line 1: invalid variable name "ITER"`},
+		{name: "end without repeat", script: "list\nend\n", wantErr: "line 2: end without repeat"},
+		{name: "repeat without end", script: "repeat 2\n  list\n", wantErr: "line 1: repeat without end"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := parseScript(strings.NewReader(tt.script))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseScript error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseScript failed: %v", err)
+			}
+			if got := stepTree(steps); got != tt.want {
+				t.Errorf("parseScript = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	s := &scriptRun{vars: map[string]string{"FILE": "test.bin", "DIR": "/tmp", "EMPTY": ""}, iter: []int{2, 5}}
+	tests := []struct {
+		arg     string
+		want    string
+		wantErr string
+	}{
+		{arg: "plain", want: "plain"},
+		{arg: "${FILE}", want: "test.bin"},
+		{arg: "${DIR}/${FILE}", want: "/tmp/test.bin"},
+		{arg: "run-${ITER}.bin", want: "run-5.bin"},
+		{arg: "a${EMPTY}b", want: "ab"},
+		{arg: "price$5.bin", want: "price$5.bin"},
+		{arg: "$FILE", want: "$FILE"},
+		{arg: "$", want: "$"},
+		{arg: "${MISSING}", wantErr: "undefined variable MISSING"},
+		{arg: "${A}${B}", wantErr: "undefined variable A, B"},
+		{arg: "${FILE", wantErr: `unterminated variable in "${FILE"`},
+	}
+	for _, tt := range tests {
+		got, err := s.expand(tt.arg)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("expand(%q) = %q, %v, want error %q", tt.arg, got, err, tt.wantErr)
+			} else if exitCode(err) != exitUsage {
+				t.Errorf("expand(%q) exit code = %d, want %d", tt.arg, exitCode(err), exitUsage)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v, want %q", tt.arg, got, err, tt.want)
+		}
+	}
+
+	// Outside a repeat ITER is an ordinary, here undefined, variable
+	if _, err := (&scriptRun{vars: map[string]string{}}).expand("${ITER}"); err == nil {
+		t.Errorf("expand(${ITER}) outside a repeat succeeded")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+// repeatReader reads a file over and over
+type repeatReader struct {
+	f *os.File
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	if err == io.EOF && n == 0 {
+		if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return r.f.Read(p)
+	}
+	return n, err
+}
+
+// send uploads for window.Duration in DATA frames of up to chunk bytes, read from
+// source or all zeros if source is nil, and returns the payload bytes sent. The
+// server drops the data. Besides the whole-run throughput the connection log
+// reports the steady-state throughput between warm-up and cool-down, from the
+// bytes_acked deltas of the TCP_INFO samples. With a schedule the upload is
+// bursty: it pauses between ON periods, and the log records the start and end of
+// every burst as events. The connection log is returned, and saved, whenever the
+// server answered.
+func (c *client) send(source io.Reader, label string, window common.SteadyWindow, chunk int, schedule *onOff) (*common.ConnectionLog, int64, string, error) {
+	startTime := time.Now()
+	connID, events := c.newConnID("SEND")
+	events = events.With("source", label, "window", window.String())
+
+	conn, serverOptions, err := c.open()
+	if err != nil {
+		events.Error("failed to connect", "err", err)
+		return nil, 0, "", err
+	}
+	defer c.release(conn)
+	events.Info("connected", "local", conn.LocalAddr().String())
+
+	tcpCollector := common.NewTCPInfoCollector()
+	tcpCollector.CollectSample(conn)
+	reporter := c.reporter(connID, 0, startTime, bytesAcked, tcpCollector.Latest())
+	stopSampling := tcpCollector.StartSampling(conn, 100*time.Millisecond, reporter.Sample)
+	defer stopSampling()
+
+	start := protocol.CreateSendFrame(window.String())
+	if err := protocol.WriteFrame(conn, start); err != nil {
+		events.Error("failed to send SEND", "err", err)
+		return nil, 0, "", failure(exitProtocol, "failed to send SEND: %w", err)
+	}
+
+	// Every chunk goes out with its frame header in a single write
+	buf := make([]byte, 5+chunk)
+	buf[0] = protocol.OpData
+
+	var w io.Writer = conn
+	var pacer *common.PacedWriter
+	if c.pacing.Enabled() {
+		pacer = c.pacing.Writer(conn)
+		w = pacer
+	}
+
+	var payload, frames int64
+	var transferEvents []common.TransferEvent
+	var rng *rand.Rand
+	var burst int
+	var burstStart time.Time
+	var burstSent int64
+	// mark records a burst boundary with the cwnd at that moment
+	mark := func(kind string) {
+		tcpCollector.CollectSample(conn)
+		e := common.TransferEvent{Timestamp: time.Now(), Type: kind, Burst: burst, Bytes: payload}
+		if latest := tcpCollector.Latest(); latest != nil {
+			e.Cwnd = latest.SndCwnd
+		}
+		transferEvents = append(transferEvents, e)
+		events.Debug("burst boundary", "type", kind, "burst", burst, "bytes", payload)
+	}
+
+	cpu := common.StartCPUTimer()
+	defer cpu.Stop()
+	sendStart := time.Now()
+	deadline := sendStart.Add(window.Duration)
+	if schedule != nil {
+		rng = rand.New(rand.NewSource(schedule.seed))
+		burst, burstStart = 1, sendStart
+		mark(common.EventBurstStart)
+	}
+	for time.Now().Before(deadline) {
+		n := chunk
+		if schedule != nil {
+			if schedule.burstOver(burstStart, burstSent) {
+				mark(common.EventBurstEnd)
+				time.Sleep(min(schedule.off.draw(rng), time.Until(deadline)))
+				if !time.Now().Before(deadline) {
+					break
+				}
+				burst++
+				burstStart, burstSent = time.Now(), 0
+				mark(common.EventBurstStart)
+			}
+			if schedule.onBytes > 0 {
+				n = int(min(int64(chunk), schedule.onBytes-burstSent))
+			}
+		}
+
+		binary.BigEndian.PutUint32(buf[1:5], uint32(n))
+		if source != nil {
+			if _, err := io.ReadFull(source, buf[5:5+n]); err != nil {
+				events.Error("failed to read source", "err", err)
+				return nil, payload, "", failure(exitUsage, "failed to read %s: %w", label, err)
+			}
+		}
+		if _, err := w.Write(buf[:5+n]); err != nil {
+			events.Error("failed to send DATA", "err", err)
+			return nil, payload, "", failure(exitProtocol, "failed to send DATA: %w", err)
+		}
+		payload += int64(n)
+		burstSent += int64(n)
+		frames++
+	}
+	if schedule != nil && transferEvents[len(transferEvents)-1].Type != common.EventBurstEnd {
+		mark(common.EventBurstEnd)
+	}
+	sendEnd := time.Now()
+	tcpCollector.CollectSample(conn)
+
+	// An empty DATA frame ends the upload
+	if err := protocol.WriteFrame(conn, &protocol.Frame{OpCode: protocol.OpData}); err != nil {
+		events.Error("failed to send final DATA", "err", err)
+		return nil, payload, "", failure(exitProtocol, "failed to send final DATA: %w", err)
+	}
+	response, err := protocol.ReadFrame(conn)
+	if err != nil {
+		events.Error("failed to read response", "err", err)
+		return nil, payload, "", failure(exitProtocol, "failed to read response: %w", err)
+	}
+	stopSampling()
+	cpuUser, cpuSystem := cpu.Stop()
+	tcpCollector.CollectSample(conn)
+
+	log := c.connectionLog(conn, serverOptions, connID, fmt.Sprintf("SEND %v", window.Duration), startTime)
+	log.EndTime = time.Now()
+	log.BytesSent = int64(5+len(start.Payload)) + 5*frames + payload + 5
+	log.BytesReceived = int64(5 + len(response.Payload))
+	log.TCPSamples = tcpCollector.GetSamples()
+	log.Intervals = reporter.Finish(tcpCollector.Latest())
+	log.Warmup = window.Warmup.Seconds()
+	log.Cooldown = window.Cooldown.Seconds()
+	from, to := window.Bounds(sendStart, sendEnd)
+	log.SteadyStateThroughput = common.SteadyStateRate(log.TCPSamples, from, to, func(s common.TCPInfo) uint64 { return s.BytesAcked })
+	log.CPUUserMs = float64(cpuUser) / float64(time.Millisecond)
+	log.CPUSystemMs = float64(cpuSystem) / float64(time.Millisecond)
+	if schedule != nil {
+		log.OnOff = schedule.String()
+		log.Events = transferEvents
+	}
+	if pacer != nil {
+		log.Pacing = c.pacing.String()
+		log.PacingWaitMs = float64(pacer.Waited()) / float64(time.Millisecond)
+	}
+	c.logger.LogConnection(log)
+
+	switch response.OpCode {
+	case protocol.OpError:
+		events.Error("server error", "message", string(response.Payload))
+		return log, payload, "", failure(exitServer, "server error: %s", response.Payload)
+	case protocol.OpSend:
+		events.Info("timed upload complete", "bytes", payload, "steady_state_bps", log.SteadyStateThroughput)
+		return log, payload, string(response.Payload), nil
+	default:
+		return log, payload, "", failure(exitProtocol, "unexpected response opcode %d", response.OpCode)
+	}
+}
+
+// runSend uploads for the window's duration from file, repeated as needed, from
+// the synthetic payload with -payload, or zeros if file is empty
+func (c *client) runSend(file string, window common.SteadyWindow, chunk int) *result {
+	return c.runUpload("send", file, window, chunk, nil)
+}
+
+// runUpload runs a timed upload for send or, with a schedule, onoff
+func (c *client) runUpload(op, file string, window common.SteadyWindow, chunk int, schedule *onOff) *result {
+	res := &result{Op: op, File: file}
+	if window.Duration <= 0 || chunk <= 0 {
+		res.finish(nil, 0, failure(exitUsage, "%s needs a positive duration and chunk size", op))
+		return res
+	}
+
+	var source io.Reader
+	label := "zeros"
+	switch {
+	case c.payload != nil:
+		if file != "" {
+			res.finish(nil, 0, failure(exitUsage, "%s takes no file with -payload", op))
+			return res
+		}
+		// The payload's size does not apply: the window decides how much is sent
+		source, label = c.payload.Reader(), c.payload.String()
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			res.finish(nil, 0, failure(exitUsage, "failed to open file: %w", err))
+			return res
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err != nil || info.Size() == 0 {
+			res.finish(nil, 0, failure(exitUsage, "%s is empty or unreadable", file))
+			return res
+		}
+		source, label = &repeatReader{f: f}, file
+	}
+
+	log, payload, message, err := c.send(source, label, window, chunk, schedule)
+	c.settle(err)
+	res.Message = message
+	res.finish(log, payload, err)
+	if log != nil {
+		res.SteadyStateThroughputBps = log.SteadyStateThroughput
+	}
+	return res
+}
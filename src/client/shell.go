@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shell runs the interactive command loop on stdin. Failed commands are reported
+// and the loop goes on, so the shell always exits with exitOK.
+func (c *client) shell() int {
+	fmt.Printf("TCP File Transfer Client\n")
+	fmt.Printf("Server: %s\n", c.address)
+	fmt.Printf("Socket profile: %s\n", c.profile)
+	if c.pacing.Enabled() {
+		fmt.Printf("Pacing: %s\n", c.pacing)
+	}
+	for _, opt := range c.options {
+		fmt.Printf("Server option: %s=%s\n", opt.key, opt.value)
+	}
+	fmt.Printf("Commands: list, put <filename>, get <filename>, quit\n\n")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			break
+		}
+
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" {
+			continue
+		}
+
+		parts := strings.Fields(command)
+		switch parts[0] {
+		case "list":
+			log, listing, err := c.list()
+			c.settle(err)
+			if err == nil {
+				fmt.Printf("Files on server:\n%s\n", listing)
+			} else {
+				fmt.Printf("Error: %v\n", err)
+			}
+			if log != nil {
+				c.logger.PrintSummary(log)
+			}
+		case "put":
+			if len(parts) < 2 {
+				fmt.Println("Usage: put <filename>")
+				continue
+			}
+			log, _, err := c.put(parts[1], parts[1])
+			c.settle(err)
+			if err == nil {
+				fmt.Printf("File %s uploaded successfully\n", parts[1])
+			} else {
+				fmt.Printf("Error: %v\n", err)
+			}
+			if log != nil {
+				c.logger.PrintSummary(log)
+			}
+		case "get":
+			if len(parts) < 2 {
+				fmt.Println("Usage: get <filename>")
+				continue
+			}
+			local := filepath.Base(parts[1])
+			log, _, err := c.get(parts[1], local)
+			c.settle(err)
+			if err == nil {
+				fmt.Printf("File %s downloaded to %s\n", parts[1], local)
+			} else {
+				fmt.Printf("Error: %v\n", err)
+			}
+			if log != nil {
+				c.logger.PrintSummary(log)
+			}
+		case "quit":
+			fmt.Println("Goodbye!")
+			return exitOK
+		default:
+			fmt.Printf("Unknown command: %s\n", parts[0])
+		}
+	}
+	return exitOK
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// sweepRow summarizes the runs of one transfer size in a sweep
+type sweepRow struct {
+	Size                  int64   `json:"size_bytes"`
+	Runs                  int     `json:"runs"` // Successful runs the other columns average over
+	Failed                int     `json:"failed,omitempty"`
+	MeanCompletionSeconds float64 `json:"mean_completion_seconds"`
+	MinCompletionSeconds  float64 `json:"min_completion_seconds"`
+	MaxCompletionSeconds  float64 `json:"max_completion_seconds"`
+	MeanThroughputBps     float64 `json:"mean_throughput_bps"`
+	MeanFinalCwnd         float64 `json:"mean_final_cwnd"`
+	MeanRetransmissions   float64 `json:"mean_retransmissions"`
+}
+
+// sweepColumns heads the CSV table, in the order of the sweepRow fields
+var sweepColumns = []string{"size_bytes", "runs", "failed", "mean_completion_seconds", "min_completion_seconds",
+	"max_completion_seconds", "mean_throughput_bps", "mean_final_cwnd", "mean_retransmissions"}
+
+func (r *sweepRow) record() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{strconv.FormatInt(r.Size, 10), strconv.Itoa(r.Runs), strconv.Itoa(r.Failed),
+		f(r.MeanCompletionSeconds), f(r.MinCompletionSeconds), f(r.MaxCompletionSeconds),
+		f(r.MeanThroughputBps), f(r.MeanFinalCwnd), f(r.MeanRetransmissions)}
+}
+
+// geometricSizes returns the sizes from min up to max, each factor times the last
+func geometricSizes(min, max int64, factor float64) []int64 {
+	var sizes []int64
+	for s := float64(min); s <= float64(max); s *= factor {
+		size := int64(math.Round(s))
+		if len(sizes) == 0 || size != sizes[len(sizes)-1] {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+func (c *client) sweepCommand(args []string) *result {
+	res := &result{Op: "sweep"}
+	fs := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	minSpec := fs.String("min", "1KB", "Smallest transfer size")
+	maxSpec := fs.String("max", "256MB", "Largest transfer size")
+	factor := fs.Float64("factor", 4, "Ratio between consecutive sizes")
+	runs := fs.Int("n", 3, "Uploads per size")
+	interval := fs.Duration("interval", 0, "Pause between uploads")
+	output := fs.String("o", "", "Also write the result table to this CSV file")
+	if _, ok := commandFlags(res, fs, args, 0, 0, "sweep [-min size] [-max size] [-factor f] [-n runs] [-interval d] [-o table.csv]"); !ok {
+		return res
+	}
+
+	minSize, err := common.ParseSize(*minSpec)
+	if err != nil {
+		res.finish(nil, 0, failure(exitUsage, "%w", err))
+		return res
+	}
+	maxSize, err := common.ParseSize(*maxSpec)
+	if err != nil {
+		res.finish(nil, 0, failure(exitUsage, "%w", err))
+		return res
+	}
+	if minSize <= 0 || maxSize < minSize || *factor <= 1 || *runs < 1 {
+		res.finish(nil, 0, failure(exitUsage, "sweep needs 0 < -min <= -max, -factor above 1 and -n at least 1"))
+		return res
+	}
+	return c.runSweep(geometricSizes(minSize, maxSize, *factor), *runs, *interval, *output)
+}
+
+// runSweep uploads every size runs times and tabulates completion time,
+// throughput and final cwnd per size. The data is a synthetic payload, -payload's
+// pattern or zeros, that the server verifies and drops, so nothing needs to exist
+// on disk on either side. Every upload is sampled, however small. The table is
+// saved as a sweep summary log next to the connection logs.
+func (c *client) runSweep(sizes []int64, runs int, interval time.Duration, output string) *result {
+	res := &result{Op: "sweep", File: output}
+	start := time.Now()
+
+	payload, restore := c.syntheticUploads()
+	c.sampleAlways = true
+	defer func() {
+		restore()
+		c.sampleAlways = false
+	}()
+
+	var err error
+	var total int64
+	for _, size := range sizes {
+		row := sweepRow{Size: size}
+		for i := 1; i <= runs; i++ {
+			if len(res.Runs) > 0 && interval > 0 {
+				time.Sleep(interval)
+			}
+
+			p := payload
+			p.Size = size
+			c.payload = &p
+			remote := fmt.Sprintf("sweep-%d-%d-%d", start.Unix(), size, i)
+			run := c.runPut(remote, remote)
+			res.Runs = append(res.Runs, *run)
+			if !run.OK {
+				row.Failed++
+				if err == nil {
+					err = &opError{code: run.ExitCode, err: fmt.Errorf("%d bytes, run %d: %w", size, i, errors.New(run.Error))}
+				}
+				continue
+			}
+
+			if row.Runs == 0 || run.DurationSeconds < row.MinCompletionSeconds {
+				row.MinCompletionSeconds = run.DurationSeconds
+			}
+			row.MaxCompletionSeconds = max(row.MaxCompletionSeconds, run.DurationSeconds)
+			row.MeanCompletionSeconds += run.DurationSeconds
+			row.MeanThroughputBps += run.ThroughputBps
+			row.MeanFinalCwnd += float64(run.FinalCwnd)
+			row.MeanRetransmissions += float64(run.TotalRetransmissions)
+			row.Runs++
+			total += size
+		}
+		if row.Runs > 0 {
+			n := float64(row.Runs)
+			row.MeanCompletionSeconds /= n
+			row.MeanThroughputBps /= n
+			row.MeanFinalCwnd /= n
+			row.MeanRetransmissions /= n
+		}
+		res.Sweep = append(res.Sweep, row)
+	}
+	res.finish(nil, total, err)
+	res.DurationSeconds = time.Since(start).Seconds()
+
+	if output != "" {
+		if err := writeSweepTable(output, res.Sweep); err != nil {
+			res.finish(nil, 0, failure(exitUsage, "failed to write %s: %w", output, err))
+		}
+	}
+	if err := c.logger.LogSummary("sweep", strconv.FormatInt(start.Unix(), 10), start, res); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save sweep summary: %v\n", err)
+	}
+	return res
+}
+
+// writeSweepTable writes the sweep rows as CSV
+func writeSweepTable(path string, rows []sweepRow) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(sweepColumns)
+	for i := range rows {
+		w.Write(rows[i].record())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGeometricSizes(t *testing.T) {
+	tests := []struct {
+		min, max int64
+		factor   float64
+		want     []int64
+	}{
+		{min: 1, max: 1024, factor: 4, want: []int64{1, 4, 16, 64, 256, 1024}},
+		{min: 1024, max: 5000, factor: 2, want: []int64{1024, 2048, 4096}},
+		{min: 100, max: 100, factor: 2, want: []int64{100}},
+		// Sizes that round to the previous one are skipped
+		{min: 1, max: 5, factor: 1.5, want: []int64{1, 2, 3}},
+		{min: 1000, max: 1500, factor: 1.2, want: []int64{1000, 1200, 1440}},
+		{min: 10, max: 5, factor: 2, want: nil},
+	}
+	for _, tt := range tests {
+		if got := geometricSizes(tt.min, tt.max, tt.factor); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("geometricSizes(%d, %d, %g) = %v, want %v", tt.min, tt.max, tt.factor, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ConfigFlags holds the -config flag and the scenario metadata flags shared by
+// client and server
+type ConfigFlags struct {
+	fs        *flag.FlagSet
+	path      string
+	scenario  string
+	container string
+	explicit  map[string]bool // Flags given on the command line
+}
+
+// RegisterConfigFlags defines -config, -scenario and -container-name
+func RegisterConfigFlags(fs *flag.FlagSet) *ConfigFlags {
+	c := &ConfigFlags{fs: fs}
+	fs.StringVar(&c.path, "config", "", "JSON configuration file keyed by flag name; command line flags and env override it")
+	fs.StringVar(&c.scenario, "scenario", "", "Scenario name for logs (overrides SCENARIO)")
+	fs.StringVar(&c.container, "container-name", "", "Container name for logs (overrides CONTAINER_NAME)")
+	return c
+}
+
+// Load applies the configuration file to every flag not given on the command
+// line. Call it after parsing the flags. Keys are flag names, with '_' accepted
+// for '-'; values may be strings, numbers or booleans.
+func (c *ConfigFlags) Load() error {
+	c.explicit = make(map[string]bool)
+	c.fs.Visit(func(f *flag.Flag) { c.explicit[f.Name] = true })
+	if c.path == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var values map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", c.path, err)
+	}
+
+	for _, key := range sortedConfigKeys(values) {
+		name := strings.ReplaceAll(key, "_", "-")
+		if c.fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown option %q in config %s", key, c.path)
+		}
+		if c.explicit[name] {
+			continue
+		}
+
+		var value string
+		switch v := values[key].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("option %q in config %s must be a string, number or boolean", key, c.path)
+		}
+		if err := c.fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %q in config %s: %v", key, c.path, err)
+		}
+	}
+	return nil
+}
+
+// Metadata returns the scenario and container name to use for logs. A command
+// line flag wins, then the SCENARIO and CONTAINER_NAME environment variables,
+// then the configuration file. Empty values leave the logger's own lookup alone.
+func (c *ConfigFlags) Metadata() (scenario, container string) {
+	if c.explicit["scenario"] || os.Getenv("SCENARIO") == "" {
+		scenario = c.scenario
+	}
+	if c.explicit["container-name"] || os.Getenv("CONTAINER_NAME") == "" {
+		container = c.container
+	}
+	return scenario, container
+}
+
+// Effective returns the final value of every flag, for reproducing a run
+func (c *ConfigFlags) Effective() map[string]string {
+	values := make(map[string]string)
+	c.fs.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	return values
+}
+
+func sortedConfigKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
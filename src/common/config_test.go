@@ -0,0 +1,129 @@
+package common
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// configFlagSet defines a few flags of each kind next to the config flags
+func configFlagSet() (*flag.FlagSet, *ConfigFlags) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("server", "localhost:8080", "")
+	fs.Int("read-timeout", 30, "")
+	fs.Bool("zero-copy", false, "")
+	fs.Duration("interval", time.Second, "")
+	fs.Float64("factor", 4, "")
+	return fs, RegisterConfigFlags(fs)
+}
+
+func TestConfigLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string // Empty for no -config
+		args    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "no config",
+			args: []string{"-server", "a:1"},
+			want: map[string]string{"server": "a:1", "read-timeout": "30"},
+		},
+		{
+			name:   "config values",
+			config: `{"server": "b:2", "read_timeout": 5, "zero-copy": true, "interval": "250ms", "factor": 1.5, "scenario": "wan"}`,
+			want:   map[string]string{"server": "b:2", "read-timeout": "5", "zero-copy": "true", "interval": "250ms", "factor": "1.5", "scenario": "wan"},
+		},
+		{
+			name:   "command line wins",
+			config: `{"server": "b:2", "read-timeout": 5}`,
+			args:   []string{"-read-timeout", "7"},
+			want:   map[string]string{"server": "b:2", "read-timeout": "7"},
+		},
+		{name: "unknown key", config: `{"sever": "b:2"}`, wantErr: true},
+		{name: "nested config", config: `{"config": "other.json"}`, wantErr: true},
+		{name: "bad value", config: `{"read-timeout": "soon"}`, wantErr: true},
+		{name: "fractional int", config: `{"read-timeout": 1.5}`, wantErr: true},
+		{name: "list value", config: `{"server": ["a", "b"]}`, wantErr: true},
+		{name: "not an object", config: `["server"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		fs, c := configFlagSet()
+		args := tt.args
+		if tt.config != "" {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			args = append([]string{"-config", path}, args...)
+		}
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+
+		err := c.Load()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: Load() succeeded", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Load() failed: %v", tt.name, err)
+			continue
+		}
+		effective := c.Effective()
+		for name, want := range tt.want {
+			if effective[name] != want {
+				t.Errorf("%s: -%s = %q, want %q", tt.name, name, effective[name], want)
+			}
+		}
+	}
+
+	fs, c := configFlagSet()
+	fs.Parse([]string{"-config", filepath.Join(t.TempDir(), "missing.json")})
+	if err := c.Load(); err == nil {
+		t.Errorf("Load() of a missing config succeeded")
+	}
+}
+
+func TestConfigMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"scenario": "from-config", "container-name": "config-box"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                        string
+		args                        []string
+		envScenario, envContainer   string
+		wantScenario, wantContainer string
+	}{
+		{name: "config", wantScenario: "from-config", wantContainer: "config-box"},
+		// An empty result leaves the environment to the logger
+		{name: "env over config", envScenario: "from-env", envContainer: "env-box"},
+		{
+			name:         "flag over env",
+			args:         []string{"-scenario", "from-flag"},
+			envScenario:  "from-env",
+			wantScenario: "from-flag", wantContainer: "config-box",
+		},
+	}
+	for _, tt := range tests {
+		t.Setenv("SCENARIO", tt.envScenario)
+		t.Setenv("CONTAINER_NAME", tt.envContainer)
+		fs, c := configFlagSet()
+		if err := fs.Parse(append([]string{"-config", path}, tt.args...)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if scenario, container := c.Metadata(); scenario != tt.wantScenario || container != tt.wantContainer {
+			t.Errorf("%s: Metadata() = %q, %q, want %q, %q", tt.name, scenario, container, tt.wantScenario, tt.wantContainer)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// rusageThread is RUSAGE_THREAD, missing from the syscall package
+const rusageThread = 1
+
+// CPUTimer measures the CPU time a goroutine spends on a transfer. The goroutine is
+// pinned to its OS thread in between, so the per-thread usage reported by the
+// kernel, including time in sendfile and splice, belongs to the transfer alone.
+type CPUTimer struct {
+	start   syscall.Rusage
+	ok      bool
+	stopped bool
+}
+
+// StartCPUTimer pins the calling goroutine to its thread and starts measuring.
+// Stop must be called from the same goroutine.
+func StartCPUTimer() *CPUTimer {
+	runtime.LockOSThread()
+	t := &CPUTimer{}
+	t.ok = syscall.Getrusage(rusageThread, &t.start) == nil
+	return t
+}
+
+// Stop returns the user and system CPU time used since StartCPUTimer and unpins
+// the goroutine. Both are zero if the kernel could not report them or the timer
+// was already stopped.
+func (t *CPUTimer) Stop() (user, system time.Duration) {
+	if t.stopped {
+		return 0, 0
+	}
+	t.stopped = true
+	defer runtime.UnlockOSThread()
+
+	var end syscall.Rusage
+	if !t.ok || syscall.Getrusage(rusageThread, &end) != nil {
+		return 0, 0
+	}
+	user = time.Duration(end.Utime.Nano() - t.start.Utime.Nano())
+	system = time.Duration(end.Stime.Nano() - t.start.Stime.Nano())
+	return user, system
+}
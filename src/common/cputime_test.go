@@ -0,0 +1,29 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCPUTimer(t *testing.T) {
+	timer := StartCPUTimer()
+	start := time.Now()
+	x := 0
+	for time.Since(start) < 50*time.Millisecond {
+		x++
+	}
+	user, system := timer.Stop()
+	if user+system < 10*time.Millisecond || user+system > time.Second {
+		t.Errorf("Stop() after 50ms of busy work = %v user, %v system", user, system)
+	}
+	if user, system := timer.Stop(); user != 0 || system != 0 {
+		t.Errorf("second Stop() = %v, %v, want zero", user, system)
+	}
+
+	// Sleeping uses no CPU
+	timer = StartCPUTimer()
+	time.Sleep(50 * time.Millisecond)
+	if user, system := timer.Stop(); user+system > 20*time.Millisecond {
+		t.Errorf("Stop() after sleeping = %v user, %v system", user, system)
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Event log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// EventLogFlags holds the command line flags of the event log. The event log
+// reports what a binary is doing as it happens; connection metrics go to the
+// ConnectionLog files instead.
+type EventLogFlags struct {
+	format string
+	level  string
+	file   string
+}
+
+// RegisterEventLogFlags defines the event log flags shared by client and server
+func RegisterEventLogFlags(fs *flag.FlagSet) *EventLogFlags {
+	f := &EventLogFlags{}
+	fs.StringVar(&f.format, "log-format", LogFormatText, "Event log format: text or json")
+	fs.StringVar(&f.level, "log-level", "info", "Event log level: debug, info, warn or error")
+	fs.StringVar(&f.file, "event-log", "", "Append the event log to this file instead of stderr")
+	return f
+}
+
+// Setup installs the event logger as the slog default. Every event carries the
+// component and, when set, the scenario. The returned function closes the log file.
+func (f *EventLogFlags) Setup(component, scenario string) (func(), error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(f.level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", f.level)
+	}
+
+	var out io.Writer = os.Stderr
+	closeLog := func() {}
+	if f.file != "" {
+		file, err := os.OpenFile(f.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open event log: %v", err)
+		}
+		out = file
+		closeLog = func() { file.Close() }
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch f.format {
+	case LogFormatText:
+		handler = slog.NewTextHandler(out, opts)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		closeLog()
+		return nil, fmt.Errorf("unknown log format %q (want %s or %s)", f.format, LogFormatText, LogFormatJSON)
+	}
+
+	logger := slog.New(handler).With("component", component)
+	if scenario != "" {
+		logger = logger.With("scenario", scenario)
+	}
+	slog.SetDefault(logger)
+	return closeLog, nil
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEventLogSetup(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{args: []string{"-log-format", "json", "-log-level", "warn"}},
+		{args: []string{"-log-format", "text", "-log-level", "debug"}},
+		{args: []string{"-log-format", "xml"}, wantErr: true},
+		{args: []string{"-log-level", "loud"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "events.log")
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags := RegisterEventLogFlags(fs)
+		if err := fs.Parse(append(tt.args, "-event-log", path)); err != nil {
+			t.Fatal(err)
+		}
+
+		closeLog, err := flags.Setup("server", "wan")
+		if tt.wantErr {
+			if err == nil {
+				closeLog()
+				t.Errorf("Setup() with %q succeeded", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Setup() with %q failed: %v", tt.args, err)
+		}
+		slog.Info("hidden at warn")
+		slog.Warn("upload failed", "bytes", 10)
+		closeLog()
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if fs.Lookup("log-format").Value.String() == LogFormatJSON {
+			if len(lines) != 1 {
+				t.Fatalf("JSON log at warn = %q, want one event", lines)
+			}
+			var event map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event["msg"] != "upload failed" || event["component"] != "server" || event["scenario"] != "wan" || event["bytes"] != 10.0 {
+				t.Errorf("JSON event = %v", event)
+			}
+			continue
+		}
+		if len(lines) != 2 || !strings.Contains(lines[1], `msg="upload failed" component=server scenario=wan bytes=10`) {
+			t.Errorf("text log at debug = %q", lines)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package common
+
+import "time"
+
+// IntervalReport is the progress of a transfer over one reporting interval,
+// derived from the TCP_INFO samples at its ends
+type IntervalReport struct {
+	Start       float64 `json:"start_seconds"`  // Since the connection started
+	End         float64 `json:"end_seconds"`    // Since the connection started
+	Bytes       uint64  `json:"bytes"`          // Acked (uploads) or received (downloads) in the interval
+	Throughput  float64 `json:"throughput_bps"` // Bytes per second over the interval
+	RTTMs       float64 `json:"rtt_ms"`         // Smoothed RTT at the end of the interval
+	Cwnd        uint32  `json:"snd_cwnd"`       // Congestion window at the end of the interval
+	Retransmits uint32  `json:"retransmits"`    // Retransmissions in the interval
+}
+
+// IntervalReporter turns TCP_INFO samples into a report per interval. Intervals
+// are aligned to the connection start and end at the first sample past their
+// boundary, so they last at least one sampling period longer than asked for
+// when the sampling period does not divide the interval. A nil reporter
+// ignores everything, so callers need not check whether reports are enabled.
+type IntervalReporter struct {
+	start    time.Time
+	interval time.Duration
+	counter  func(TCPInfo) uint64
+	emit     func(IntervalReport) // Called with every report as it ends, may be nil
+
+	base    *TCPInfo // Sample the current interval started at
+	next    time.Time
+	reports []IntervalReport
+}
+
+// NewIntervalReporter returns a reporter of the byte counter, such as
+// bytes_acked or bytes_received, for a connection started at start
+func NewIntervalReporter(start time.Time, interval time.Duration, counter func(TCPInfo) uint64, emit func(IntervalReport)) *IntervalReporter {
+	return &IntervalReporter{
+		start:    start,
+		interval: interval,
+		counter:  counter,
+		emit:     emit,
+		next:     start.Add(interval),
+	}
+}
+
+// Sample feeds the next sample; the first one is the baseline of the first
+// interval. It suits StartSampling's onSample, which calls it from one goroutine.
+func (r *IntervalReporter) Sample(s TCPInfo) {
+	if r == nil {
+		return
+	}
+	if r.base == nil {
+		r.base = &s
+		return
+	}
+	if s.Timestamp.Before(r.next) {
+		return
+	}
+	r.report(s)
+	for !s.Timestamp.Before(r.next) {
+		r.next = r.next.Add(r.interval)
+	}
+}
+
+// Finish reports the last, usually shorter, interval up to the final sample and
+// returns all reports. The last interval is left out if its byte counter did not
+// move, as when the transfer ended right at an interval boundary.
+func (r *IntervalReporter) Finish(final *TCPInfo) []IntervalReport {
+	if r == nil {
+		return nil
+	}
+	if final != nil && r.base != nil && r.counter(*final) != r.counter(*r.base) {
+		r.report(*final)
+	}
+	return r.reports
+}
+
+func (r *IntervalReporter) report(s TCPInfo) {
+	report := IntervalReport{
+		Start:       r.base.Timestamp.Sub(r.start).Seconds(),
+		End:         s.Timestamp.Sub(r.start).Seconds(),
+		RTTMs:       float64(s.RTT) / 1000,
+		Cwnd:        s.SndCwnd,
+		Retransmits: s.TotalRetrans - r.base.TotalRetrans,
+	}
+	if now, before := r.counter(s), r.counter(*r.base); now > before {
+		report.Bytes = now - before
+	}
+	if elapsed := report.End - report.Start; elapsed > 0 {
+		report.Throughput = float64(report.Bytes) / elapsed
+	}
+	r.reports = append(r.reports, report)
+	r.base = &s
+	if r.emit != nil {
+		r.emit(report)
+	}
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIntervalReporter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	// sample is taken ms milliseconds after the start with ms bytes acked
+	sample := func(ms int, retrans uint32) TCPInfo {
+		return TCPInfo{
+			Timestamp:    start.Add(time.Duration(ms) * time.Millisecond),
+			BytesAcked:   uint64(ms),
+			TotalRetrans: retrans,
+			RTT:          uint32(ms),
+			SndCwnd:      uint32(ms / 100),
+		}
+	}
+	acked := func(s TCPInfo) uint64 { return s.BytesAcked }
+	stalled := sample(1100, 0)
+	stalled.BytesAcked = 1000
+
+	tests := []struct {
+		name    string
+		samples []TCPInfo
+		final   *TCPInfo
+		want    []IntervalReport
+	}{
+		{
+			name:    "ends at the first sample past the boundary",
+			samples: []TCPInfo{sample(0, 0), sample(400, 0), sample(800, 1), sample(1200, 2), sample(1600, 2), sample(2000, 3)},
+			final:   &TCPInfo{Timestamp: start.Add(2500 * time.Millisecond), BytesAcked: 2500, TotalRetrans: 3},
+			want: []IntervalReport{
+				{Start: 0, End: 1.2, Bytes: 1200, Throughput: 1000, RTTMs: 1.2, Cwnd: 12, Retransmits: 2},
+				{Start: 1.2, End: 2, Bytes: 800, Throughput: 1000, RTTMs: 2, Cwnd: 20, Retransmits: 1},
+				{Start: 2, End: 2.5, Bytes: 500, Throughput: 1000},
+			},
+		},
+		{
+			name:    "skips intervals without a sample",
+			samples: []TCPInfo{sample(0, 0), sample(500, 0), sample(3500, 0), sample(3900, 0)},
+			final:   &TCPInfo{Timestamp: start.Add(4500 * time.Millisecond), BytesAcked: 4500},
+			want: []IntervalReport{
+				{Start: 0, End: 3.5, Bytes: 3500, Throughput: 1000, RTTMs: 3.5, Cwnd: 35},
+				{Start: 3.5, End: 4.5, Bytes: 1000, Throughput: 1000},
+			},
+		},
+		{
+			name:    "drops the tail when the counter did not move",
+			samples: []TCPInfo{sample(0, 0), sample(1000, 0)},
+			final:   &stalled,
+			want: []IntervalReport{
+				{Start: 0, End: 1, Bytes: 1000, Throughput: 1000, RTTMs: 1, Cwnd: 10},
+			},
+		},
+		{
+			name:    "no final sample",
+			samples: []TCPInfo{sample(0, 0), sample(1000, 0), sample(1500, 0)},
+			want: []IntervalReport{
+				{Start: 0, End: 1, Bytes: 1000, Throughput: 1000, RTTMs: 1, Cwnd: 10},
+			},
+		},
+		{
+			name:  "no samples",
+			final: &TCPInfo{Timestamp: start.Add(time.Second), BytesAcked: 1000},
+		},
+	}
+	for _, tt := range tests {
+		var emitted []IntervalReport
+		r := NewIntervalReporter(start, time.Second, acked, func(report IntervalReport) {
+			emitted = append(emitted, report)
+		})
+		for _, s := range tt.samples {
+			r.Sample(s)
+		}
+		got := r.Finish(tt.final)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: reports = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if fmt.Sprint(emitted) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: emitted %+v, want %+v", tt.name, emitted, tt.want)
+		}
+	}
+
+	// A nil reporter ignores everything
+	var r *IntervalReporter
+	r.Sample(sample(0, 0))
+	if got := r.Finish(&stalled); got != nil {
+		t.Errorf("nil reporter Finish() = %+v, want nil", got)
+	}
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,24 +12,152 @@ import (
 
 // ConnectionLog represents a connection's performance metrics
 type ConnectionLog struct {
+	ConnID                string            `json:"conn_id,omitempty"`
+	StartTime             time.Time         `json:"start_time"`
+	EndTime               time.Time         `json:"end_time"`
+	BytesSent             int64             `json:"bytes_sent"`
+	BytesReceived         int64             `json:"bytes_received"`
+	Duration              float64           `json:"duration_seconds"`
+	Throughput            float64           `json:"throughput_bps"`
+	RemoteAddr            string            `json:"remote_addr"`
+	Operation             string            `json:"operation"`
+	SessionID             string            `json:"session_id,omitempty"`                  // Client script session the connection belongs to
+	Step                  int               `json:"step,omitempty"`                        // Number of the script step that opened the connection
+	StreamID              int               `json:"stream_id,omitempty"`                   // Stream of a parallel transfer, counting from 1
+	StreamMode            string            `json:"stream_mode,omitempty"`                 // Parallel transfer mode of an aggregate record: share or copy
+	Streams               []StreamStat      `json:"streams,omitempty"`                     // Per-stream breakdown of an aggregate record
+	Fairness              float64           `json:"fairness,omitempty"`                    // Jain's fairness index of the stream throughputs
+	Warmup                float64           `json:"warmup_seconds,omitempty"`              // Start of a timed transfer left out of the steady state
+	Cooldown              float64           `json:"cooldown_seconds,omitempty"`            // End of a timed transfer left out of the steady state
+	SteadyStateThroughput float64           `json:"steady_state_throughput_bps,omitempty"` // Rate between warm-up and cool-down, from TCP_INFO byte counters
+	PingRTTsMs            []float64         `json:"ping_rtts_ms,omitempty"`                // Application-level round trips of PING frames
+	PersistentConn        string            `json:"persistent_conn,omitempty"`             // Conn id of the operation that opened the persistent connection this one reused
+	ConnReuse             int               `json:"conn_reuse,omitempty"`                  // Earlier operations carried by the same persistent connection
+	SlowStartAfterIdle    *bool             `json:"slow_start_after_idle,omitempty"`       // net.ipv4.tcp_slow_start_after_idle on this host during an idle experiment
+	IdleGaps              []IdleGap         `json:"idle_gaps,omitempty"`                   // Bursts of an idle experiment with the cwnd around the gap before each
+	OnOff                 string            `json:"on_off,omitempty"`                      // On/off traffic spec of a bursty upload
+	Events                []TransferEvent   `json:"events,omitempty"`                      // Points of interest during the transfer, such as burst boundaries
+	Intervals             []IntervalReport  `json:"intervals,omitempty"`                   // Per-interval progress reported live during the transfer
+	CloseReason           string            `json:"close_reason,omitempty"`                // Why the connection ended (quit, idle timeout, rejection reason, ...)
+	Listener              string            `json:"listener,omitempty"`                    // Server listener that accepted the connection
+	ListenerProfile       string            `json:"listener_profile,omitempty"`            // Listener profile (from -listeners) that served the connection
+	Scenario              string            `json:"scenario,omitempty"`
+	ContainerName         string            `json:"container_name,omitempty"`
+	InitialRTTMs          float64           `json:"initial_rtt_ms,omitempty"`
+	FinalRTTMs            float64           `json:"final_rtt_ms,omitempty"`
+	InitialCwnd           uint32            `json:"initial_cwnd,omitempty"`
+	FinalCwnd             uint32            `json:"final_cwnd,omitempty"`
+	InitialSsthresh       uint32            `json:"initial_ssthresh,omitempty"`
+	FinalSsthresh         uint32            `json:"final_ssthresh,omitempty"`
+	TotalRetransmissions  uint32            `json:"total_retransmissions,omitempty"`
+	CongestionControl     string            `json:"congestion_control,omitempty"`      // Algorithm in effect on this side
+	PeerCongestionControl string            `json:"peer_congestion_control,omitempty"` // Algorithm the peer reported after negotiation
+	SocketProfile         string            `json:"socket_profile,omitempty"`
+	SocketOptions         *SocketProfile    `json:"socket_options,omitempty"`      // Effective socket options read back from the kernel
+	Namespace             string            `json:"namespace,omitempty"`           // Server storage namespace used by the connection
+	ReadThrottle          string            `json:"read_throttle,omitempty"`       // Slow-reader emulation applied by the server
+	RwndLimitedMs         float64           `json:"rwnd_limited_ms,omitempty"`     // Time the sender was limited by the receive window
+	SndbufLimitedMs       float64           `json:"sndbuf_limited_ms,omitempty"`   // Time the sender was limited by the send buffer
+	Pacing                string            `json:"pacing,omitempty"`              // Application-level send rate limit
+	PacingWaitMs          float64           `json:"pacing_wait_ms,omitempty"`      // Time the sender held data back to keep to the pacing rate
+	AppLimitedSamples     int               `json:"app_limited_samples,omitempty"` // TCP_INFO samples whose delivery rate was app-limited
+	ZeroCopy              bool              `json:"zero_copy,omitempty"`           // Payload moved with sendfile/splice instead of user-space copies
+	Payload               string            `json:"payload,omitempty"`             // Synthetic payload spec the upload was generated from
+	VerifiedBytes         int64             `json:"verified_bytes,omitempty"`      // Upload bytes the server regenerated from the payload seed and found matching
+	VerifyError           string            `json:"verify_error,omitempty"`        // First mismatch between the upload and its payload
+	CPUUserMs             float64           `json:"cpu_user_ms,omitempty"`         // CPU time spent moving payload, user space
+	CPUSystemMs           float64           `json:"cpu_system_ms,omitempty"`       // CPU time spent moving payload, kernel
+	FsyncPolicy           string            `json:"fsync_policy,omitempty"`
+	FsyncCount            int               `json:"fsync_count,omitempty"`
+	FsyncTimeMs           float64           `json:"fsync_time_ms,omitempty"` // Time spent flushing uploads to disk
+	Relay                 *RelayHop         `json:"relay,omitempty"`         // Upstream hop when the server relays uploads
+	Config                map[string]string `json:"config,omitempty"`        // Effective configuration of the binary that wrote the log
+	TCPSamples            []TCPInfo         `json:"tcp_samples,omitempty"`   // TCP_INFO samples collected during connection
+}
+
+// StreamStat summarizes one stream of a parallel transfer in its aggregate record.
+// The stream's own connection log has the full details.
+type StreamStat struct {
+	StreamID             int     `json:"stream_id"`
+	ConnID               string  `json:"conn_id,omitempty"`
+	Bytes                int64   `json:"bytes"` // Payload bytes
+	Duration             float64 `json:"duration_seconds"`
+	Throughput           float64 `json:"throughput_bps"` // Payload bytes per second
+	FinalCwnd            uint32  `json:"final_cwnd,omitempty"`
+	TotalRetransmissions uint32  `json:"total_retransmissions,omitempty"`
+	Error                string  `json:"error,omitempty"`
+}
+
+// IdleGap is one gap of an idle experiment: the connection goes quiet for Gap
+// seconds between two bursts, and the cwnd on either side shows whether the
+// kernel restarted slow start
+type IdleGap struct {
+	Gap             float64 `json:"gap_seconds"`
+	RTOMs           float64 `json:"rto_ms"`         // RTO when the gap began; longer gaps count as idle
+	CwndBefore      uint32  `json:"cwnd_before"`    // After the previous burst was acknowledged
+	CwndAfter       uint32  `json:"cwnd_after"`     // Right after the first bytes of the next burst went out
+	SsthreshAfter   uint32  `json:"ssthresh_after"` // Right after the first bytes of the next burst went out
+	CwndEnd         uint32  `json:"cwnd_end"`       // After the next burst was acknowledged
+	BurstBytes      int64   `json:"burst_bytes"`
+	BurstDuration   float64 `json:"burst_seconds"`        // From the first chunk to the server's answer
+	BurstThroughput float64 `json:"burst_throughput_bps"` // Burst bytes per second
+}
+
+// Transfer event types
+const (
+	EventBurstStart = "burst_start"
+	EventBurstEnd   = "burst_end" // The burst's last bytes were handed to the socket
+)
+
+// TransferEvent marks a point of a transfer, with the cwnd sampled right then
+type TransferEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	Burst     int       `json:"burst,omitempty"` // Burst number, counting from 1
+	Bytes     int64     `json:"bytes"`           // Payload bytes sent so far
+	Cwnd      uint32    `json:"snd_cwnd,omitempty"`
+}
+
+// JainFairness returns Jain's fairness index of the given throughputs: 1 when all
+// are equal, down to 1/n when one stream gets everything
+func JainFairness(throughputs []float64) float64 {
+	var sum, squares float64
+	for _, t := range throughputs {
+		sum += t
+		squares += t * t
+	}
+	if squares == 0 {
+		return 0
+	}
+	return sum * sum / (float64(len(throughputs)) * squares)
+}
+
+// Relay bottleneck verdicts
+const (
+	BottleneckDownstream = "downstream" // The relay mostly waited for data from the client
+	BottleneckUpstream   = "upstream"   // The relay mostly waited for the upstream socket to accept data
+	BottleneckBalanced   = "balanced"
+)
+
+// RelayHop describes the upstream hop of a relayed connection. The connection log
+// around it describes the downstream hop.
+type RelayHop struct {
+	Upstream             string    `json:"upstream"`
 	StartTime            time.Time `json:"start_time"`
 	EndTime              time.Time `json:"end_time"`
 	BytesSent            int64     `json:"bytes_sent"`
 	BytesReceived        int64     `json:"bytes_received"`
-	Duration             float64   `json:"duration_seconds"`
-	Throughput           float64   `json:"throughput_bps"`
-	RemoteAddr           string    `json:"remote_addr"`
-	Operation            string    `json:"operation"`
-	Scenario             string    `json:"scenario,omitempty"`
-	ContainerName        string    `json:"container_name,omitempty"`
+	CongestionControl    string    `json:"congestion_control,omitempty"`
+	ReadWaitMs           float64   `json:"read_wait_ms"`  // Time spent waiting for data from downstream
+	WriteWaitMs          float64   `json:"write_wait_ms"` // Time spent waiting for the upstream socket
+	Bottleneck           string    `json:"bottleneck"`
 	InitialRTTMs         float64   `json:"initial_rtt_ms,omitempty"`
 	FinalRTTMs           float64   `json:"final_rtt_ms,omitempty"`
-	InitialCwnd          uint32    `json:"initial_cwnd,omitempty"`
 	FinalCwnd            uint32    `json:"final_cwnd,omitempty"`
-	InitialSsthresh      uint32    `json:"initial_ssthresh,omitempty"`
-	FinalSsthresh        uint32    `json:"final_ssthresh,omitempty"`
 	TotalRetransmissions uint32    `json:"total_retransmissions,omitempty"`
-	TCPSamples           []TCPInfo `json:"tcp_samples,omitempty"` // TCP_INFO samples collected during connection
+	RwndLimitedMs        float64   `json:"rwnd_limited_ms,omitempty"`
+	SndbufLimitedMs      float64   `json:"sndbuf_limited_ms,omitempty"`
+	TCPSamples           []TCPInfo `json:"tcp_samples,omitempty"` // TCP_INFO samples of the upstream socket
 }
 
 // Logger handles connection logging
@@ -36,6 +165,7 @@ type Logger struct {
 	logDir        string
 	scenario      string
 	containerName string
+	config        map[string]string
 }
 
 // NewLogger creates a new logger instance
@@ -72,6 +202,27 @@ func NewLogger(logDir string) *Logger {
 	}
 }
 
+// SetMetadata overrides the scenario and container name found in the environment;
+// empty values are ignored
+func (l *Logger) SetMetadata(scenario, container string) {
+	if scenario != "" {
+		l.scenario = scenario
+	}
+	if container != "" {
+		l.containerName = container
+	}
+}
+
+// SetConfig records the effective configuration written into every connection log
+func (l *Logger) SetConfig(config map[string]string) {
+	l.config = config
+}
+
+// Scenario returns the scenario name logs are filed under
+func (l *Logger) Scenario() string {
+	return l.scenario
+}
+
 // LogConnection saves connection metrics to a JSON file
 func (l *Logger) LogConnection(log *ConnectionLog) error {
 	// Ensure scenario/container metadata is present in the log (prefer explicit values on the struct)
@@ -81,6 +232,9 @@ func (l *Logger) LogConnection(log *ConnectionLog) error {
 	if log.ContainerName == "" {
 		log.ContainerName = l.containerName
 	}
+	if log.Config == nil {
+		log.Config = l.config
+	}
 
 	// Calculate derived metrics
 	log.Duration = log.EndTime.Sub(log.StartTime).Seconds()
@@ -100,8 +254,51 @@ func (l *Logger) LogConnection(log *ConnectionLog) error {
 		log.InitialSsthresh = first.SndSsthresh
 		log.FinalSsthresh = last.SndSsthresh
 		log.TotalRetransmissions = last.TotalRetrans
+		log.RwndLimitedMs = float64(last.RwndLimited) / 1000.0
+		log.SndbufLimitedMs = float64(last.SndbufLimited) / 1000.0
+
+		log.AppLimitedSamples = 0
+		for _, sample := range log.TCPSamples {
+			if sample.AppLimited {
+				log.AppLimitedSamples++
+			}
+		}
 	}
 
+	if r := log.Relay; r != nil && len(r.TCPSamples) > 0 {
+		first := r.TCPSamples[0]
+		last := r.TCPSamples[len(r.TCPSamples)-1]
+
+		r.InitialRTTMs = float64(first.RTT) / 1000.0
+		r.FinalRTTMs = float64(last.RTT) / 1000.0
+		r.FinalCwnd = last.SndCwnd
+		r.TotalRetransmissions = last.TotalRetrans
+		r.RwndLimitedMs = float64(last.RwndLimited) / 1000.0
+		r.SndbufLimitedMs = float64(last.SndbufLimited) / 1000.0
+	}
+
+	filename, err := l.writeLog("connection", log.Scenario, log.ContainerName, log.StartTime, log.ConnID, log)
+	if err != nil {
+		return err
+	}
+	slog.Info("connection log saved", "conn_id", log.ConnID, "file", filename)
+	return nil
+}
+
+// LogSummary saves a summary of several connections, such as a client session,
+// next to their connection logs. kind prefixes the file name instead of "connection".
+func (l *Logger) LogSummary(kind, id string, start time.Time, summary interface{}) error {
+	filename, err := l.writeLog(kind, l.scenario, l.containerName, start, id, summary)
+	if err != nil {
+		return err
+	}
+	slog.Info("summary log saved", "kind", kind, "id", id, "file", filename)
+	return nil
+}
+
+// writeLog writes v as JSON to a new file in the scenario's log directory and
+// returns the file name
+func (l *Logger) writeLog(kind, scenario, container string, start time.Time, id string, v interface{}) (string, error) {
 	// Create filename with timestamp — include scenario and container name (sanitized)
 	sanitize := func(s string) string {
 		if s == "" {
@@ -116,32 +313,39 @@ func (l *Logger) LogConnection(log *ConnectionLog) error {
 	}
 
 	// Ensure we write logs under the per-scenario folder inside the shared log directory.
-	scenarioName := sanitize(log.Scenario)
+	scenarioName := sanitize(scenario)
 	scenarioDir := filepath.Join(l.logDir, scenarioName)
 	if err := os.MkdirAll(scenarioDir, 0755); err != nil {
-		return fmt.Errorf("failed to create scenario log dir: %v", err)
+		return "", fmt.Errorf("failed to create scenario log dir: %v", err)
 	}
 
-	filename := filepath.Join(scenarioDir, fmt.Sprintf("connection_%s_%s_%s.json",
+	base := fmt.Sprintf("%s_%s_%s_%s",
+		kind,
 		scenarioName,
-		sanitize(log.ContainerName),
-		log.StartTime.Format("20060102_150405")))
+		sanitize(container),
+		start.Format("20060102_150405"))
+	if id != "" {
+		base += "_" + sanitize(id)
+	}
 
-	// Write to file
-	file, err := os.Create(filename)
+	// Write to file; never overwrite a log from another connection started in the same second
+	filename := filepath.Join(scenarioDir, base+".json")
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	for i := 2; os.IsExist(err); i++ {
+		filename = filepath.Join(scenarioDir, fmt.Sprintf("%s_%d.json", base, i))
+		file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create log file: %v", err)
+		return "", fmt.Errorf("failed to create log file: %v", err)
 	}
 	defer file.Close()
 
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(log); err != nil {
-		return fmt.Errorf("failed to encode log: %v", err)
+	if err := encoder.Encode(v); err != nil {
+		return "", fmt.Errorf("failed to encode log: %v", err)
 	}
-
-	fmt.Printf("Connection log saved: %s\n", filename)
-	return nil
+	return filename, nil
 }
 
 // PrintSummary prints connection summary to console
@@ -149,10 +353,51 @@ func (l *Logger) PrintSummary(log *ConnectionLog) {
 	fmt.Printf("\n=== Connection Summary ===\n")
 	fmt.Printf("Remote: %s\n", log.RemoteAddr)
 	fmt.Printf("Operation: %s\n", log.Operation)
+	if log.CloseReason != "" {
+		fmt.Printf("Close Reason: %s\n", log.CloseReason)
+	}
 	fmt.Printf("Duration: %.2f seconds\n", log.Duration)
 	fmt.Printf("Bytes Sent: %d\n", log.BytesSent)
 	fmt.Printf("Bytes Received: %d\n", log.BytesReceived)
 	fmt.Printf("Throughput: %.2f bytes/sec\n", log.Throughput)
+	if log.CongestionControl != "" {
+		fmt.Printf("Congestion Control: %s\n", log.CongestionControl)
+	}
+	if log.SteadyStateThroughput > 0 {
+		fmt.Printf("Steady-State Throughput: %.2f bytes/sec (excluding %.1fs warm-up, %.1fs cool-down)\n", log.SteadyStateThroughput, log.Warmup, log.Cooldown)
+	}
+	if log.Payload != "" {
+		fmt.Printf("Payload: %s\n", log.Payload)
+	}
+	if log.VerifiedBytes > 0 || log.VerifyError != "" {
+		status := "ok"
+		if log.VerifyError != "" {
+			status = log.VerifyError
+		}
+		fmt.Printf("Verified: %d bytes (%s)\n", log.VerifiedBytes, status)
+	}
+	if log.OnOff != "" {
+		bursts := 0
+		for _, e := range log.Events {
+			if e.Type == EventBurstStart {
+				bursts++
+			}
+		}
+		fmt.Printf("On/Off: %s (%d bursts)\n", log.OnOff, bursts)
+	}
+	if log.Pacing != "" {
+		fmt.Printf("Pacing: %s (held back %.2f ms)\n", log.Pacing, log.PacingWaitMs)
+	}
+	if log.CPUUserMs > 0 || log.CPUSystemMs > 0 {
+		mode := "copy"
+		if log.ZeroCopy {
+			mode = "zero-copy"
+		}
+		fmt.Printf("CPU: %.2f ms user, %.2f ms system (%s)\n", log.CPUUserMs, log.CPUSystemMs, mode)
+	}
+	if log.FsyncCount > 0 {
+		fmt.Printf("Fsync: %d calls, %.2f ms (%s)\n", log.FsyncCount, log.FsyncTimeMs, log.FsyncPolicy)
+	}
 
 	// Show TCP_INFO summary if available
 	if len(log.TCPSamples) > 0 {
@@ -170,6 +415,50 @@ func (l *Logger) PrintSummary(log *ConnectionLog) {
 		fmt.Printf("Initial ssthresh: %d, Final ssthresh: %d\n",
 			first.SndSsthresh, last.SndSsthresh)
 		fmt.Printf("Total Retransmissions: %d\n", last.TotalRetrans)
+		if last.BusyTime > 0 {
+			fmt.Printf("Busy: %.2f ms, rwnd limited: %.2f ms, sndbuf limited: %.2f ms\n",
+				float64(last.BusyTime)/1000.0, float64(last.RwndLimited)/1000.0, float64(last.SndbufLimited)/1000.0)
+		}
+		fmt.Printf("---------------------------\n")
+	}
+
+	if len(log.Streams) > 0 {
+		fmt.Printf("\n--- Streams (%s, fairness %.3f) ---\n", log.StreamMode, log.Fairness)
+		for _, st := range log.Streams {
+			if st.Error != "" {
+				fmt.Printf("Stream %d: failed: %s\n", st.StreamID, st.Error)
+				continue
+			}
+			fmt.Printf("Stream %d: %d bytes in %.2f s, %.2f bytes/sec, final cwnd %d, retransmissions %d\n",
+				st.StreamID, st.Bytes, st.Duration, st.Throughput, st.FinalCwnd, st.TotalRetransmissions)
+		}
+		fmt.Printf("---------------------------\n")
+	}
+
+	if len(log.IdleGaps) > 0 {
+		restart := "unknown"
+		if log.SlowStartAfterIdle != nil {
+			restart = map[bool]string{true: "on", false: "off"}[*log.SlowStartAfterIdle]
+		}
+		fmt.Printf("\n--- Idle Gaps (slow start after idle: %s) ---\n", restart)
+		for _, g := range log.IdleGaps {
+			fmt.Printf("Gap %.3f s (RTO %.0f ms): cwnd %d -> %d (ssthresh %d), burst of %d bytes in %.3f s, %.2f bytes/sec, cwnd %d at its end\n",
+				g.Gap, g.RTOMs, g.CwndBefore, g.CwndAfter, g.SsthreshAfter, g.BurstBytes, g.BurstDuration, g.BurstThroughput, g.CwndEnd)
+		}
+		fmt.Printf("---------------------------\n")
+	}
+
+	if r := log.Relay; r != nil {
+		fmt.Printf("\n--- Upstream Hop (%s) ---\n", r.Upstream)
+		fmt.Printf("Bytes Sent: %d, Bytes Received: %d\n", r.BytesSent, r.BytesReceived)
+		if r.CongestionControl != "" {
+			fmt.Printf("Congestion Control: %s\n", r.CongestionControl)
+		}
+		fmt.Printf("Waiting on downstream: %.2f ms, on upstream: %.2f ms\n", r.ReadWaitMs, r.WriteWaitMs)
+		fmt.Printf("Bottleneck: %s\n", r.Bottleneck)
+		if len(r.TCPSamples) > 0 {
+			fmt.Printf("Final RTT: %.2f ms, Final cwnd: %d, Retransmissions: %d\n", r.FinalRTTMs, r.FinalCwnd, r.TotalRetransmissions)
+		}
 		fmt.Printf("---------------------------\n")
 	}
 
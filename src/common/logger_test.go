@@ -0,0 +1,26 @@
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJainFairness(t *testing.T) {
+	tests := []struct {
+		throughputs []float64
+		want        float64
+	}{
+		{[]float64{10}, 1},
+		{[]float64{5, 5, 5, 5}, 1},
+		{[]float64{10, 0}, 0.5},
+		{[]float64{10, 0, 0, 0}, 0.25},
+		{[]float64{1, 2, 3}, 36.0 / 42},
+		{nil, 0},
+		{[]float64{0, 0}, 0},
+	}
+	for _, tt := range tests {
+		if got := JainFairness(tt.throughputs); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("JainFairness(%v) = %v, want %v", tt.throughputs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minPacingBurst is the smallest default bucket size
+const minPacingBurst = 16 * 1024
+
+// RateStep changes the pacing rate at a point of a transfer
+type RateStep struct {
+	At   time.Duration // Relative to the first write
+	Rate int64         // Bytes per second, 0 for unlimited
+}
+
+// Pacing is an application-level send rate limit: a token bucket whose rate may
+// follow a schedule over the transfer
+type Pacing struct {
+	Rate     int64 // Initial bytes per second, 0 for unlimited
+	Burst    int64 // Bucket size in bytes, 0 for 10ms worth of data (at least 16 KB)
+	Schedule []RateStep
+}
+
+// ParsePacing parses a pacing spec such as "rate=1048576,burst=65536,step=10s:524288,step=20s:0".
+// An empty spec disables pacing.
+func ParsePacing(spec string) (*Pacing, error) {
+	p := &Pacing{}
+	if strings.TrimSpace(spec) == "" {
+		return p, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pacing entry %q", part)
+		}
+
+		switch key {
+		case "rate":
+			rate, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || rate < 0 {
+				return nil, fmt.Errorf("invalid pacing rate %q", value)
+			}
+			p.Rate = rate
+		case "burst":
+			burst, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid pacing burst %q", value)
+			}
+			p.Burst = burst
+		case "step":
+			at, rate, ok := strings.Cut(value, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid pacing step %q (want <at>:<rate>)", value)
+			}
+			var s RateStep
+			var err error
+			if s.At, err = time.ParseDuration(at); err != nil || s.At < 0 {
+				return nil, fmt.Errorf("invalid pacing step time %q", at)
+			}
+			if s.Rate, err = strconv.ParseInt(rate, 10, 64); err != nil || s.Rate < 0 {
+				return nil, fmt.Errorf("invalid pacing step rate %q", rate)
+			}
+			if n := len(p.Schedule); n > 0 && s.At <= p.Schedule[n-1].At {
+				return nil, fmt.Errorf("pacing steps must be in increasing time order")
+			}
+			p.Schedule = append(p.Schedule, s)
+		default:
+			return nil, fmt.Errorf("unknown pacing key %q", key)
+		}
+	}
+
+	return p, nil
+}
+
+// Enabled reports whether the pacing limits sending at all
+func (p *Pacing) Enabled() bool {
+	if p == nil {
+		return false
+	}
+	if p.Rate > 0 {
+		return true
+	}
+	for _, s := range p.Schedule {
+		if s.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RateAt returns the configured rate at elapsed time into a transfer
+func (p *Pacing) RateAt(elapsed time.Duration) int64 {
+	rate := p.Rate
+	for _, s := range p.Schedule {
+		if elapsed < s.At {
+			break
+		}
+		rate = s.Rate
+	}
+	return rate
+}
+
+// String describes the pacing for logs
+func (p *Pacing) String() string {
+	if !p.Enabled() {
+		return ""
+	}
+	var parts []string
+	if p.Rate > 0 {
+		parts = append(parts, fmt.Sprintf("rate=%d", p.Rate))
+	}
+	if p.Burst > 0 {
+		parts = append(parts, fmt.Sprintf("burst=%d", p.Burst))
+	}
+	for _, s := range p.Schedule {
+		parts = append(parts, fmt.Sprintf("step=%v:%d", s.At, s.Rate))
+	}
+	return strings.Join(parts, ",")
+}
+
+// burst returns the bucket size at rate
+func (p *Pacing) burst(rate int64) int64 {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	if b := rate / 100; b > minPacingBurst {
+		return b
+	}
+	return minPacingBurst
+}
+
+// Writer returns a writer sending to w no faster than the pacing allows.
+// The schedule starts with the first write.
+func (p *Pacing) Writer(w io.Writer) *PacedWriter {
+	return &PacedWriter{w: w, p: p}
+}
+
+// PacedWriter is a token bucket in front of a writer
+type PacedWriter struct {
+	w      io.Writer
+	p      *Pacing
+	start  time.Time
+	last   time.Time // Last token refill
+	tokens float64
+	waited time.Duration
+}
+
+func (pw *PacedWriter) Write(b []byte) (int, error) {
+	if pw.start.IsZero() {
+		pw.start = time.Now()
+		pw.last = pw.start
+		pw.tokens = float64(pw.p.burst(pw.p.RateAt(0)))
+	}
+
+	written := 0
+	for written < len(b) {
+		rate := pw.p.RateAt(time.Since(pw.start))
+		if rate == 0 {
+			n, err := pw.w.Write(b[written:])
+			return written + n, err
+		}
+
+		burst := pw.p.burst(rate)
+		chunk := int64(len(b) - written)
+		if chunk > burst {
+			chunk = burst
+		}
+
+		pw.refill(rate, burst)
+		if missing := float64(chunk) - pw.tokens; missing > 0 {
+			wait := time.Duration(missing / float64(rate) * float64(time.Second))
+			time.Sleep(wait)
+			pw.waited += wait
+			pw.refill(rate, burst)
+		}
+		pw.tokens -= float64(chunk)
+
+		n, err := pw.w.Write(b[written : written+int(chunk)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// refill adds the tokens earned since the last refill, up to the bucket size
+func (pw *PacedWriter) refill(rate, burst int64) {
+	now := time.Now()
+	pw.tokens += now.Sub(pw.last).Seconds() * float64(rate)
+	if pw.tokens > float64(burst) {
+		pw.tokens = float64(burst)
+	}
+	pw.last = now
+}
+
+// Waited returns how long the writer held data back to keep to the rate
+func (pw *PacedWriter) Waited() time.Duration {
+	return pw.waited
+}
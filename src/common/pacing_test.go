@@ -0,0 +1,166 @@
+package common
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePacing(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    *Pacing
+		wantErr bool
+	}{
+		{spec: "", want: &Pacing{}},
+		{spec: "rate=1048576", want: &Pacing{Rate: 1048576}},
+		{spec: "rate=2097152, burst=65536", want: &Pacing{Rate: 2097152, Burst: 65536}},
+		{
+			spec: "rate=1000,step=10s:500,step=20s:0",
+			want: &Pacing{Rate: 1000, Schedule: []RateStep{{At: 10 * time.Second, Rate: 500}, {At: 20 * time.Second, Rate: 0}}},
+		},
+		{spec: "rate=-1", wantErr: true},
+		{spec: "rate=fast", wantErr: true},
+		{spec: "burst=0", wantErr: true},
+		{spec: "step=10s", wantErr: true},
+		{spec: "step=-1s:100", wantErr: true},
+		{spec: "step=20s:100,step=10s:50", wantErr: true},
+		{spec: "step=10s:100,step=10s:50", wantErr: true},
+		{spec: "rate", wantErr: true},
+		{spec: "speed=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePacing(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePacing(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePacing(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePacing(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestPacingStringRoundTrip(t *testing.T) {
+	for _, spec := range []string{"rate=1000", "rate=1000,burst=4096", "rate=1000,step=1s:0,step=2s:500", "step=5s:100"} {
+		p, err := ParsePacing(spec)
+		if err != nil {
+			t.Fatalf("ParsePacing(%q) failed: %v", spec, err)
+		}
+		if got := p.String(); got != spec {
+			t.Errorf("ParsePacing(%q).String() = %q", spec, got)
+		}
+	}
+}
+
+func TestPacingEnabledAndRateAt(t *testing.T) {
+	var none *Pacing
+	if none.Enabled() {
+		t.Errorf("nil pacing is enabled")
+	}
+	if (&Pacing{Schedule: []RateStep{{At: time.Second, Rate: 0}}}).Enabled() {
+		t.Errorf("pacing with only unlimited rates is enabled")
+	}
+
+	p := &Pacing{Rate: 100, Schedule: []RateStep{{At: time.Second, Rate: 50}, {At: 2 * time.Second, Rate: 0}}}
+	if !p.Enabled() {
+		t.Errorf("pacing with a rate is not enabled")
+	}
+	tests := []struct {
+		elapsed time.Duration
+		want    int64
+	}{
+		{0, 100},
+		{999 * time.Millisecond, 100},
+		{time.Second, 50},
+		{1500 * time.Millisecond, 50},
+		{2 * time.Second, 0},
+		{time.Hour, 0},
+	}
+	for _, tt := range tests {
+		if got := p.RateAt(tt.elapsed); got != tt.want {
+			t.Errorf("RateAt(%v) = %d, want %d", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestPacingBurst(t *testing.T) {
+	tests := []struct {
+		p    Pacing
+		rate int64
+		want int64
+	}{
+		{Pacing{Burst: 1000}, 1 << 30, 1000},
+		{Pacing{}, 1000, minPacingBurst},
+		{Pacing{}, 100 * minPacingBurst * 4, minPacingBurst * 4},
+	}
+	for _, tt := range tests {
+		if got := tt.p.burst(tt.rate); got != tt.want {
+			t.Errorf("burst(%d) of %+v = %d, want %d", tt.rate, tt.p, got, tt.want)
+		}
+	}
+}
+
+// chunkWriter records the size of every write
+type chunkWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	w.writes = append(w.writes, len(b))
+	return w.Buffer.Write(b)
+}
+
+func TestPacedWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		pacing  Pacing
+		size    int
+		minTime time.Duration // Lower bound of the time the writes take
+		maxTime time.Duration
+	}{
+		// The first burst goes out at once, the rest at the rate
+		{name: "rate", pacing: Pacing{Rate: 1 << 20, Burst: 16 << 10}, size: 128 << 10, minTime: 100 * time.Millisecond, maxTime: time.Second},
+		{name: "within burst", pacing: Pacing{Rate: 1 << 10, Burst: 64 << 10}, size: 64 << 10, maxTime: 50 * time.Millisecond},
+		{name: "unlimited", pacing: Pacing{}, size: 1 << 20, maxTime: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := &chunkWriter{}
+			pw := tt.pacing.Writer(dst)
+			data := bytes.Repeat([]byte{'x'}, tt.size)
+
+			start := time.Now()
+			n, err := pw.Write(data)
+			elapsed := time.Since(start)
+
+			if err != nil || n != tt.size {
+				t.Fatalf("Write = %d, %v, want %d, nil", n, err, tt.size)
+			}
+			if !bytes.Equal(dst.Bytes(), data) {
+				t.Errorf("written data differs")
+			}
+			if elapsed < tt.minTime || elapsed > tt.maxTime {
+				t.Errorf("Write took %v, want between %v and %v", elapsed, tt.minTime, tt.maxTime)
+			}
+			if tt.minTime > 0 && pw.Waited() < tt.minTime*9/10 {
+				t.Errorf("Waited() = %v, want about %v", pw.Waited(), tt.minTime)
+			}
+			if tt.pacing.Enabled() {
+				for _, w := range dst.writes {
+					if int64(w) > tt.pacing.burst(tt.pacing.Rate) {
+						t.Errorf("write of %d bytes exceeds the burst", w)
+					}
+				}
+			}
+		})
+	}
+}
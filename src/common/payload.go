@@ -0,0 +1,228 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Payload patterns
+const (
+	PatternRandom = "random" // Incompressible
+	PatternZeros  = "zeros"  // Maximally compressible
+	PatternText   = "text"   // Words from a seeded vocabulary; text:1 (large vocabulary) to text:9 (tiny)
+)
+
+// Payload describes synthetic upload data generated from a seeded PRNG, so the
+// receiver can regenerate and verify it without a copy on disk
+type Payload struct {
+	Pattern string
+	Level   int // Compressibility level of PatternText, 1 to 9
+	Seed    int64
+	Size    int64 // Bytes per upload
+}
+
+// ParsePayload parses a spec such as "random,seed=42,size=200MB" or
+// "text:6,size=1GB". The pattern comes first; seed defaults to 1 and size to 0.
+func ParsePayload(spec string) (*Payload, error) {
+	parts := strings.Split(spec, ",")
+	pattern, level, hasLevel := strings.Cut(strings.TrimSpace(parts[0]), ":")
+	p := &Payload{Pattern: pattern, Seed: 1}
+
+	switch pattern {
+	case PatternRandom, PatternZeros:
+		if hasLevel {
+			return nil, fmt.Errorf("pattern %s takes no level", pattern)
+		}
+	case PatternText:
+		p.Level = 5
+		if hasLevel {
+			l, err := strconv.Atoi(level)
+			if err != nil || l < 1 || l > 9 {
+				return nil, fmt.Errorf("invalid text level %q (want 1 to 9)", level)
+			}
+			p.Level = l
+		}
+	default:
+		return nil, fmt.Errorf("unknown payload pattern %q (want %s, %s or %s:N)", pattern, PatternRandom, PatternZeros, PatternText)
+	}
+
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid payload entry %q", part)
+		}
+		switch key {
+		case "seed":
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid payload seed %q", value)
+			}
+			p.Seed = seed
+		case "size":
+			size, err := ParseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			p.Size = size
+		default:
+			return nil, fmt.Errorf("unknown payload key %q", key)
+		}
+	}
+	return p, nil
+}
+
+// ParseSize parses a byte count with an optional K, M or G suffix (powers of
+// 1024, with or without a trailing B)
+func ParseSize(s string) (int64, error) {
+	value := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			value = value[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n * multiplier, nil
+}
+
+// String returns the spec of the payload, without the size if it is unset
+func (p *Payload) String() string {
+	pattern := p.Pattern
+	if p.Pattern == PatternText {
+		pattern = fmt.Sprintf("%s:%d", p.Pattern, p.Level)
+	}
+	if p.Size == 0 {
+		return fmt.Sprintf("%s,seed=%d", pattern, p.Seed)
+	}
+	return fmt.Sprintf("%s,seed=%d,size=%d", pattern, p.Seed, p.Size)
+}
+
+// Reader returns an endless reader of the payload's bytes. Every reader of the
+// same pattern and seed produces the same sequence, however it is read.
+func (p *Payload) Reader() io.Reader {
+	rng := rand.New(rand.NewSource(p.Seed))
+	switch p.Pattern {
+	case PatternZeros:
+		return zeroReader{}
+	case PatternText:
+		return newTextReader(rng, p.Level)
+	default:
+		return rng
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
+// textReader produces lines of words drawn from a vocabulary; smaller
+// vocabularies compress better
+type textReader struct {
+	rng     *rand.Rand
+	vocab   [][]byte
+	line    []byte
+	pending []byte // Part of line not read yet
+}
+
+func newTextReader(rng *rand.Rand, level int) *textReader {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	t := &textReader{rng: rng, vocab: make([][]byte, 1<<(13-level))}
+	for i := range t.vocab {
+		word := make([]byte, 2+rng.Intn(9))
+		for j := range word {
+			word[j] = letters[rng.Intn(len(letters))]
+		}
+		t.vocab[i] = word
+	}
+	return t
+}
+
+func (t *textReader) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		if len(t.pending) == 0 {
+			t.line = t.line[:0]
+			for words := 4 + t.rng.Intn(12); words > 0; words-- {
+				t.line = append(t.line, t.vocab[t.rng.Intn(len(t.vocab))]...)
+				t.line = append(t.line, ' ')
+			}
+			t.line[len(t.line)-1] = '\n'
+			t.pending = t.line
+		}
+		copied := copy(b[n:], t.pending)
+		t.pending = t.pending[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// Verifier is a writer that checks the data written to it against a payload
+type Verifier struct {
+	expected io.Reader
+	buf      []byte
+	written  int64
+	mismatch int64 // Offset of the first wrong byte, -1 while everything matches
+}
+
+// NewVerifier returns a verifier expecting the payload from its start
+func NewVerifier(p *Payload) *Verifier {
+	return &Verifier{expected: p.Reader(), mismatch: -1}
+}
+
+func (v *Verifier) Write(b []byte) (int, error) {
+	if v.mismatch < 0 {
+		if cap(v.buf) < len(b) {
+			v.buf = make([]byte, len(b))
+		}
+		expected := v.buf[:len(b)]
+		io.ReadFull(v.expected, expected)
+		if !bytes.Equal(b, expected) {
+			for i := range b {
+				if b[i] != expected[i] {
+					v.mismatch = v.written + int64(i)
+					break
+				}
+			}
+		}
+	}
+	v.written += int64(len(b))
+	return len(b), nil
+}
+
+// Verified returns how many bytes matched before the first mismatch
+func (v *Verifier) Verified() int64 {
+	if v.mismatch >= 0 {
+		return v.mismatch
+	}
+	return v.written
+}
+
+// Err reports the first mismatch, if any
+func (v *Verifier) Err() error {
+	if v.mismatch >= 0 {
+		return fmt.Errorf("payload mismatch at offset %d", v.mismatch)
+	}
+	return nil
+}
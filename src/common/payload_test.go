@@ -0,0 +1,171 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParsePayload(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    *Payload
+		wantErr bool
+	}{
+		{spec: "random", want: &Payload{Pattern: PatternRandom, Seed: 1}},
+		{spec: "zeros,size=1GB", want: &Payload{Pattern: PatternZeros, Seed: 1, Size: 1 << 30}},
+		{spec: "random,seed=42,size=200MB", want: &Payload{Pattern: PatternRandom, Seed: 42, Size: 200 << 20}},
+		{spec: "text", want: &Payload{Pattern: PatternText, Level: 5, Seed: 1}},
+		{spec: "text:9, seed=-3", want: &Payload{Pattern: PatternText, Level: 9, Seed: -3}},
+		{spec: "text:0", wantErr: true},
+		{spec: "text:10", wantErr: true},
+		{spec: "zeros:2", wantErr: true},
+		{spec: "noise", wantErr: true},
+		{spec: "random,seed=x", wantErr: true},
+		{spec: "random,size=-1", wantErr: true},
+		{spec: "random,size", wantErr: true},
+		{spec: "random,speed=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePayload(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePayload(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePayload(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestPayloadStringRoundTrip(t *testing.T) {
+	for _, p := range []*Payload{
+		{Pattern: PatternRandom, Seed: 7},
+		{Pattern: PatternZeros, Seed: 1, Size: 1000},
+		{Pattern: PatternText, Level: 3, Seed: 2, Size: 1 << 20},
+	} {
+		got, err := ParsePayload(p.String())
+		if err != nil || !reflect.DeepEqual(got, p) {
+			t.Errorf("ParsePayload(%q) = %+v, %v, want %+v", p.String(), got, err, p)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "0", want: 0},
+		{s: "1500", want: 1500},
+		{s: "1500B", want: 1500},
+		{s: "4K", want: 4 << 10},
+		{s: "4kb", want: 4 << 10},
+		{s: " 200MB ", want: 200 << 20},
+		{s: "2G", want: 2 << 30},
+		{s: "8589934591G", want: 8589934591 << 30},
+		{s: "8589934592G", wantErr: true},
+		{s: "9000000000G", wantErr: true},
+		{s: "9223372036854775807", want: 9223372036854775807},
+		{s: "", wantErr: true},
+		{s: "MB", wantErr: true},
+		{s: "-1K", wantErr: true},
+		{s: "1.5M", wantErr: true},
+		{s: "10T", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestPayloadReader(t *testing.T) {
+	for _, spec := range []string{"random,seed=3", "zeros", "text:1", "text:9,seed=5"} {
+		p, err := ParsePayload(spec)
+		if err != nil {
+			t.Fatalf("ParsePayload(%q) failed: %v", spec, err)
+		}
+
+		// The same bytes come out however the reader is read
+		whole := make([]byte, 100000)
+		io.ReadFull(p.Reader(), whole)
+		var pieces bytes.Buffer
+		r := p.Reader()
+		for _, n := range []int{1, 7, 4096, 3, 65536, 100000} {
+			buf := make([]byte, min(n, len(whole)-pieces.Len()))
+			io.ReadFull(r, buf)
+			pieces.Write(buf)
+		}
+		if !bytes.Equal(whole, pieces.Bytes()) {
+			t.Errorf("%s: reads of different sizes give different bytes", spec)
+		}
+
+		if p.Pattern == PatternZeros && bytes.Count(whole, []byte{0}) != len(whole) {
+			t.Errorf("%s: not all zeros", spec)
+		}
+		if p.Pattern == PatternText && bytes.Count(whole, []byte{'\n'}) == 0 {
+			t.Errorf("%s: no lines", spec)
+		}
+	}
+
+	a, _ := ParsePayload("random,seed=1")
+	b, _ := ParsePayload("random,seed=2")
+	bufA, bufB := make([]byte, 64), make([]byte, 64)
+	io.ReadFull(a.Reader(), bufA)
+	io.ReadFull(b.Reader(), bufB)
+	if bytes.Equal(bufA, bufB) {
+		t.Errorf("different seeds give the same bytes")
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	p := &Payload{Pattern: PatternText, Level: 4, Seed: 9}
+	data := make([]byte, 50000)
+	io.ReadFull(p.Reader(), data)
+
+	tests := []struct {
+		name         string
+		corrupt      int // Offset of a flipped byte, -1 for none
+		chunk        int
+		wantVerified int64
+	}{
+		{name: "intact", corrupt: -1, chunk: 4096, wantVerified: 50000},
+		{name: "intact, small writes", corrupt: -1, chunk: 3, wantVerified: 50000},
+		{name: "first byte", corrupt: 0, chunk: 4096, wantVerified: 0},
+		{name: "inside a write", corrupt: 10000, chunk: 4096, wantVerified: 10000},
+		{name: "last byte", corrupt: 49999, chunk: 1000, wantVerified: 49999},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := append([]byte(nil), data...)
+			if tt.corrupt >= 0 {
+				received[tt.corrupt] ^= 0xff
+			}
+			v := NewVerifier(p)
+			for off := 0; off < len(received); off += tt.chunk {
+				chunk := received[off:min(off+tt.chunk, len(received))]
+				if n, err := v.Write(chunk); n != len(chunk) || err != nil {
+					t.Fatalf("Write = %d, %v, want %d, nil", n, err, len(chunk))
+				}
+			}
+			if got := v.Verified(); got != tt.wantVerified {
+				t.Errorf("Verified() = %d, want %d", got, tt.wantVerified)
+			}
+			if err := v.Err(); (err != nil) != (tt.corrupt >= 0) {
+				t.Errorf("Err() = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// availableCongestionControlPath lists the algorithms the kernel has loaded
+const availableCongestionControlPath = "/proc/sys/net/ipv4/tcp_available_congestion_control"
+
+// slowStartAfterIdlePath tells whether the kernel resets cwnd after an idle period
+const slowStartAfterIdlePath = "/proc/sys/net/ipv4/tcp_slow_start_after_idle"
+
+// SlowStartAfterIdle reports whether net.ipv4.tcp_slow_start_after_idle is set in
+// this network namespace, which makes a connection idle for longer than its RTO
+// restart from the initial cwnd
+func SlowStartAfterIdle() (bool, error) {
+	b, err := os.ReadFile(slowStartAfterIdlePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read tcp_slow_start_after_idle: %v", err)
+	}
+	return strings.TrimSpace(string(b)) != "0", nil
+}
+
+// AvailableCongestionControls returns the congestion control algorithms available on this host
+func AvailableCongestionControls() ([]string, error) {
+	b, err := os.ReadFile(availableCongestionControlPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read available congestion control algorithms: %v", err)
+	}
+	return strings.Fields(string(b)), nil
+}
+
+// ValidateCongestionControl checks that the named algorithm is available on this host
+func ValidateCongestionControl(name string) error {
+	available, err := AvailableCongestionControls()
+	if err != nil {
+		return err
+	}
+	for _, algo := range available {
+		if algo == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("congestion control %q not available (available: %s)", name, strings.Join(available, " "))
+}
+
+// SetCongestionControl sets TCP_CONGESTION on a connection
+func SetCongestionControl(conn net.Conn, name string) error {
+	if err := ValidateCongestionControl(name); err != nil {
+		return err
+	}
+	return controlFD(conn, func(fd int) error {
+		return setCongestionControlFD(fd, name)
+	})
+}
+
+// GetCongestionControl reads back the congestion control algorithm in effect on a connection
+func GetCongestionControl(conn net.Conn) (string, error) {
+	var name string
+	err := controlFD(conn, func(fd int) error {
+		var err error
+		name, err = getCongestionControlFD(fd)
+		return err
+	})
+	return name, err
+}
+
+// controlFD runs fn against the raw file descriptor of a TCP connection
+func controlFD(conn net.Conn, fn func(fd int) error) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("not a TCP connection")
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+	err = rawConn.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// setCongestionControlFD sets TCP_CONGESTION using setsockopt (Linux specific)
+func setCongestionControlFD(fd int, name string) error {
+	if err := syscall.SetsockoptString(fd, syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, name); err != nil {
+		return fmt.Errorf("failed to set congestion control %q: %v", name, err)
+	}
+	return nil
+}
+
+// getCongestionControlFD reads TCP_CONGESTION using getsockopt (Linux specific)
+func getCongestionControlFD(fd int) (string, error) {
+	// TCP_CA_NAME_MAX is 16 in the kernel
+	var buf [16]byte
+	size := uint32(len(buf))
+
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_GETSOCKOPT,
+		uintptr(fd),
+		syscall.IPPROTO_TCP,
+		syscall.TCP_CONGESTION,
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(unsafe.Pointer(&size)),
+		0,
+	)
+	if errno != 0 {
+		return "", errno
+	}
+
+	return strings.TrimRight(string(buf[:size]), "\x00"), nil
+}
+
+// setUint64OptFD sets a socket option that the kernel reads as an unsigned long,
+// such as SO_MAX_PACING_RATE, with an 8-byte buffer so values above 2^32-1 are
+// not truncated. Kernels that only take 32 bits would keep the low half, so the
+// value is read back and rejected if it did not stick.
+func setUint64OptFD(fd, level, opt int, value uint64, name string) error {
+	buf := make([]byte, 8)
+	binary.NativeEndian.PutUint64(buf, value)
+	if err := syscall.SetsockoptString(fd, level, opt, string(buf)); err != nil {
+		return fmt.Errorf("failed to set %s=%d: %v", name, value, err)
+	}
+	effective, err := getUint64OptFD(fd, level, opt)
+	if err != nil {
+		return fmt.Errorf("failed to read back %s: %v", name, err)
+	}
+	if effective != value {
+		return fmt.Errorf("failed to set %s=%d: the kernel took %d", name, value, effective)
+	}
+	return nil
+}
+
+// getUint64OptFD reads a socket option the kernel returns as an unsigned long,
+// or as 32 bits on kernels without 64-bit support for it
+func getUint64OptFD(fd, level, opt int) (uint64, error) {
+	var buf [8]byte
+	size := uint32(len(buf))
+
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_GETSOCKOPT,
+		uintptr(fd),
+		uintptr(level),
+		uintptr(opt),
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(unsafe.Pointer(&size)),
+		0,
+	)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	if size == 4 {
+		// ~0U stands for unlimited like ~0UL does
+		if v := binary.NativeEndian.Uint32(buf[:4]); v != math.MaxUint32 {
+			return uint64(v), nil
+		}
+		return math.MaxUint64, nil
+	}
+	return binary.NativeEndian.Uint64(buf[:]), nil
+}
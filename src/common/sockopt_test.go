@@ -0,0 +1,61 @@
+package common
+
+import (
+	"net"
+	"testing"
+)
+
+// loopbackPair returns both ends of a TCP connection over the loopback interface
+func loopbackPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestCongestionControl(t *testing.T) {
+	available, err := AvailableCongestionControls()
+	if err != nil || len(available) == 0 {
+		t.Skipf("no congestion control list: %v", err)
+	}
+	client, _ := loopbackPair(t)
+
+	for _, name := range available {
+		if err := SetCongestionControl(client, name); err != nil {
+			// Unprivileged users may only select the allowed algorithms
+			t.Logf("SetCongestionControl(%q) failed: %v", name, err)
+			continue
+		}
+		if got, err := GetCongestionControl(client); err != nil || got != name {
+			t.Errorf("GetCongestionControl() = %q, %v, want %q", got, err, name)
+		}
+	}
+
+	if err := SetCongestionControl(client, "no-such-algorithm"); err == nil {
+		t.Errorf("SetCongestionControl() of an unknown algorithm succeeded")
+	}
+	if _, err := GetCongestionControl(&net.UDPConn{}); err == nil {
+		t.Errorf("GetCongestionControl() of a UDP connection succeeded")
+	}
+}
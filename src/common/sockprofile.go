@@ -0,0 +1,368 @@
+package common
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Socket option constants missing from the syscall package
+const (
+	soMaxPacingRate = 47 // SO_MAX_PACING_RATE
+	tcpNotSentLowat = 25 // TCP_NOTSENT_LOWAT
+)
+
+// SocketProfile is a named set of socket options applied to client and server sockets.
+// Zero values (and nil booleans) leave the kernel default in place.
+type SocketProfile struct {
+	Name              string `json:"name,omitempty"`
+	SndBuf            int    `json:"sndbuf,omitempty"`          // SO_SNDBUF in bytes
+	RcvBuf            int    `json:"rcvbuf,omitempty"`          // SO_RCVBUF in bytes
+	NotSentLowat      int    `json:"notsent_lowat,omitempty"`   // TCP_NOTSENT_LOWAT in bytes
+	WindowClamp       int    `json:"window_clamp,omitempty"`    // TCP_WINDOW_CLAMP in bytes
+	MaxSeg            int    `json:"maxseg,omitempty"`          // TCP_MAXSEG in bytes
+	NoDelay           *bool  `json:"nodelay,omitempty"`         // TCP_NODELAY
+	QuickAck          *bool  `json:"quickack,omitempty"`        // TCP_QUICKACK
+	MaxPacingRate     uint64 `json:"max_pacing_rate,omitempty"` // SO_MAX_PACING_RATE in bytes/sec
+	CongestionControl string `json:"cc,omitempty"`              // TCP_CONGESTION
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// builtinProfiles are available without a profile file
+var builtinProfiles = map[string]SocketProfile{
+	"default":       {},
+	"small-buffers": {SndBuf: 64 * 1024, RcvBuf: 64 * 1024},
+	"large-buffers": {SndBuf: 8 * 1024 * 1024, RcvBuf: 8 * 1024 * 1024},
+	"low-latency":   {NoDelay: boolPtr(true), QuickAck: boolPtr(true), NotSentLowat: 16 * 1024},
+}
+
+// LoadSocketProfiles reads named profiles from a JSON file mapping names to profiles
+func LoadSocketProfiles(path string) (map[string]SocketProfile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read socket profiles: %v", err)
+	}
+
+	profiles := make(map[string]SocketProfile)
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse socket profiles %s: %v", path, err)
+	}
+	return profiles, nil
+}
+
+// LookupSocketProfile finds a profile in the given set, falling back to the built-in profiles
+func LookupSocketProfile(name string, profiles map[string]SocketProfile) (*SocketProfile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		p, ok = builtinProfiles[name]
+	}
+	if !ok {
+		var names []string
+		for n := range builtinProfiles {
+			names = append(names, n)
+		}
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown socket profile %q (known: %s)", name, strings.Join(names, ", "))
+	}
+	p.Name = name
+	return &p, nil
+}
+
+// Merge overrides the profile with every option set in o
+func (p *SocketProfile) Merge(o SocketProfile) {
+	if o.SndBuf != 0 {
+		p.SndBuf = o.SndBuf
+	}
+	if o.RcvBuf != 0 {
+		p.RcvBuf = o.RcvBuf
+	}
+	if o.NotSentLowat != 0 {
+		p.NotSentLowat = o.NotSentLowat
+	}
+	if o.WindowClamp != 0 {
+		p.WindowClamp = o.WindowClamp
+	}
+	if o.MaxSeg != 0 {
+		p.MaxSeg = o.MaxSeg
+	}
+	if o.NoDelay != nil {
+		p.NoDelay = o.NoDelay
+	}
+	if o.QuickAck != nil {
+		p.QuickAck = o.QuickAck
+	}
+	if o.MaxPacingRate != 0 {
+		p.MaxPacingRate = o.MaxPacingRate
+	}
+	if o.CongestionControl != "" {
+		p.CongestionControl = o.CongestionControl
+	}
+}
+
+// Validate checks the profile against what this host supports
+func (p *SocketProfile) Validate() error {
+	if p.CongestionControl != "" {
+		return ValidateCongestionControl(p.CongestionControl)
+	}
+	return nil
+}
+
+// Control applies the profile to a socket before connect or listen.
+// It is meant for net.Dialer.Control and net.ListenConfig.Control; accepted
+// sockets inherit these options from the listener.
+func (p *SocketProfile) Control(network, address string, c syscall.RawConn) error {
+	var applyErr error
+	err := c.Control(func(fd uintptr) {
+		applyErr = p.applyFD(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return applyErr
+}
+
+// ApplyConn applies the options that must be set on an established connection.
+// Go enables TCP_NODELAY on every new connection and TCP_QUICKACK is not sticky,
+// so neither can be set from the Control hook alone.
+func (p *SocketProfile) ApplyConn(conn net.Conn) error {
+	if p.NoDelay != nil {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			if err := tcpConn.SetNoDelay(*p.NoDelay); err != nil {
+				return fmt.Errorf("failed to set TCP_NODELAY: %v", err)
+			}
+		}
+	}
+	if p.QuickAck != nil {
+		return controlFD(conn, func(fd int) error {
+			return setIntOpt(fd, syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, boolToInt(*p.QuickAck), "TCP_QUICKACK")
+		})
+	}
+	return nil
+}
+
+// String describes the options set in the profile
+func (p *SocketProfile) String() string {
+	var parts []string
+	add := func(name string, v int) {
+		if v != 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", name, v))
+		}
+	}
+	add("sndbuf", p.SndBuf)
+	add("rcvbuf", p.RcvBuf)
+	add("notsent_lowat", p.NotSentLowat)
+	add("window_clamp", p.WindowClamp)
+	add("maxseg", p.MaxSeg)
+	if p.NoDelay != nil {
+		parts = append(parts, fmt.Sprintf("nodelay=%t", *p.NoDelay))
+	}
+	if p.QuickAck != nil {
+		parts = append(parts, fmt.Sprintf("quickack=%t", *p.QuickAck))
+	}
+	if p.MaxPacingRate != 0 {
+		parts = append(parts, fmt.Sprintf("max_pacing_rate=%d", p.MaxPacingRate))
+	}
+	if p.CongestionControl != "" {
+		parts = append(parts, "cc="+p.CongestionControl)
+	}
+
+	name := p.Name
+	if name == "" {
+		name = "custom"
+	}
+	if len(parts) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, strings.Join(parts, " "))
+}
+
+// applyFD sets every option of the profile that can be set before connect or listen
+func (p *SocketProfile) applyFD(fd int) error {
+	if p.SndBuf != 0 {
+		if err := setIntOpt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, p.SndBuf, "SO_SNDBUF"); err != nil {
+			return err
+		}
+	}
+	if p.RcvBuf != 0 {
+		if err := setIntOpt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, p.RcvBuf, "SO_RCVBUF"); err != nil {
+			return err
+		}
+	}
+	if p.NotSentLowat != 0 {
+		if err := setIntOpt(fd, syscall.IPPROTO_TCP, tcpNotSentLowat, p.NotSentLowat, "TCP_NOTSENT_LOWAT"); err != nil {
+			return err
+		}
+	}
+	if p.WindowClamp != 0 {
+		if err := setIntOpt(fd, syscall.IPPROTO_TCP, syscall.TCP_WINDOW_CLAMP, p.WindowClamp, "TCP_WINDOW_CLAMP"); err != nil {
+			return err
+		}
+	}
+	if p.MaxSeg != 0 {
+		if err := setIntOpt(fd, syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, p.MaxSeg, "TCP_MAXSEG"); err != nil {
+			return err
+		}
+	}
+	if p.NoDelay != nil {
+		if err := setIntOpt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, boolToInt(*p.NoDelay), "TCP_NODELAY"); err != nil {
+			return err
+		}
+	}
+	if p.MaxPacingRate != 0 {
+		if err := setUint64OptFD(fd, syscall.SOL_SOCKET, soMaxPacingRate, p.MaxPacingRate, "SO_MAX_PACING_RATE"); err != nil {
+			return err
+		}
+	}
+	if p.CongestionControl != "" {
+		if err := setCongestionControlFD(fd, p.CongestionControl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSocketProfile reads back the effective values of every profile option on a connection
+func ReadSocketProfile(conn net.Conn) (*SocketProfile, error) {
+	p := &SocketProfile{Name: "effective"}
+	err := controlFD(conn, func(fd int) error {
+		var err error
+		get := func(level, opt int) int {
+			if err != nil {
+				return 0
+			}
+			var v int
+			v, err = syscall.GetsockoptInt(fd, level, opt)
+			return v
+		}
+
+		p.SndBuf = get(syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		p.RcvBuf = get(syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		p.NotSentLowat = get(syscall.IPPROTO_TCP, tcpNotSentLowat)
+		p.WindowClamp = get(syscall.IPPROTO_TCP, syscall.TCP_WINDOW_CLAMP)
+		p.MaxSeg = get(syscall.IPPROTO_TCP, syscall.TCP_MAXSEG)
+		p.NoDelay = boolPtr(get(syscall.IPPROTO_TCP, syscall.TCP_NODELAY) != 0)
+		p.QuickAck = boolPtr(get(syscall.IPPROTO_TCP, syscall.TCP_QUICKACK) != 0)
+		if err != nil {
+			return err
+		}
+		// ~0 means unlimited
+		rate, err := getUint64OptFD(fd, syscall.SOL_SOCKET, soMaxPacingRate)
+		if err != nil {
+			return err
+		}
+		if rate != math.MaxUint64 {
+			p.MaxPacingRate = rate
+		}
+
+		p.CongestionControl, err = getCongestionControlFD(fd)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func setIntOpt(fd, level, opt, value int, name string) error {
+	if err := syscall.SetsockoptInt(fd, level, opt, value); err != nil {
+		return fmt.Errorf("failed to set %s=%d: %v", name, value, err)
+	}
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// SocketFlags holds the command line flags that select and override a socket profile
+type SocketFlags struct {
+	profile   string
+	file      string
+	overrides SocketProfile
+}
+
+// RegisterSocketFlags defines the socket tuning flags shared by client and server
+func RegisterSocketFlags(fs *flag.FlagSet) *SocketFlags {
+	f := &SocketFlags{}
+	fs.StringVar(&f.profile, "sock-profile", "default", "Named socket tuning profile (built-in: default, small-buffers, large-buffers, low-latency)")
+	fs.StringVar(&f.file, "sock-profile-file", "", "JSON file with additional named socket profiles")
+	fs.IntVar(&f.overrides.SndBuf, "sndbuf", 0, "SO_SNDBUF in bytes (overrides profile)")
+	fs.IntVar(&f.overrides.RcvBuf, "rcvbuf", 0, "SO_RCVBUF in bytes (overrides profile)")
+	fs.IntVar(&f.overrides.NotSentLowat, "notsent-lowat", 0, "TCP_NOTSENT_LOWAT in bytes (overrides profile)")
+	fs.IntVar(&f.overrides.WindowClamp, "window-clamp", 0, "TCP_WINDOW_CLAMP in bytes (overrides profile)")
+	fs.IntVar(&f.overrides.MaxSeg, "mss", 0, "TCP_MAXSEG in bytes (overrides profile)")
+	fs.Var(&optBool{&f.overrides.NoDelay}, "nodelay", "TCP_NODELAY (overrides profile)")
+	fs.Var(&optBool{&f.overrides.QuickAck}, "quickack", "TCP_QUICKACK (overrides profile)")
+	fs.Uint64Var(&f.overrides.MaxPacingRate, "max-pacing-rate", 0, "SO_MAX_PACING_RATE in bytes/sec (overrides profile)")
+	fs.StringVar(&f.overrides.CongestionControl, "cc", "", "TCP congestion control algorithm (default: system setting)")
+	return f
+}
+
+// Resolve builds the socket profile selected by the flags
+func (f *SocketFlags) Resolve() (*SocketProfile, error) {
+	return f.ResolveProfile("", SocketProfile{})
+}
+
+// ResolveProfile builds a named profile with the flag overrides and then the given
+// overrides applied on top. An empty name selects the profile chosen by the flags.
+func (f *SocketFlags) ResolveProfile(name string, overrides SocketProfile) (*SocketProfile, error) {
+	var profiles map[string]SocketProfile
+	if f.file != "" {
+		var err error
+		if profiles, err = LoadSocketProfiles(f.file); err != nil {
+			return nil, err
+		}
+	}
+
+	if name == "" {
+		name = f.profile
+	}
+	p, err := LookupSocketProfile(name, profiles)
+	if err != nil {
+		return nil, err
+	}
+	p.Merge(f.overrides)
+	p.Merge(overrides)
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// optBool is a boolean flag that remembers whether it was set
+type optBool struct {
+	v **bool
+}
+
+func (b *optBool) String() string {
+	if b.v == nil || *b.v == nil {
+		return ""
+	}
+	return strconv.FormatBool(**b.v)
+}
+
+func (b *optBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b.v = &v
+	return nil
+}
+
+func (b *optBool) IsBoolFlag() bool { return true }
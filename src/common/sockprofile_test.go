@@ -0,0 +1,147 @@
+package common
+
+import (
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLookupSocketProfile(t *testing.T) {
+	profiles := map[string]SocketProfile{
+		"bulk":          {SndBuf: 4 << 20, CongestionControl: "cubic"},
+		"small-buffers": {SndBuf: 1000}, // Shadows the built-in profile
+	}
+	tests := []struct {
+		name    string
+		want    *SocketProfile
+		wantErr bool
+	}{
+		{name: "default", want: &SocketProfile{Name: "default"}},
+		{name: "large-buffers", want: &SocketProfile{Name: "large-buffers", SndBuf: 8 << 20, RcvBuf: 8 << 20}},
+		{name: "bulk", want: &SocketProfile{Name: "bulk", SndBuf: 4 << 20, CongestionControl: "cubic"}},
+		{name: "small-buffers", want: &SocketProfile{Name: "small-buffers", SndBuf: 1000}},
+		{name: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := LookupSocketProfile(tt.name, profiles)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("LookupSocketProfile(%q) = %+v, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LookupSocketProfile(%q) = %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	// Lookups return copies, so callers can merge overrides into them
+	p, _ := LookupSocketProfile("small-buffers", nil)
+	p.SndBuf = 1
+	if builtinProfiles["small-buffers"].SndBuf == 1 {
+		t.Errorf("changing a looked up profile changed the built-in one")
+	}
+}
+
+func TestSocketFlagsResolve(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(file, []byte(`{"wan": {"sndbuf": 1048576, "nodelay": true, "notsent_lowat": 4096}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args    []string
+		want    *SocketProfile
+		wantErr bool
+	}{
+		{args: nil, want: &SocketProfile{Name: "default"}},
+		{
+			args: []string{"-sock-profile", "low-latency", "-quickack=false", "-rcvbuf", "8192"},
+			want: &SocketProfile{Name: "low-latency", RcvBuf: 8192, NoDelay: boolPtr(true), QuickAck: boolPtr(false), NotSentLowat: 16 * 1024},
+		},
+		{
+			args: []string{"-sock-profile-file", file, "-sock-profile", "wan", "-nodelay=false", "-max-pacing-rate", "1000000"},
+			want: &SocketProfile{Name: "wan", SndBuf: 1 << 20, NotSentLowat: 4096, NoDelay: boolPtr(false), MaxPacingRate: 1000000},
+		},
+		{args: []string{"-sock-profile", "wan"}, wantErr: true},
+		{args: []string{"-sock-profile-file", filepath.Join(t.TempDir(), "missing.json")}, wantErr: true},
+		{args: []string{"-cc", "no-such-algorithm"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags := RegisterSocketFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.args, err)
+		}
+		got, err := flags.Resolve()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Resolve() with %q = %+v, want error", tt.args, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Resolve() with %q = %v, %v, want %v", tt.args, got, err, tt.want)
+		}
+	}
+}
+
+func TestSocketProfileString(t *testing.T) {
+	tests := []struct {
+		p    SocketProfile
+		want string
+	}{
+		{SocketProfile{Name: "default"}, "default"},
+		{SocketProfile{}, "custom"},
+		{
+			SocketProfile{Name: "x", SndBuf: 10, MaxSeg: 1400, NoDelay: boolPtr(false), MaxPacingRate: 5, CongestionControl: "bbr"},
+			"x (sndbuf=10 maxseg=1400 nodelay=false max_pacing_rate=5 cc=bbr)",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.p.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestSocketProfileApply(t *testing.T) {
+	// The pacing rate does not fit in 32 bits, so a truncated setsockopt shows
+	p := &SocketProfile{SndBuf: 256 * 1024, NotSentLowat: 32 * 1024, NoDelay: boolPtr(false), MaxPacingRate: 5 << 30}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+
+	dialer := net.Dialer{Control: p.Control}
+	conn, err := dialer.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := p.ApplyConn(conn); err != nil {
+		t.Fatalf("ApplyConn() failed: %v", err)
+	}
+
+	got, err := ReadSocketProfile(conn)
+	if err != nil {
+		t.Fatalf("ReadSocketProfile() failed: %v", err)
+	}
+	// The kernel doubles buffer sizes for its own bookkeeping
+	if got.SndBuf < p.SndBuf {
+		t.Errorf("SO_SNDBUF = %d, want at least %d", got.SndBuf, p.SndBuf)
+	}
+	if got.NotSentLowat != p.NotSentLowat || *got.NoDelay || got.MaxPacingRate != p.MaxPacingRate {
+		t.Errorf("ReadSocketProfile() = %v, want %v", got, p)
+	}
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SteadyWindow describes a timed transfer: it runs for Duration, and its
+// steady-state statistics leave out the warm-up at the start and the cool-down
+// at the end
+type SteadyWindow struct {
+	Duration time.Duration
+	Warmup   time.Duration
+	Cooldown time.Duration
+}
+
+// ParseSteadyWindow parses a spec such as "duration=30s,warmup=5s,cooldown=2s".
+// Every key is optional.
+func ParseSteadyWindow(spec string) (SteadyWindow, error) {
+	var w SteadyWindow
+	if strings.TrimSpace(spec) == "" {
+		return w, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return w, fmt.Errorf("invalid window entry %q", part)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return w, fmt.Errorf("invalid %s %q", key, value)
+		}
+		switch key {
+		case "duration":
+			w.Duration = d
+		case "warmup":
+			w.Warmup = d
+		case "cooldown":
+			w.Cooldown = d
+		default:
+			return w, fmt.Errorf("unknown window key %q", key)
+		}
+	}
+
+	if w.Duration > 0 && w.Warmup+w.Cooldown >= w.Duration {
+		return w, fmt.Errorf("warm-up and cool-down leave nothing of the %v duration", w.Duration)
+	}
+	return w, nil
+}
+
+// String returns the spec of the window
+func (w SteadyWindow) String() string {
+	return fmt.Sprintf("duration=%v,warmup=%v,cooldown=%v", w.Duration, w.Warmup, w.Cooldown)
+}
+
+// Bounds returns the steady-state part of a transfer that ran from start to end
+func (w SteadyWindow) Bounds(start, end time.Time) (from, to time.Time) {
+	return start.Add(w.Warmup), end.Add(-w.Cooldown)
+}
+
+// SteadyStateRate returns the rate of a TCP_INFO byte counter, such as bytes_acked
+// or bytes_received, between the first sample at or after from and the last one
+// at or before to. It is 0 if fewer than two samples fall in between.
+func SteadyStateRate(samples []TCPInfo, from, to time.Time, counter func(TCPInfo) uint64) float64 {
+	var first, last *TCPInfo
+	for i := range samples {
+		s := &samples[i]
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+		if first == nil {
+			first = s
+		}
+		last = s
+	}
+	if first == nil || first == last {
+		return 0
+	}
+
+	elapsed := last.Timestamp.Sub(first.Timestamp).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(counter(*last)-counter(*first)) / elapsed
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSteadyWindow(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    SteadyWindow
+		wantErr bool
+	}{
+		{spec: "", want: SteadyWindow{}},
+		{spec: "duration=30s", want: SteadyWindow{Duration: 30 * time.Second}},
+		{spec: "duration=30s, warmup=5s,cooldown=2s", want: SteadyWindow{Duration: 30 * time.Second, Warmup: 5 * time.Second, Cooldown: 2 * time.Second}},
+		{spec: "warmup=1s", want: SteadyWindow{Warmup: time.Second}},
+		{spec: "duration=10s,warmup=5s,cooldown=5s", wantErr: true},
+		{spec: "duration=10s,warmup=11s", wantErr: true},
+		{spec: "duration=-1s", wantErr: true},
+		{spec: "duration=30", wantErr: true},
+		{spec: "duration", wantErr: true},
+		{spec: "length=30s", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSteadyWindow(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSteadyWindow(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSteadyWindow(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestSteadyWindowStringRoundTrip(t *testing.T) {
+	w := SteadyWindow{Duration: 30 * time.Second, Warmup: 5 * time.Second, Cooldown: 1500 * time.Millisecond}
+	got, err := ParseSteadyWindow(w.String())
+	if err != nil || got != w {
+		t.Errorf("ParseSteadyWindow(%q) = %+v, %v, want %+v", w.String(), got, err, w)
+	}
+}
+
+func TestSteadyStateRate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds float64) time.Time { return start.Add(time.Duration(seconds * float64(time.Second))) }
+	// 1000 bytes/sec for the first two seconds, then 3000 bytes/sec
+	samples := []TCPInfo{
+		{Timestamp: at(0), BytesAcked: 0},
+		{Timestamp: at(1), BytesAcked: 1000},
+		{Timestamp: at(2), BytesAcked: 2000},
+		{Timestamp: at(3), BytesAcked: 5000},
+		{Timestamp: at(4), BytesAcked: 8000},
+	}
+	acked := func(s TCPInfo) uint64 { return s.BytesAcked }
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     float64
+	}{
+		{"whole run", at(0), at(4), 2000},
+		{"first part", at(0), at(2), 1000},
+		{"second part", at(2), at(4), 3000},
+		{"bounds between samples", at(1.5), at(3.5), 3000},
+		{"one sample", at(0.5), at(1.5), 0},
+		{"no sample", at(5), at(6), 0},
+	}
+	for _, tt := range tests {
+		if got := SteadyStateRate(samples, tt.from, tt.to, acked); got != tt.want {
+			t.Errorf("%s: SteadyStateRate = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Bounds trims the warm-up and cool-down off the transfer
+	w := SteadyWindow{Duration: 4 * time.Second, Warmup: time.Second, Cooldown: time.Second}
+	from, to := w.Bounds(at(0), at(4))
+	if !from.Equal(at(1)) || !to.Equal(at(3)) {
+		t.Errorf("Bounds = %v, %v, want %v, %v", from, to, at(1), at(3))
+	}
+	if got := SteadyStateRate(samples, from, to, acked); got != 2000 {
+		t.Errorf("steady-state rate = %v, want 2000", got)
+	}
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"net"
+	"sync"
 	"syscall"
 	"time"
 	"unsafe"
@@ -10,20 +11,27 @@ import (
 // TCPInfo represents TCP connection metrics
 type TCPInfo struct {
 	Timestamp     time.Time `json:"timestamp"`
-	RTT           uint32    `json:"rtt_us"`         // Round trip time in microseconds
-	RTTVar        uint32    `json:"rtt_var_us"`     // RTT variance in microseconds
-	SndCwnd       uint32    `json:"snd_cwnd"`       // Congestion window size
-	SndSsthresh   uint32    `json:"snd_ssthresh"`   // Slow start threshold
-	Retransmits   uint8     `json:"retransmits"`    // Number of retransmits
-	TotalRetrans  uint32    `json:"total_retrans"`  // Total retransmissions
-	BytesAcked    uint64    `json:"bytes_acked"`    // Bytes acknowledged
-	BytesReceived uint64    `json:"bytes_received"` // Bytes received
-	SegsOut       uint32    `json:"segs_out"`       // Segments sent
-	SegsIn        uint32    `json:"segs_in"`        // Segments received
+	RTT           uint32    `json:"rtt_us"`            // Round trip time in microseconds
+	RTTVar        uint32    `json:"rtt_var_us"`        // RTT variance in microseconds
+	RTO           uint32    `json:"rto_us"`            // Retransmission timeout in microseconds
+	SndCwnd       uint32    `json:"snd_cwnd"`          // Congestion window size
+	SndSsthresh   uint32    `json:"snd_ssthresh"`      // Slow start threshold
+	Retransmits   uint8     `json:"retransmits"`       // Number of retransmits
+	TotalRetrans  uint32    `json:"total_retrans"`     // Total retransmissions
+	BytesAcked    uint64    `json:"bytes_acked"`       // Bytes acknowledged
+	BytesReceived uint64    `json:"bytes_received"`    // Bytes received
+	SegsOut       uint32    `json:"segs_out"`          // Segments sent
+	SegsIn        uint32    `json:"segs_in"`           // Segments received
+	DeliveryRate  uint64    `json:"delivery_rate"`     // Most recent delivery rate in bytes/sec
+	AppLimited    bool      `json:"app_limited"`       // Delivery rate was measured while the application limited sending
+	BusyTime      uint64    `json:"busy_time_us"`      // Time with unacknowledged data in flight
+	RwndLimited   uint64    `json:"rwnd_limited_us"`   // Time limited by the receive window
+	SndbufLimited uint64    `json:"sndbuf_limited_us"` // Time limited by the send buffer
 }
 
 // TCPInfoCollector collects TCP_INFO metrics from connections
 type TCPInfoCollector struct {
+	mu      sync.Mutex
 	samples []TCPInfo
 }
 
@@ -71,18 +79,69 @@ func (c *TCPInfoCollector) CollectSample(conn net.Conn) error {
 		return err
 	}
 
+	c.mu.Lock()
 	c.samples = append(c.samples, *info)
+	c.mu.Unlock()
 	return nil
 }
 
+// StartSampling collects a sample every interval until the returned stop function is called.
+// onSample, if not nil, is called with every new sample. Calling stop more than once is safe.
+func (c *TCPInfoCollector) StartSampling(conn net.Conn, interval time.Duration, onSample func(TCPInfo)) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.CollectSample(conn); err == nil && onSample != nil {
+					if latest := c.Latest(); latest != nil {
+						onSample(*latest)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
 // GetSamples returns all collected samples
 func (c *TCPInfoCollector) GetSamples() []TCPInfo {
-	return c.samples
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]TCPInfo(nil), c.samples...)
+}
+
+// Latest returns the most recent sample, or nil if none was collected yet
+func (c *TCPInfoCollector) Latest() *TCPInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) == 0 {
+		return nil
+	}
+	latest := c.samples[len(c.samples)-1]
+	return &latest
 }
 
 // ClearSamples clears all collected samples
 func (c *TCPInfoCollector) ClearSamples() {
+	c.mu.Lock()
 	c.samples = c.samples[:0]
+	c.mu.Unlock()
 }
 
 // getTCPInfoFromFD gets TCP_INFO using getsockopt syscall (Linux specific)
@@ -101,7 +160,8 @@ func getTCPInfoFromFD(fd int) (*TCPInfo, error) {
 		Probes        uint8
 		Backoff       uint8
 		Options       uint8
-		Pad           [2]uint8
+		Wscale        uint8 // snd_wscale:4, rcv_wscale:4
+		Flags         uint8 // delivery_rate_app_limited:1, fastopen_client_fail:2
 		Rto           uint32
 		Ato           uint32
 		SndMss        uint32
@@ -165,6 +225,7 @@ func getTCPInfoFromFD(fd int) (*TCPInfo, error) {
 		Timestamp:     time.Now(),
 		RTT:           info.Rtt,
 		RTTVar:        info.Rttvar,
+		RTO:           info.Rto,
 		SndCwnd:       info.SndCwnd,
 		SndSsthresh:   info.SndSsthresh,
 		Retransmits:   info.Retransmits,
@@ -173,6 +234,11 @@ func getTCPInfoFromFD(fd int) (*TCPInfo, error) {
 		BytesReceived: info.BytesReceived,
 		SegsOut:       info.SegsOut,
 		SegsIn:        info.SegsIn,
+		DeliveryRate:  info.DeliveryRate,
+		AppLimited:    info.Flags&1 != 0,
+		BusyTime:      info.BusyTime,
+		RwndLimited:   info.RwndLimited,
+		SndbufLimited: info.SndbufLimited,
 	}
 
 	return tcpInfo, nil
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTCPInfoCollectorSampling(t *testing.T) {
+	client, _ := loopbackPair(t)
+	c := NewTCPInfoCollector()
+	if c.Latest() != nil {
+		t.Fatalf("Latest() of an empty collector is not nil")
+	}
+
+	var seen atomic.Int64
+	stop := c.StartSampling(client, 10*time.Millisecond, func(TCPInfo) { seen.Add(1) })
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	stop()
+
+	samples := c.GetSamples()
+	if len(samples) < 3 {
+		t.Fatalf("%d samples in 100ms at a 10ms interval", len(samples))
+	}
+	if int(seen.Load()) != len(samples) {
+		t.Errorf("onSample called %d times for %d samples", seen.Load(), len(samples))
+	}
+	if latest := c.Latest(); latest == nil || *latest != samples[len(samples)-1] {
+		t.Errorf("Latest() = %+v, want the last sample", latest)
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Timestamp.Before(samples[i-1].Timestamp) {
+			t.Errorf("sample %d is older than the one before", i)
+		}
+	}
+
+	// Sampling has stopped, and the returned samples are a copy
+	time.Sleep(30 * time.Millisecond)
+	if n := len(c.GetSamples()); n != len(samples) {
+		t.Errorf("%d samples after stop, want %d", n, len(samples))
+	}
+	samples[0].RTT = 123456789
+	if c.GetSamples()[0].RTT == 123456789 {
+		t.Errorf("GetSamples() shares its slice with the collector")
+	}
+}
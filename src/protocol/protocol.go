@@ -5,16 +5,34 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 )
 
 // Operation codes
 const (
-	OpList  byte = 1
-	OpPut   byte = 2
-	OpQuit  byte = 3
-	OpError byte = 255
+	OpList   byte = 1
+	OpPut    byte = 2
+	OpQuit   byte = 3
+	OpOption byte = 4
+	OpGet    byte = 5
+	OpPing   byte = 6
+	OpSend   byte = 7
+	OpData   byte = 8
+	OpError  byte = 255
 )
 
+// Connection option keys carried by OPTION frames
+const (
+	OptCongestionControl = "cc"            // TCP_CONGESTION algorithm for the server side of the connection
+	OptReadThrottle      = "read_throttle" // Slow-reader spec for uploads on the connection
+	OptNamespace         = "namespace"     // Storage namespace for LIST and PUT on the connection
+	OptPayload           = "payload"       // Synthetic payload spec; uploads are verified against it and dropped instead of stored
+	OptPacing            = "pacing"        // Token-bucket spec limiting the server's download sending rate
+)
+
+// MaxFilenameLen is the longest filename a PUT frame may carry
+const MaxFilenameLen = 4096
+
 // Frame represents a protocol message
 type Frame struct {
 	OpCode     byte
@@ -26,18 +44,18 @@ type Frame struct {
 func WriteFrame(conn net.Conn, frame *Frame) error {
 	// Write opcode
 	if err := binary.Write(conn, binary.BigEndian, frame.OpCode); err != nil {
-		return fmt.Errorf("failed to write opcode: %v", err)
+		return fmt.Errorf("failed to write opcode: %w", err)
 	}
 
 	// Write payload length
 	if err := binary.Write(conn, binary.BigEndian, frame.PayloadLen); err != nil {
-		return fmt.Errorf("failed to write payload length: %v", err)
+		return fmt.Errorf("failed to write payload length: %w", err)
 	}
 
 	// Write payload if exists
 	if frame.PayloadLen > 0 {
 		if _, err := conn.Write(frame.Payload); err != nil {
-			return fmt.Errorf("failed to write payload: %v", err)
+			return fmt.Errorf("failed to write payload: %w", err)
 		}
 	}
 
@@ -45,28 +63,116 @@ func WriteFrame(conn net.Conn, frame *Frame) error {
 }
 
 // ReadFrame reads a frame from the connection
-func ReadFrame(conn net.Conn) (*Frame, error) {
+func ReadFrame(conn io.Reader) (*Frame, error) {
+	frame, err := ReadFrameHeader(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ReadFramePayload(conn, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// ReadFrameHeader reads the opcode and payload length, leaving the payload on the connection
+func ReadFrameHeader(conn io.Reader) (*Frame, error) {
 	frame := &Frame{}
 
 	// Read opcode
 	if err := binary.Read(conn, binary.BigEndian, &frame.OpCode); err != nil {
-		return nil, fmt.Errorf("failed to read opcode: %v", err)
+		return nil, fmt.Errorf("failed to read opcode: %w", err)
 	}
 
 	// Read payload length
 	if err := binary.Read(conn, binary.BigEndian, &frame.PayloadLen); err != nil {
-		return nil, fmt.Errorf("failed to read payload length: %v", err)
+		return nil, fmt.Errorf("failed to read payload length: %w", err)
 	}
 
+	return frame, nil
+}
+
+// ReadFramePayload reads the payload announced by a frame header
+func ReadFramePayload(conn io.Reader, frame *Frame) error {
 	// Read payload if exists
 	if frame.PayloadLen > 0 {
 		frame.Payload = make([]byte, frame.PayloadLen)
 		if _, err := io.ReadFull(conn, frame.Payload); err != nil {
-			return nil, fmt.Errorf("failed to read payload: %v", err)
+			return fmt.Errorf("failed to read payload: %w", err)
 		}
 	}
 
-	return frame, nil
+	return nil
+}
+
+// ReadPutHeader reads the filename prefix of a PUT payload still on the connection.
+// It returns the filename and the number of file data bytes that follow.
+func ReadPutHeader(conn io.Reader, frame *Frame) (string, int64, error) {
+	if frame.OpCode != OpPut {
+		return "", 0, fmt.Errorf("not a PUT frame")
+	}
+
+	if frame.PayloadLen < 4 {
+		return "", 0, fmt.Errorf("invalid PUT frame payload")
+	}
+
+	var filenameLen uint32
+	if err := binary.Read(conn, binary.BigEndian, &filenameLen); err != nil {
+		return "", 0, fmt.Errorf("failed to read filename length: %w", err)
+	}
+	// Compare in 64 bits so a huge filename length cannot wrap around
+	if uint64(filenameLen) > uint64(frame.PayloadLen)-4 {
+		return "", 0, fmt.Errorf("invalid PUT frame: filename length mismatch")
+	}
+	if filenameLen > MaxFilenameLen {
+		return "", 0, fmt.Errorf("invalid PUT frame: filename longer than %d bytes", MaxFilenameLen)
+	}
+
+	filenameBytes := make([]byte, filenameLen)
+	if _, err := io.ReadFull(conn, filenameBytes); err != nil {
+		return "", 0, fmt.Errorf("failed to read filename: %w", err)
+	}
+
+	return string(filenameBytes), int64(frame.PayloadLen - 4 - filenameLen), nil
+}
+
+// WriteFrameHeader sends the header of a frame whose payload the caller streams afterwards
+func WriteFrameHeader(conn io.Writer, opCode byte, payloadLen int64) error {
+	if payloadLen < 0 || payloadLen > 0xFFFFFFFF {
+		return fmt.Errorf("payload length %d out of range", payloadLen)
+	}
+
+	header := make([]byte, 5)
+	header[0] = opCode
+	binary.BigEndian.PutUint32(header[1:5], uint32(payloadLen))
+	if _, err := conn.Write(header); err != nil {
+		return fmt.Errorf("failed to write frame header: %w", err)
+	}
+	return nil
+}
+
+// WritePutHeader sends the frame header and filename of a PUT whose dataLen bytes
+// of file data the caller streams afterwards
+func WritePutHeader(conn io.Writer, filename string, dataLen int64) error {
+	if len(filename) > MaxFilenameLen {
+		return fmt.Errorf("filename longer than %d bytes", MaxFilenameLen)
+	}
+	payloadLen := 4 + int64(len(filename)) + dataLen
+	if payloadLen > 0xFFFFFFFF {
+		return fmt.Errorf("PUT payload too large: %d bytes", payloadLen)
+	}
+
+	header := make([]byte, 9+len(filename))
+	header[0] = OpPut
+	binary.BigEndian.PutUint32(header[1:5], uint32(payloadLen))
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(filename)))
+	copy(header[9:], filename)
+
+	if _, err := conn.Write(header); err != nil {
+		return fmt.Errorf("failed to write PUT header: %w", err)
+	}
+	return nil
 }
 
 // CreateListFrame creates a LIST operation frame
@@ -95,6 +201,16 @@ func CreatePutFrame(filename string, fileData []byte) *Frame {
 	}
 }
 
+// CreateGetFrame creates a GET operation frame; the server answers with a GET
+// frame carrying the file data
+func CreateGetFrame(filename string) *Frame {
+	return &Frame{
+		OpCode:     OpGet,
+		PayloadLen: uint32(len(filename)),
+		Payload:    []byte(filename),
+	}
+}
+
 // CreateQuitFrame creates a QUIT operation frame
 func CreateQuitFrame() *Frame {
 	return &Frame{
@@ -104,6 +220,37 @@ func CreateQuitFrame() *Frame {
 	}
 }
 
+// CreatePingFrame creates a PING frame; the server echoes it back unchanged
+func CreatePingFrame(payload []byte) *Frame {
+	return &Frame{
+		OpCode:     OpPing,
+		PayloadLen: uint32(len(payload)),
+		Payload:    payload,
+	}
+}
+
+// CreateSendFrame starts a timed upload. The spec describes its steady-state
+// window (see common.ParseSteadyWindow); DATA frames follow, and an empty DATA
+// frame ends the upload, answered with a SEND frame.
+func CreateSendFrame(spec string) *Frame {
+	return &Frame{
+		OpCode:     OpSend,
+		PayloadLen: uint32(len(spec)),
+		Payload:    []byte(spec),
+	}
+}
+
+// CreateOptionFrame creates an OPTION frame setting key to value.
+// The server answers with an OPTION frame carrying the effective value.
+func CreateOptionFrame(key, value string) *Frame {
+	payload := []byte(key + "=" + value)
+	return &Frame{
+		OpCode:     OpOption,
+		PayloadLen: uint32(len(payload)),
+		Payload:    payload,
+	}
+}
+
 // CreateErrorFrame creates an ERROR frame
 func CreateErrorFrame(message string) *Frame {
 	payload := []byte(message)
@@ -125,12 +272,29 @@ func ParsePutFrame(frame *Frame) (string, []byte, error) {
 	}
 
 	filenameLen := binary.BigEndian.Uint32(frame.Payload[0:4])
-	if len(frame.Payload) < int(4+filenameLen) {
+	if uint64(filenameLen) > uint64(len(frame.Payload))-4 {
 		return "", nil, fmt.Errorf("invalid PUT frame: filename length mismatch")
 	}
+	if filenameLen > MaxFilenameLen {
+		return "", nil, fmt.Errorf("invalid PUT frame: filename longer than %d bytes", MaxFilenameLen)
+	}
 
 	filename := string(frame.Payload[4 : 4+filenameLen])
 	fileData := frame.Payload[4+filenameLen:]
 
 	return filename, fileData, nil
 }
+
+// ParseOptionFrame extracts the key and value from an OPTION frame
+func ParseOptionFrame(frame *Frame) (string, string, error) {
+	if frame.OpCode != OpOption {
+		return "", "", fmt.Errorf("not an OPTION frame")
+	}
+
+	key, value, ok := strings.Cut(string(frame.Payload), "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid OPTION frame payload")
+	}
+
+	return key, value, nil
+}
@@ -0,0 +1,183 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pipe returns a connection whose reads return data, as sent by the peer
+func pipe(t *testing.T, data []byte) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []*Frame{
+		CreateListFrame(),
+		CreateQuitFrame(),
+		CreatePutFrame("a.bin", []byte("hello")),
+		CreatePutFrame("empty", nil),
+		CreateErrorFrame("File not found"),
+		CreateOptionFrame(OptCongestionControl, "bbr"),
+	}
+	for _, want := range frames {
+		client, server := net.Pipe()
+		go func(frame *Frame) {
+			WriteFrame(client, frame)
+			client.Close()
+		}(want)
+		got, err := ReadFrame(server)
+		server.Close()
+		if err != nil {
+			t.Fatalf("ReadFrame() of op %d failed: %v", want.OpCode, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadFrame() = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "short length", data: []byte{OpList, 0, 0}},
+		{name: "short payload", data: []byte{OpError, 0, 0, 0, 4, 'a', 'b'}},
+	}
+	for _, tt := range tests {
+		if frame, err := ReadFrame(pipe(t, tt.data)); err == nil {
+			t.Errorf("%s: ReadFrame() = %+v, want error", tt.name, frame)
+		}
+	}
+}
+
+func TestReadPutHeader(t *testing.T) {
+	frame := CreatePutFrame("dir/a.bin", []byte("file data"))
+	var wire bytes.Buffer
+	wire.WriteByte(frame.OpCode)
+	binary.Write(&wire, binary.BigEndian, frame.PayloadLen)
+	wire.Write(frame.Payload)
+	conn := pipe(t, wire.Bytes())
+
+	header, err := ReadFrameHeader(conn)
+	if err != nil {
+		t.Fatalf("ReadFrameHeader() failed: %v", err)
+	}
+	filename, size, err := ReadPutHeader(conn, header)
+	if err != nil || filename != "dir/a.bin" || size != 9 {
+		t.Fatalf("ReadPutHeader() = %q, %d, %v, want %q, 9", filename, size, err, "dir/a.bin")
+	}
+	// Only the file data is left on the connection
+	data, _ := io.ReadAll(conn)
+	if string(data) != "file data" {
+		t.Errorf("data after the PUT header = %q", data)
+	}
+}
+
+func TestReadPutHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *Frame
+		data  []byte // Payload bytes on the connection
+	}{
+		{name: "not a PUT", frame: &Frame{OpCode: OpList, PayloadLen: 8}, data: []byte{0, 0, 0, 1, 'a', 'b', 'c', 'd'}},
+		{name: "no filename length", frame: &Frame{OpCode: OpPut, PayloadLen: 3}, data: []byte{0, 0, 0}},
+		{name: "filename past the payload", frame: &Frame{OpCode: OpPut, PayloadLen: 6}, data: []byte{0, 0, 0, 3, 'a', 'b'}},
+		{name: "truncated filename", frame: &Frame{OpCode: OpPut, PayloadLen: 10}, data: []byte{0, 0, 0, 6, 'a', 'b'}},
+		// 4+0xFFFFFFFF wraps around to 3 in 32 bits
+		{name: "filename length overflow", frame: &Frame{OpCode: OpPut, PayloadLen: 10}, data: []byte{0xFF, 0xFF, 0xFF, 0xFF, 'a', 'b'}},
+		{name: "filename too long", frame: &Frame{OpCode: OpPut, PayloadLen: 1 << 20}, data: []byte{0, 0, 0x10, 1, 'a', 'b'}},
+	}
+	for _, tt := range tests {
+		if filename, size, err := ReadPutHeader(pipe(t, tt.data), tt.frame); err == nil {
+			t.Errorf("%s: ReadPutHeader() = %q, %d, want error", tt.name, filename, size)
+		}
+	}
+}
+
+func TestWritePutHeader(t *testing.T) {
+	var wire bytes.Buffer
+	if err := WritePutHeader(&wire, "a.bin", 3); err != nil {
+		t.Fatalf("WritePutHeader() failed: %v", err)
+	}
+	wire.WriteString("xyz")
+	frame, err := ReadFrame(&wire)
+	if err != nil {
+		t.Fatalf("ReadFrame() failed: %v", err)
+	}
+	if filename, data, err := ParsePutFrame(frame); err != nil || filename != "a.bin" || string(data) != "xyz" {
+		t.Errorf("ParsePutFrame() = %q, %q, %v, want %q, %q", filename, data, err, "a.bin", "xyz")
+	}
+
+	for _, tt := range []struct {
+		filename string
+		dataLen  int64
+	}{
+		{filename: strings.Repeat("a", MaxFilenameLen+1), dataLen: 0},
+		{filename: "a.bin", dataLen: 0xFFFFFFFF},
+	} {
+		if err := WritePutHeader(io.Discard, tt.filename, tt.dataLen); err == nil {
+			t.Errorf("WritePutHeader(%d byte filename, %d) succeeded, want error", len(tt.filename), tt.dataLen)
+		}
+	}
+}
+
+func TestParsePutFrame(t *testing.T) {
+	filename, data, err := ParsePutFrame(CreatePutFrame("a.bin", []byte("xyz")))
+	if err != nil || filename != "a.bin" || string(data) != "xyz" {
+		t.Errorf("ParsePutFrame() = %q, %q, %v", filename, data, err)
+	}
+
+	for _, frame := range []*Frame{
+		CreateListFrame(),
+		{OpCode: OpPut, PayloadLen: 2, Payload: []byte{0, 0}},
+		{OpCode: OpPut, PayloadLen: 5, Payload: []byte{0, 0, 0, 9, 'a'}},
+		{OpCode: OpPut, PayloadLen: 5, Payload: []byte{0xFF, 0xFF, 0xFF, 0xFF, 'a'}},
+		CreatePutFrame(strings.Repeat("a", MaxFilenameLen+1), nil),
+	} {
+		if filename, data, err := ParsePutFrame(frame); err == nil {
+			t.Errorf("ParsePutFrame(%+v) = %q, %q, want error", frame, filename, data)
+		}
+	}
+}
+
+func TestParseOptionFrame(t *testing.T) {
+	tests := []struct {
+		frame   *Frame
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{frame: CreateOptionFrame(OptCongestionControl, "cubic"), key: "cc", value: "cubic"},
+		{frame: CreateOptionFrame("cc", ""), key: "cc", value: ""},
+		{frame: CreateOptionFrame("k", "a=b"), key: "k", value: "a=b"},
+		{frame: CreateOptionFrame("", "cubic"), wantErr: true},
+		{frame: &Frame{OpCode: OpOption, PayloadLen: 2, Payload: []byte("cc")}, wantErr: true},
+		{frame: CreateListFrame(), wantErr: true},
+	}
+	for _, tt := range tests {
+		key, value, err := ParseOptionFrame(tt.frame)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseOptionFrame(%q) = %q, %q, want error", tt.frame.Payload, key, value)
+			}
+			continue
+		}
+		if err != nil || key != tt.key || value != tt.value {
+			t.Errorf("ParseOptionFrame(%q) = %q, %q, %v, want %q, %q", tt.frame.Payload, key, value, err, tt.key, tt.value)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// closeAborted is the close reason of connections aborted through the admin API
+const closeAborted = "aborted via admin API"
+
+// activeConn is the live view of a connection shared with the admin API
+type activeConn struct {
+	id        string
+	remote    string
+	start     time.Time
+	conn      net.Conn
+	in        *connReader
+	collector *common.TCPInfoCollector
+	op        atomic.Value // string
+	sent      atomic.Int64
+	aborted   atomic.Bool
+}
+
+// connRegistry tracks the connections currently being served
+type connRegistry struct {
+	mu    sync.Mutex
+	conns map[string]*activeConn
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{conns: make(map[string]*activeConn)}
+}
+
+func (r *connRegistry) add(c *activeConn) {
+	r.mu.Lock()
+	r.conns[c.id] = c
+	r.mu.Unlock()
+}
+
+func (r *connRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.conns, id)
+	r.mu.Unlock()
+}
+
+func (r *connRegistry) get(id string) *activeConn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns[id]
+}
+
+// list returns the active connections ordered by id
+func (r *connRegistry) list() []*activeConn {
+	r.mu.Lock()
+	conns := make([]*activeConn, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		a, _ := strconv.Atoi(conns[i].id)
+		b, _ := strconv.Atoi(conns[j].id)
+		return a < b
+	})
+	return conns
+}
+
+// connStatus is the JSON representation of an active connection
+type connStatus struct {
+	ID             string          `json:"id"`
+	RemoteAddr     string          `json:"remote_addr"`
+	Operation      string          `json:"operation"`
+	BytesReceived  int64           `json:"bytes_received"`
+	BytesSent      int64           `json:"bytes_sent"`
+	ElapsedSeconds float64         `json:"elapsed_seconds"`
+	TCPInfo        *common.TCPInfo `json:"tcp_info,omitempty"` // Most recent TCP_INFO sample
+}
+
+func (c *activeConn) status() connStatus {
+	op, _ := c.op.Load().(string)
+	return connStatus{
+		ID:             c.id,
+		RemoteAddr:     c.remote,
+		Operation:      op,
+		BytesReceived:  c.in.bytes.Load(),
+		BytesSent:      c.sent.Load(),
+		ElapsedSeconds: time.Since(c.start).Seconds(),
+		TCPInfo:        c.collector.Latest(),
+	}
+}
+
+// abort closes the connection; its handler logs it with closeAborted
+func (c *activeConn) abort() {
+	c.aborted.Store(true)
+	c.conn.Close()
+}
+
+// ServeHTTP implements the admin API:
+//
+//	GET         /connections              list active connections
+//	GET         /connections/{id}         one connection
+//	GET         /connections/{id}/samples full TCP_INFO sample history
+//	POST|DELETE /connections/{id}         abort a connection
+func (r *connRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/connections"), "/")
+	if path == "" {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		statuses := []connStatus{}
+		for _, c := range r.list() {
+			statuses = append(statuses, c.status())
+		}
+		writeJSON(w, statuses)
+		return
+	}
+
+	id, sub, _ := strings.Cut(path, "/")
+	c := r.get(id)
+	if c == nil {
+		http.Error(w, "connection not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case sub == "" && req.Method == http.MethodGet:
+		writeJSON(w, c.status())
+	case sub == "" && (req.Method == http.MethodPost || req.Method == http.MethodDelete):
+		c.abort()
+		writeJSON(w, map[string]string{"id": id, "status": "aborted"})
+	case sub == "samples" && req.Method == http.MethodGet:
+		samples := c.collector.GetSamples()
+		if samples == nil {
+			samples = []common.TCPInfo{}
+		}
+		writeJSON(w, samples)
+	case sub == "" || sub == "samples":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
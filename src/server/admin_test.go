@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// testConn registers a connection with the given id on a pipe, returning the
+// client end of the pipe
+func testConn(t *testing.T, r *connRegistry, id string) (*activeConn, net.Conn) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	c := &activeConn{
+		id:        id,
+		remote:    "10.0.0.1:" + id,
+		start:     time.Now().Add(-time.Second),
+		conn:      server,
+		in:        &connReader{},
+		collector: common.NewTCPInfoCollector(),
+	}
+	c.op.Store("PUT")
+	c.in.bytes.Store(1000)
+	c.sent.Store(10)
+	r.add(c)
+	return c, client
+}
+
+func TestConnRegistryServeHTTP(t *testing.T) {
+	r := newConnRegistry()
+	for _, id := range []string{"10", "2", "1"} {
+		testConn(t, r, id)
+	}
+	aborted, peer := testConn(t, r, "7")
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantIDs      []string // IDs in a connection list, in order
+	}{
+		{method: "GET", path: "/connections", wantStatus: http.StatusOK, wantIDs: []string{"1", "2", "7", "10"}},
+		{method: "GET", path: "/connections/", wantStatus: http.StatusOK, wantIDs: []string{"1", "2", "7", "10"}},
+		{method: "POST", path: "/connections", wantStatus: http.StatusMethodNotAllowed},
+		{method: "GET", path: "/connections/2", wantStatus: http.StatusOK},
+		{method: "GET", path: "/connections/2/samples", wantStatus: http.StatusOK},
+		{method: "PUT", path: "/connections/2", wantStatus: http.StatusMethodNotAllowed},
+		{method: "DELETE", path: "/connections/2/samples", wantStatus: http.StatusMethodNotAllowed},
+		{method: "GET", path: "/connections/2/other", wantStatus: http.StatusNotFound},
+		{method: "GET", path: "/connections/99", wantStatus: http.StatusNotFound},
+		{method: "DELETE", path: "/connections/7", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantIDs == nil {
+			continue
+		}
+		var statuses []connStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+		}
+		var ids []string
+		for _, s := range statuses {
+			ids = append(ids, s.ID)
+		}
+		if len(ids) != len(tt.wantIDs) {
+			t.Errorf("%s %s lists %v, want %v", tt.method, tt.path, ids, tt.wantIDs)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.wantIDs[i] {
+				t.Errorf("%s %s lists %v, want %v", tt.method, tt.path, ids, tt.wantIDs)
+				break
+			}
+		}
+	}
+
+	// The aborted connection is closed and flagged for its handler
+	if !aborted.aborted.Load() {
+		t.Errorf("aborted connection not flagged")
+	}
+	if _, err := peer.Write([]byte("x")); err == nil {
+		t.Errorf("aborted connection still open")
+	}
+}
+
+func TestActiveConnStatus(t *testing.T) {
+	r := newConnRegistry()
+	c, _ := testConn(t, r, "3")
+
+	s := c.status()
+	if s.ID != "3" || s.RemoteAddr != "10.0.0.1:3" || s.Operation != "PUT" || s.BytesReceived != 1000 || s.BytesSent != 10 {
+		t.Errorf("status() = %+v", s)
+	}
+	if s.ElapsedSeconds < 1 || s.TCPInfo != nil {
+		t.Errorf("status() = %+v, want over a second elapsed and no TCP_INFO", s)
+	}
+
+	r.remove("3")
+	if r.get("3") != nil || len(r.list()) != 0 {
+		t.Errorf("connection still registered after remove")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Admission modes when the connection limit is reached
+const (
+	admissionReject = "reject"
+	admissionQueue  = "queue"
+)
+
+// admission limits the number of connections handled at once. The accept loop
+// decides on every connection before spawning its handler, so in queue mode only
+// a bounded number of connections wait for a slot, each for a bounded time.
+type admission struct {
+	maxConns     int           // 0 for unlimited
+	perIP        int           // 0 for unlimited
+	mode         string        // admissionReject or admissionQueue
+	queueLen     int           // Connections that may wait for a slot in queue mode
+	queueTimeout time.Duration // Longest wait for a slot, 0 for no limit
+	slots        chan struct{}
+
+	mu     sync.Mutex
+	active map[string]int // Admitted connections per remote IP
+	queued int            // Connections waiting for a slot
+}
+
+// newAdmission validates the admission flags
+func newAdmission(maxConns, perIP int, mode string, queueLen int, queueTimeout time.Duration) (*admission, error) {
+	if maxConns < 0 || perIP < 0 {
+		return nil, fmt.Errorf("connection limits must not be negative")
+	}
+	if mode != admissionReject && mode != admissionQueue {
+		return nil, fmt.Errorf("unknown admission mode %q (want %s or %s)", mode, admissionReject, admissionQueue)
+	}
+	if queueLen < 0 || queueTimeout < 0 {
+		return nil, fmt.Errorf("admission queue length and timeout must not be negative")
+	}
+
+	a := &admission{
+		maxConns:     maxConns,
+		perIP:        perIP,
+		mode:         mode,
+		queueLen:     queueLen,
+		queueTimeout: queueTimeout,
+		active:       make(map[string]int),
+	}
+	if maxConns > 0 {
+		a.slots = make(chan struct{}, maxConns)
+	}
+	return a, nil
+}
+
+// ticket is a connection let in by admission control. A queued ticket still has
+// to wait for its slot.
+type ticket struct {
+	a      *admission
+	ip     string
+	queued bool
+}
+
+// admit decides on a connection from remoteAddr without blocking. It returns the
+// connection's ticket, queued when all slots are taken in queue mode and the
+// queue has room, or the reason the connection was rejected.
+func (a *admission) admit(remoteAddr string) (*ticket, string) {
+	t := &ticket{a: a, ip: remoteIP(remoteAddr)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.perIP > 0 && a.active[t.ip] >= a.perIP {
+		return nil, fmt.Sprintf("per-IP connection limit reached (%d)", a.perIP)
+	}
+
+	if a.slots != nil {
+		select {
+		case a.slots <- struct{}{}:
+		default:
+			if a.mode != admissionQueue || a.queued >= a.queueLen {
+				return nil, a.limitReason()
+			}
+			a.queued++
+			t.queued = true
+		}
+	}
+	a.active[t.ip]++
+	return t, ""
+}
+
+// wait blocks a queued ticket until a slot frees up. When the queue timeout
+// passes first, the ticket gives up its place and the reason the connection was
+// rejected is returned; the ticket must not be released then.
+func (t *ticket) wait() string {
+	if !t.queued {
+		return ""
+	}
+
+	var timeout <-chan time.Time
+	if t.a.queueTimeout > 0 {
+		timer := time.NewTimer(t.a.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case t.a.slots <- struct{}{}:
+		t.a.mu.Lock()
+		t.a.queued--
+		t.a.mu.Unlock()
+		t.queued = false
+		return ""
+	case <-timeout:
+		t.release()
+		return t.a.limitReason()
+	}
+}
+
+// release gives back the ticket's slot, or its place in the queue
+func (t *ticket) release() {
+	a := t.a
+	if !t.queued && a.slots != nil {
+		<-a.slots
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t.queued {
+		a.queued--
+	}
+	if a.active[t.ip]--; a.active[t.ip] <= 0 {
+		delete(a.active, t.ip)
+	}
+}
+
+// limitReason is the reason given to connections over the total limit
+func (a *admission) limitReason() string {
+	return fmt.Sprintf("connection limit reached (%d)", a.maxConns)
+}
+
+// remoteIP strips the port from a remote address
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// timeouts bounds how long a connection may stay idle or open
+type timeouts struct {
+	idle  time.Duration // Maximum time between reads, 0 for none
+	total time.Duration // Maximum connection lifetime, 0 for none
+}
+
+// Close reasons recorded in connection logs
+const (
+	closeQuit        = "quit"
+	closeClientEOF   = "client closed"
+	closeIdleTimeout = "idle timeout"
+	closeMaxDuration = "max duration exceeded"
+)
+
+// arm sets the read deadline for the next read on a connection started at start
+func (t timeouts) arm(conn net.Conn, start time.Time) {
+	var deadline time.Time
+	if t.idle > 0 {
+		deadline = time.Now().Add(t.idle)
+	}
+	if t.total > 0 {
+		if end := start.Add(t.total); deadline.IsZero() || end.Before(deadline) {
+			deadline = end
+		}
+	}
+	if !deadline.IsZero() {
+		conn.SetReadDeadline(deadline)
+	}
+}
+
+// closeReason classifies the error that ended a connection started at start
+func (t timeouts) closeReason(err error, start time.Time) string {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		if t.total > 0 && time.Since(start) >= t.total {
+			return closeMaxDuration
+		}
+		return closeIdleTimeout
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return closeClientEOF
+	}
+	return err.Error()
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewAdmission(t *testing.T) {
+	tests := []struct {
+		maxConns, perIP int
+		mode            string
+		queueLen        int
+		queueTimeout    time.Duration
+		wantErr         bool
+	}{
+		{maxConns: 0, perIP: 0, mode: admissionReject},
+		{maxConns: 10, perIP: 2, mode: admissionQueue, queueLen: 5, queueTimeout: time.Second},
+		{maxConns: -1, mode: admissionReject, wantErr: true},
+		{perIP: -1, mode: admissionReject, wantErr: true},
+		{mode: "drop", wantErr: true},
+		{maxConns: 1, mode: admissionQueue, queueLen: -1, wantErr: true},
+		{maxConns: 1, mode: admissionQueue, queueTimeout: -time.Second, wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := newAdmission(tt.maxConns, tt.perIP, tt.mode, tt.queueLen, tt.queueTimeout)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newAdmission(%d, %d, %q, %d, %v) error = %v, want error %t",
+				tt.maxConns, tt.perIP, tt.mode, tt.queueLen, tt.queueTimeout, err, tt.wantErr)
+		}
+	}
+}
+
+// admissionStep admits a connection from addr, or with an empty addr releases
+// the connection admitted at step release (counting from 0)
+type admissionStep struct {
+	addr    string
+	release int
+	queued  bool   // Whether the connection has to wait for a slot
+	reason  string // Expected rejection, empty if admitted
+}
+
+func TestAdmit(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxConns, perIP int
+		mode            string
+		queueLen        int
+		steps           []admissionStep
+	}{
+		{
+			name:  "unlimited",
+			steps: []admissionStep{{addr: "10.0.0.1:1"}, {addr: "10.0.0.1:2"}, {addr: "10.0.0.2:1"}},
+		},
+		{
+			name:     "total limit",
+			maxConns: 2,
+			steps: []admissionStep{
+				{addr: "10.0.0.1:1"},
+				{addr: "10.0.0.2:1"},
+				{addr: "10.0.0.3:1", reason: "connection limit reached (2)"},
+				{release: 0},
+				{addr: "10.0.0.3:2"},
+			},
+		},
+		{
+			name:  "per-IP limit",
+			perIP: 1,
+			steps: []admissionStep{
+				{addr: "10.0.0.1:1"},
+				{addr: "10.0.0.1:2", reason: "per-IP connection limit reached (1)"},
+				{addr: "10.0.0.2:1"},
+				{addr: "[::1]:1"},
+				{addr: "[::1]:2", reason: "per-IP connection limit reached (1)"},
+				{release: 0},
+				{addr: "10.0.0.1:3"},
+			},
+		},
+		{
+			// A connection over the total limit gives its per-IP count back
+			name:     "both limits",
+			maxConns: 1,
+			perIP:    1,
+			steps: []admissionStep{
+				{addr: "10.0.0.1:1"},
+				{addr: "10.0.0.2:1", reason: "connection limit reached (1)"},
+				{release: 0},
+				{addr: "10.0.0.2:2"},
+			},
+		},
+		{
+			name:     "queue",
+			maxConns: 1,
+			perIP:    2,
+			mode:     admissionQueue,
+			queueLen: 2,
+			steps: []admissionStep{
+				{addr: "10.0.0.1:1"},
+				{addr: "10.0.0.1:2", queued: true},
+				// Queued connections count against the per-IP limit
+				{addr: "10.0.0.1:3", reason: "per-IP connection limit reached (2)"},
+				{addr: "10.0.0.2:1", queued: true},
+				{addr: "10.0.0.3:1", reason: "connection limit reached (1)"},
+				// A connection leaving the queue makes room for another
+				{release: 1},
+				{addr: "10.0.0.3:2", queued: true},
+			},
+		},
+		{
+			name:     "queue without room",
+			maxConns: 1,
+			mode:     admissionQueue,
+			steps: []admissionStep{
+				{addr: "10.0.0.1:1"},
+				{addr: "10.0.0.2:1", reason: "connection limit reached (1)"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode := tt.mode
+			if mode == "" {
+				mode = admissionReject
+			}
+			a, err := newAdmission(tt.maxConns, tt.perIP, mode, tt.queueLen, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			held := make(map[int]*ticket)
+			for i, s := range tt.steps {
+				if s.addr == "" {
+					held[s.release].release()
+					delete(held, s.release)
+					continue
+				}
+				ticket, reason := a.admit(s.addr)
+				if reason != s.reason || (ticket == nil) != (s.reason != "") {
+					t.Fatalf("step %d: admit(%q) = %t, %q, want %q", i, s.addr, ticket != nil, reason, s.reason)
+				}
+				if ticket == nil {
+					continue
+				}
+				if ticket.queued != s.queued {
+					t.Fatalf("step %d: admit(%q) queued = %t, want %t", i, s.addr, ticket.queued, s.queued)
+				}
+				if !s.queued {
+					if reason := ticket.wait(); reason != "" {
+						t.Fatalf("step %d: wait() on an admitted connection = %q", i, reason)
+					}
+				}
+				held[i] = ticket
+			}
+
+			// Releasing everything leaves no accounting behind
+			for _, ticket := range held {
+				ticket.release()
+			}
+			if len(a.active) != 0 || a.queued != 0 {
+				t.Errorf("accounting left after releasing everything: %v per IP, %d queued", a.active, a.queued)
+			}
+			if len(a.slots) != 0 {
+				t.Errorf("%d slots held after releasing everything", len(a.slots))
+			}
+		})
+	}
+}
+
+func TestAdmissionQueue(t *testing.T) {
+	a, err := newAdmission(1, 0, admissionQueue, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, reason := a.admit("10.0.0.1:1")
+	if first == nil {
+		t.Fatalf("admit() rejected the first connection: %s", reason)
+	}
+	second, reason := a.admit("10.0.0.2:1")
+	if second == nil || !second.queued {
+		t.Fatalf("admit() did not queue the second connection: %s", reason)
+	}
+
+	admitted := make(chan string)
+	go func() {
+		admitted <- second.wait()
+	}()
+	select {
+	case <-admitted:
+		t.Fatalf("second connection admitted over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.release()
+	select {
+	case reason := <-admitted:
+		if reason != "" {
+			t.Fatalf("queued connection rejected after a release: %s", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("queued connection not admitted after a release")
+	}
+	if a.queued != 0 || len(a.slots) != 1 {
+		t.Errorf("after admitting from the queue: %d queued, %d slots held, want 0 and 1", a.queued, len(a.slots))
+	}
+	second.release()
+	if len(a.active) != 0 || len(a.slots) != 0 {
+		t.Errorf("accounting left after releasing everything: %v per IP, %d slots", a.active, len(a.slots))
+	}
+}
+
+func TestAdmissionQueueTimeout(t *testing.T) {
+	a, err := newAdmission(1, 0, admissionQueue, 1, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := a.admit("10.0.0.1:1")
+	second, _ := a.admit("10.0.0.2:1")
+
+	start := time.Now()
+	if reason := second.wait(); reason != "connection limit reached (1)" {
+		t.Fatalf("wait() past the queue timeout = %q, want the limit reason", reason)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("wait() gave up after %v, before the queue timeout", waited)
+	}
+	// The rejected connection gave its place and per-IP count back
+	if a.queued != 0 || a.active["10.0.0.2"] != 0 || a.active["10.0.0.1"] != 1 {
+		t.Errorf("after the queue timeout: %d queued, %v per IP", a.queued, a.active)
+	}
+	if third, reason := a.admit("10.0.0.3:1"); third == nil || !third.queued {
+		t.Errorf("admit() after the queue timeout = %v, %q, want a queued connection", third, reason)
+	} else {
+		third.release()
+	}
+	first.release()
+}
+
+func TestRemoteIP(t *testing.T) {
+	for addr, want := range map[string]string{
+		"10.0.0.1:5201": "10.0.0.1",
+		"[::1]:80":      "::1",
+		"pipe":          "pipe",
+	} {
+		if got := remoteIP(addr); got != want {
+			t.Errorf("remoteIP(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestTimeoutsArm(t *testing.T) {
+	tests := []struct {
+		timeouts timeouts
+		age      time.Duration // How long the connection has been open
+		want     time.Duration // Expected wait for the read to time out, 0 for none
+	}{
+		{timeouts: timeouts{}, want: 0},
+		{timeouts: timeouts{idle: 50 * time.Millisecond}, want: 50 * time.Millisecond},
+		{timeouts: timeouts{idle: time.Minute, total: time.Minute}, age: time.Minute - 50*time.Millisecond, want: 50 * time.Millisecond},
+		{timeouts: timeouts{idle: 50 * time.Millisecond, total: time.Minute}, want: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		tt.timeouts.arm(server, time.Now().Add(-tt.age))
+		start := time.Now()
+		if tt.want == 0 {
+			// Without a deadline the read only ends when the peer closes
+			time.AfterFunc(100*time.Millisecond, func() { client.Close() })
+		}
+		_, err := server.Read(make([]byte, 1))
+		waited := time.Since(start)
+		client.Close()
+		server.Close()
+
+		var ne net.Error
+		timedOut := errors.As(err, &ne) && ne.Timeout()
+		if timedOut != (tt.want > 0) {
+			t.Errorf("%+v: read error = %v, want timeout %t", tt.timeouts, err, tt.want > 0)
+			continue
+		}
+		if tt.want > 0 && (waited < tt.want-10*time.Millisecond || waited > tt.want+time.Second) {
+			t.Errorf("%+v: read timed out after %v, want about %v", tt.timeouts, waited, tt.want)
+		}
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	tests := []struct {
+		timeouts timeouts
+		err      error
+		age      time.Duration // How long the connection has been open
+		want     string
+	}{
+		{timeouts: timeouts{idle: time.Second}, err: os.ErrDeadlineExceeded, age: 2 * time.Second, want: closeIdleTimeout},
+		{timeouts: timeouts{idle: time.Second, total: time.Minute}, err: fmt.Errorf("read: %w", os.ErrDeadlineExceeded), age: time.Second, want: closeIdleTimeout},
+		{timeouts: timeouts{idle: time.Second, total: time.Minute}, err: os.ErrDeadlineExceeded, age: time.Minute, want: closeMaxDuration},
+		{timeouts: timeouts{total: time.Minute}, err: io.EOF, want: closeClientEOF},
+		{err: fmt.Errorf("failed to read opcode: %w", io.ErrUnexpectedEOF), want: closeClientEOF},
+		{err: errors.New("connection reset by peer"), want: "connection reset by peer"},
+	}
+	for _, tt := range tests {
+		if got := tt.timeouts.closeReason(tt.err, time.Now().Add(-tt.age)); got != tt.want {
+			t.Errorf("closeReason(%v) after %v = %q, want %q", tt.err, tt.age, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+// opName names an operation code for logs and metrics
+func opName(opCode byte) string {
+	switch opCode {
+	case protocol.OpList:
+		return "LIST"
+	case protocol.OpPut:
+		return "PUT"
+	case protocol.OpOption:
+		return "OPTION"
+	case protocol.OpGet:
+		return "GET"
+	case protocol.OpPing:
+		return "PING"
+	case protocol.OpSend:
+		return "SEND"
+	case protocol.OpData:
+		return "DATA"
+	case protocol.OpQuit:
+		return "QUIT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// connSettings holds the per-connection settings clients change with OPTION frames
+type connSettings struct {
+	throttle  readThrottle
+	namespace string          // Storage namespace, empty for the top-level directory
+	zeroCopy  bool            // Splice uploads into files (without a read throttle) and sendfile downloads
+	payload   *common.Payload // Uploads are verified against this payload and dropped, nil to store them
+	pacing    *common.Pacing  // Rate limit for downloads, disabled if nil or zero
+}
+
+// connReader reads from a client connection, re-arming the timeouts before every
+// read and accounting received bytes to the current operation as they arrive
+type connReader struct {
+	conn     net.Conn
+	timeouts timeouts
+	start    time.Time
+	metrics  *metrics
+	op       string // Operation the next bytes belong to, empty while reading a frame header
+	bytes    atomic.Int64
+}
+
+func (r *connReader) Read(p []byte) (int, error) {
+	r.timeouts.arm(r.conn, r.start)
+	n, err := r.conn.Read(p)
+	r.bytes.Add(int64(n))
+	if r.op != "" && n > 0 {
+		r.metrics.received(r.op, int64(n))
+	}
+	return n, err
+}
+
+// direct returns a reader of the next n bytes straight from the socket, so that
+// copying it into a file can use splice(2) instead of passing through user space.
+// Only the maximum connection duration bounds the transfer, and the bytes are
+// accounted when done is called.
+func (r *connReader) direct(n int64) (lr *io.LimitedReader, done func()) {
+	var deadline time.Time
+	if r.timeouts.total > 0 {
+		deadline = r.start.Add(r.timeouts.total)
+	}
+	r.conn.SetReadDeadline(deadline)
+
+	lr = &io.LimitedReader{R: r.conn, N: n}
+	return lr, func() {
+		read := n - lr.N
+		r.bytes.Add(read)
+		if r.op != "" && read > 0 {
+			r.metrics.received(r.op, read)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// soReusePort is SO_REUSEPORT, missing from the syscall package
+const soReusePort = 15
+
+// listenerConfig is one entry of the -listeners file. Unset fields fall back to
+// the server's command line flags.
+type listenerConfig struct {
+	Name          string               `json:"name"`
+	Host          string               `json:"host"`
+	Port          string               `json:"port"`
+	SocketProfile string               `json:"sock_profile"` // Named socket profile
+	Socket        common.SocketProfile `json:"socket"`       // Overrides on top of the named profile
+	Storage       string               `json:"storage"`      // disk or discard
+	FileDir       string               `json:"file_dir"`     // Directory of the disk backend
+	ReadThrottle  string               `json:"read_throttle"`
+	RelayUpstream string               `json:"relay_upstream"` // Forward LIST and PUT to this server
+	RelayCC       string               `json:"relay_cc"`       // Congestion control of the upstream socket
+}
+
+// loadListenerConfigs reads a JSON array of listener configurations
+func loadListenerConfigs(path string) ([]listenerConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read listeners: %v", err)
+	}
+
+	var configs []listenerConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse listeners %s: %v", path, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no listeners in %s", path)
+	}
+
+	names := make(map[string]bool)
+	for _, c := range configs {
+		if c.Name == "" || c.Port == "" {
+			return nil, fmt.Errorf("listener in %s needs a name and a port", path)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate listener %q in %s", c.Name, path)
+		}
+		names[c.Name] = true
+	}
+	return configs, nil
+}
+
+// listenerProfile is the resolved configuration of one listening address
+type listenerProfile struct {
+	name     string // Empty for the listener configured by the command line flags
+	address  string
+	socket   *common.SocketProfile
+	store    backend
+	throttle readThrottle // Default slow-reader emulation, clients may override it per connection
+
+	upstream   string // Relay mode upstream server, empty to use the storage backend
+	upstreamCC string
+}
+
+func (p *listenerProfile) String() string {
+	s := fmt.Sprintf("%s, storage %s, socket profile %s", p.address, p.store, p.socket)
+	if p.upstream != "" {
+		s = fmt.Sprintf("%s, relaying to %s, socket profile %s", p.address, p.relayString(), p.socket)
+	}
+	if p.throttle.enabled() {
+		s += fmt.Sprintf(", read throttle %s", p.throttle)
+	}
+	return s
+}
+
+// resolveListener fills in a listener configuration from the server defaults,
+// taking the relay settings from defaults.
+// Listeners without a file_dir share the default disk backend.
+func resolveListener(c, defaults listenerConfig, host string, sockFlags *common.SocketFlags, throttle readThrottle,
+	store *storage, diskStore func(dir string) (*storage, error)) (*listenerProfile, error) {
+	if c.Host != "" {
+		host = c.Host
+	}
+	p := &listenerProfile{name: c.Name, address: net.JoinHostPort(host, c.Port), throttle: throttle}
+
+	var err error
+	if p.socket, err = sockFlags.ResolveProfile(c.SocketProfile, c.Socket); err != nil {
+		return nil, err
+	}
+
+	switch c.Storage {
+	case "", backendDisk:
+		p.store = store
+		if c.FileDir != "" {
+			if p.store, err = diskStore(c.FileDir); err != nil {
+				return nil, err
+			}
+		}
+	case backendDiscard:
+		p.store = discardStorage{}
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (want %s or %s)", c.Storage, backendDisk, backendDiscard)
+	}
+
+	if c.ReadThrottle != "" {
+		if p.throttle, err = parseReadThrottle(c.ReadThrottle); err != nil {
+			return nil, err
+		}
+	}
+
+	p.upstream, p.upstreamCC = defaults.RelayUpstream, defaults.RelayCC
+	if c.RelayUpstream != "" {
+		p.upstream = c.RelayUpstream
+	}
+	if c.RelayCC != "" {
+		if err := common.ValidateCongestionControl(c.RelayCC); err != nil {
+			return nil, err
+		}
+		p.upstreamCC = c.RelayCC
+	}
+	return p, nil
+}
+
+// relayString describes the upstream of a relaying listener
+func (p *listenerProfile) relayString() string {
+	if p.upstreamCC != "" {
+		return fmt.Sprintf("%s (cc=%s)", p.upstream, p.upstreamCC)
+	}
+	return p.upstream
+}
+
+// listener is one accept loop of the server
+type listener struct {
+	name     string
+	profile  *listenerProfile
+	ln       net.Listener
+	accepted atomic.Uint64
+}
+
+// listenerStatus is the JSON representation of a listener
+type listenerStatus struct {
+	Name        string `json:"name"`
+	Profile     string `json:"profile,omitempty"`
+	Address     string `json:"address"`
+	Connections uint64 `json:"connections"`
+}
+
+// openListeners listens on the profile's address with its socket profile applied.
+// With more than one acceptor every listener sets SO_REUSEPORT and the kernel
+// spreads incoming connections across them.
+func openListeners(p *listenerProfile, acceptors int) ([]*listener, error) {
+	if acceptors < 1 {
+		return nil, fmt.Errorf("need at least one acceptor, got %d", acceptors)
+	}
+
+	profile := p.socket
+	address := p.address
+	control := profile.Control
+	if acceptors > 1 {
+		control = func(network, address string, c syscall.RawConn) error {
+			var optErr error
+			err := c.Control(func(fd uintptr) {
+				optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+			})
+			if err != nil {
+				return err
+			}
+			if optErr != nil {
+				return fmt.Errorf("failed to set SO_REUSEPORT: %v", optErr)
+			}
+			return profile.Control(network, address, c)
+		}
+	}
+
+	// Accepted sockets inherit the profile from the listener
+	lc := net.ListenConfig{Control: control}
+	var listeners []*listener
+	for i := 0; i < acceptors; i++ {
+		ln, err := lc.Listen(context.Background(), "tcp", address)
+		if err != nil {
+			for _, l := range listeners {
+				l.ln.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %v", address, err)
+		}
+
+		name := p.name
+		switch {
+		case acceptors > 1 && name != "":
+			name = fmt.Sprintf("%s/acceptor-%d", p.name, i+1)
+		case acceptors > 1:
+			name = fmt.Sprintf("acceptor-%d", i+1)
+		case name == "":
+			name = "main"
+		}
+		listeners = append(listeners, &listener{name: name, profile: p, ln: ln})
+	}
+	return listeners, nil
+}
+
+// acceptLoop accepts connections until the listener is closed
+func (s *server) acceptLoop(l *listener) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("failed to accept connection", "listener", l.name, "err", err)
+			continue
+		}
+
+		l.accepted.Add(1)
+		s.metrics.accepted(l.name)
+
+		// Admission is decided before the handler starts, so connections over the
+		// limits only live long enough to be told so
+		t, rejectReason := s.admission.admit(conn.RemoteAddr().String())
+		go s.handleConnection(conn, l, t, rejectReason)
+	}
+}
+
+// listenerStatuses reports how many connections each listener accepted
+func (s *server) listenerStatuses() []listenerStatus {
+	statuses := make([]listenerStatus, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		statuses = append(statuses, listenerStatus{
+			Name:        l.name,
+			Profile:     l.profile.name,
+			Address:     l.profile.address,
+			Connections: l.accepted.Load(),
+		})
+	}
+	return statuses
+}
+
+// ServeHTTP implements GET /listeners of the admin API
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.listenerStatuses())
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// freePort returns a loopback address nothing listens on
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func closeListeners(listeners []*listener) {
+	for _, l := range listeners {
+		l.ln.Close()
+	}
+}
+
+func TestOpenListeners(t *testing.T) {
+	profile := func(address string) *listenerProfile {
+		return &listenerProfile{address: address, socket: &common.SocketProfile{}}
+	}
+	if _, err := openListeners(profile(freePort(t)), 0); err == nil {
+		t.Errorf("openListeners() with no acceptors succeeded")
+	}
+
+	address := freePort(t)
+	single, err := openListeners(profile(address), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(single) != 1 || single[0].name != "main" {
+		t.Errorf("openListeners() with one acceptor = %d listeners, first %q", len(single), single[0].name)
+	}
+	// Without SO_REUSEPORT the address cannot be shared
+	if ls, err := openListeners(profile(address), 2); err == nil {
+		closeListeners(ls)
+		t.Errorf("openListeners() on an address in use succeeded")
+	}
+	closeListeners(single)
+
+	address = freePort(t)
+	listeners, err := openListeners(profile(address), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeListeners(listeners)
+	for i, l := range listeners {
+		if want := fmt.Sprintf("acceptor-%d", i+1); l.name != want || l.ln.Addr().String() != address {
+			t.Errorf("listener %d = %s on %s, want %s on %s", i, l.name, l.ln.Addr(), want, address)
+		}
+	}
+}
+
+func TestListenerStatuses(t *testing.T) {
+	listeners, err := openListeners(&listenerProfile{name: "bulk", address: freePort(t), socket: &common.SocketProfile{}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeListeners(listeners)
+	listeners[0].accepted.Add(3)
+	listeners[1].accepted.Add(1)
+	s := &server{listeners: listeners}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/listeners", nil))
+	var got []listenerStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []listenerStatus{
+		{Name: "bulk/acceptor-1", Profile: "bulk", Address: listeners[0].profile.address, Connections: 3},
+		{Name: "bulk/acceptor-2", Profile: "bulk", Address: listeners[1].profile.address, Connections: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /listeners = %+v, want %+v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("POST", "/listeners", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /listeners = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestLoadListenerConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []listenerConfig
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			content: `[{"name": "bulk", "port": "9001", "sock_profile": "large-buffers", "storage": "discard"},
+				{"name": "slow", "host": "127.0.0.1", "port": "9002", "socket": {"rcvbuf": 65536}, "read_throttle": "rate=1000"}]`,
+			want: []listenerConfig{
+				{Name: "bulk", Port: "9001", SocketProfile: "large-buffers", Storage: backendDiscard},
+				{Name: "slow", Host: "127.0.0.1", Port: "9002", Socket: common.SocketProfile{RcvBuf: 65536}, ReadThrottle: "rate=1000"},
+			},
+		},
+		{name: "empty", content: `[]`, wantErr: true},
+		{name: "no port", content: `[{"name": "a"}]`, wantErr: true},
+		{name: "no name", content: `[{"port": "1"}]`, wantErr: true},
+		{name: "duplicate", content: `[{"name": "a", "port": "1"}, {"name": "a", "port": "2"}]`, wantErr: true},
+		{name: "not JSON", content: `name=a`, wantErr: true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "listeners.json")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadListenerConfigs(path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: loadListenerConfigs() = %+v, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: loadListenerConfigs() = %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveListener(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	sockFlags := common.RegisterSocketFlags(fs)
+	if err := fs.Parse([]string{"-sndbuf", "131072"}); err != nil {
+		t.Fatal(err)
+	}
+	defaultThrottle := readThrottle{Rate: 5000}
+	defaultStore, err := newStorage(t.TempDir(), fsyncPolicy{Mode: fsyncNone}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened []string
+	diskStore := func(dir string) (*storage, error) {
+		opened = append(opened, dir)
+		return newStorage(dir, fsyncPolicy{Mode: fsyncNone}, 0, 0)
+	}
+	otherDir := t.TempDir()
+	defaults := listenerConfig{RelayUpstream: "upstream:9000"}
+
+	tests := []struct {
+		config       listenerConfig
+		wantAddress  string
+		wantSocket   common.SocketProfile
+		wantStore    string
+		wantRate     int64
+		wantUpstream string
+		wantErr      bool
+	}{
+		{
+			config:       listenerConfig{Name: "a", Port: "9001"},
+			wantAddress:  "0.0.0.0:9001",
+			wantSocket:   common.SocketProfile{Name: "default", SndBuf: 131072},
+			wantStore:    defaultStore.String(),
+			wantRate:     5000,
+			wantUpstream: "upstream:9000",
+		},
+		{
+			config:       listenerConfig{Name: "b", Host: "::1", Port: "9002", SocketProfile: "small-buffers", Socket: common.SocketProfile{RcvBuf: 1000}, Storage: backendDiscard, ReadThrottle: "rate=10"},
+			wantAddress:  "[::1]:9002",
+			wantSocket:   common.SocketProfile{Name: "small-buffers", SndBuf: 131072, RcvBuf: 1000},
+			wantStore:    backendDiscard,
+			wantRate:     10,
+			wantUpstream: "upstream:9000",
+		},
+		{
+			config:       listenerConfig{Name: "c", Port: "9003", FileDir: otherDir},
+			wantAddress:  "0.0.0.0:9003",
+			wantSocket:   common.SocketProfile{Name: "default", SndBuf: 131072},
+			wantStore:    "disk:" + otherDir + " (fsync none)",
+			wantRate:     5000,
+			wantUpstream: "upstream:9000",
+		},
+		{
+			config:       listenerConfig{Name: "g", Port: "9004", RelayUpstream: "other:9000", RelayCC: "reno"},
+			wantAddress:  "0.0.0.0:9004",
+			wantSocket:   common.SocketProfile{Name: "default", SndBuf: 131072},
+			wantStore:    defaultStore.String(),
+			wantRate:     5000,
+			wantUpstream: "other:9000 (cc=reno)",
+		},
+		{config: listenerConfig{Name: "h", Port: "1", RelayCC: "no-such-algorithm"}, wantErr: true},
+		{config: listenerConfig{Name: "d", Port: "1", SocketProfile: "missing"}, wantErr: true},
+		{config: listenerConfig{Name: "e", Port: "1", Storage: "tape"}, wantErr: true},
+		{config: listenerConfig{Name: "f", Port: "1", ReadThrottle: "rate=x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := resolveListener(tt.config, defaults, "0.0.0.0", sockFlags, defaultThrottle, defaultStore, diskStore)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveListener(%+v) = %v, want error", tt.config, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveListener(%+v) failed: %v", tt.config, err)
+			continue
+		}
+		if p.name != tt.config.Name || p.address != tt.wantAddress || !reflect.DeepEqual(*p.socket, tt.wantSocket) ||
+			p.store.String() != tt.wantStore || p.throttle.Rate != tt.wantRate || p.relayString() != tt.wantUpstream {
+			t.Errorf("resolveListener(%+v) = %v, socket %v", tt.config, p, p.socket)
+		}
+	}
+	if len(opened) != 1 || opened[0] != otherDir {
+		t.Errorf("disk backends opened: %v, want only %s", opened, otherDir)
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -22,131 +26,595 @@ func main() {
 	port := flag.String("port", "8080", "Server port")
 	fileDir := flag.String("file-dir", "./files", "File storage directory")
 	logDir := flag.String("log-dir", "./logs", "Log directory")
+	fsyncMode := flag.String("fsync", fsyncNone, "Fsync policy for uploads: none, on-close or every-n")
+	fsyncBytes := flag.Int64("fsync-bytes", 8*1024*1024, "Bytes written between fsyncs with -fsync=every-n")
+	quota := flag.Int64("quota", 0, "Total storage quota in bytes across all namespaces (0 for unlimited)")
+	nsQuota := flag.Int64("ns-quota", 0, "Storage quota in bytes per namespace (0 for unlimited)")
+	retention := flag.Duration("retention", 0, "Purge namespaces with no file newer than this (0 to keep forever)")
+	retentionInterval := flag.Duration("retention-interval", time.Minute, "How often the retention policy runs")
+	readThrottleSpec := flag.String("read-throttle", "", "Slow-reader emulation for uploads, e.g. rate=1048576,stall=2s:500ms,pause=1s/200ms")
+	maxConns := flag.Int("max-conns", 0, "Maximum concurrent connections (0 for unlimited)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "Maximum concurrent connections per client IP (0 for unlimited)")
+	admissionMode := flag.String("admission", admissionReject, "What to do when -max-conns is reached: reject or queue")
+	queueLen := flag.Int("queue-len", 64, "Connections that may wait for a slot with -admission queue; further ones are rejected")
+	queueTimeout := flag.Duration("queue-timeout", 30*time.Second, "Longest wait for a slot with -admission queue before the connection is rejected (0 for no limit)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Close connections idle for this long (0 for no timeout)")
+	maxConnDuration := flag.Duration("max-conn-duration", 0, "Close connections open for this long (0 for no limit)")
+	sampleInterval := flag.Duration("sample-interval", 100*time.Millisecond, "TCP_INFO sampling interval per connection (0 to disable)")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9100 (disabled if empty)")
+	relayUpstream := flag.String("relay-upstream", "", "Relay mode: forward LIST and PUT to this upstream server (host:port) instead of storing files")
+	relayCC := flag.String("relay-cc", "", "Congestion control of the upstream socket in relay mode (default: socket profile)")
+	listenersFile := flag.String("listeners", "", "JSON file with one profile per listening port (replaces -host/-port)")
+	pacingSpec := flag.String("pacing", "", "Application-level download rate limit, e.g. rate=1048576,burst=65536,step=10s:524288; clients may override it per connection")
+	zeroCopy := flag.Bool("zero-copy", false, "Splice uploads from the socket into files with splice(2) (not with -read-throttle) and send downloads with sendfile(2)")
+	acceptors := flag.Int("acceptors", 1, "Number of SO_REUSEPORT listeners, each with its own accept loop")
+	adminAddr := flag.String("admin-addr", "", "Serve the JSON admin API on this address, e.g. :9101 (disabled if empty)")
+	sockFlags := common.RegisterSocketFlags(flag.CommandLine)
+	logFlags := common.RegisterEventLogFlags(flag.CommandLine)
+	configFlags := common.RegisterConfigFlags(flag.CommandLine)
 	flag.Parse()
 
-	// Create file directory if it doesn't exist
-	if err := os.MkdirAll(*fileDir, 0755); err != nil {
-		fmt.Printf("Failed to create file directory: %v\n", err)
+	if err := configFlags.Load(); err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
 		return
 	}
 
 	logger := common.NewLogger(*logDir)
-	address := fmt.Sprintf("%s:%s", *host, *port)
+	logger.SetMetadata(configFlags.Metadata())
+	logger.SetConfig(configFlags.Effective())
+	closeEventLog, err := logFlags.Setup("server", logger.Scenario())
+	if err != nil {
+		fmt.Printf("Invalid event log: %v\n", err)
+		return
+	}
+	defer closeEventLog()
 
-	// Start server
-	listener, err := net.Listen("tcp", address)
+	admission, err := newAdmission(*maxConns, *maxConnsPerIP, *admissionMode, *queueLen, *queueTimeout)
+	if err != nil {
+		fmt.Printf("Invalid admission control: %v\n", err)
+		return
+	}
+
+	throttle, err := parseReadThrottle(*readThrottleSpec)
+	if err != nil {
+		fmt.Printf("Invalid read throttle: %v\n", err)
+		return
+	}
+
+	pacing, err := common.ParsePacing(*pacingSpec)
+	if err != nil {
+		fmt.Printf("Invalid pacing: %v\n", err)
+		return
+	}
+
+	profile, err := sockFlags.Resolve()
+	if err != nil {
+		fmt.Printf("Invalid socket profile: %v\n", err)
+		return
+	}
+
+	fsync, err := parseFsyncPolicy(*fsyncMode, *fsyncBytes)
+	if err != nil {
+		fmt.Printf("Invalid fsync policy: %v\n", err)
+		return
+	}
+
+	// Disk backends are shared by listeners using the same directory
+	stores := make(map[string]*storage)
+	diskStore := func(dir string) (*storage, error) {
+		if stores[dir] == nil {
+			store, err := newStorage(dir, fsync, *quota, *nsQuota)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create file directory %s: %v", dir, err)
+			}
+			stores[dir] = store
+		}
+		return stores[dir], nil
+	}
+
+	// Create file directory if it doesn't exist
+	store, err := diskStore(*fileDir)
 	if err != nil {
-		fmt.Printf("Failed to listen on %s: %v\n", address, err)
+		fmt.Printf("%v\n", err)
 		return
 	}
-	defer listener.Close()
+
+	if *relayCC != "" {
+		if err := common.ValidateCongestionControl(*relayCC); err != nil {
+			fmt.Printf("Invalid relay congestion control: %v\n", err)
+			return
+		}
+	}
+	relayDefaults := listenerConfig{RelayUpstream: *relayUpstream, RelayCC: *relayCC}
+
+	profiles := []*listenerProfile{{
+		address:    fmt.Sprintf("%s:%s", *host, *port),
+		socket:     profile,
+		store:      store,
+		throttle:   throttle,
+		upstream:   *relayUpstream,
+		upstreamCC: *relayCC,
+	}}
+	if *listenersFile != "" {
+		configs, err := loadListenerConfigs(*listenersFile)
+		if err != nil {
+			fmt.Printf("Invalid listeners: %v\n", err)
+			return
+		}
+
+		profiles = nil
+		for _, c := range configs {
+			p, err := resolveListener(c, relayDefaults, *host, sockFlags, throttle, store, diskStore)
+			if err != nil {
+				fmt.Printf("Invalid listener %s: %v\n", c.Name, err)
+				return
+			}
+			profiles = append(profiles, p)
+		}
+	}
+
+	if *retention > 0 {
+		for _, store := range stores {
+			go store.retain(*retention, *retentionInterval)
+		}
+	}
+
+	srv := &server{
+		logger:    logger,
+		admission: admission,
+		timeouts:  timeouts{idle: *idleTimeout, total: *maxConnDuration},
+		interval:  *sampleInterval,
+		metrics:   newMetrics(),
+		conns:     newConnRegistry(),
+		zeroCopy:  *zeroCopy,
+		pacing:    pacing,
+	}
+
+	// Start server
+	for _, p := range profiles {
+		listeners, err := openListeners(p, *acceptors)
+		if err != nil {
+			for _, l := range srv.listeners {
+				l.ln.Close()
+			}
+			fmt.Printf("%v\n", err)
+			return
+		}
+		srv.listeners = append(srv.listeners, listeners...)
+	}
 
 	fmt.Printf("TCP File Transfer Server\n")
-	fmt.Printf("Listening on: %s\n", address)
-	fmt.Printf("File directory: %s\n", *fileDir)
+	if *listenersFile == "" {
+		fmt.Printf("Listening on: %s\n", profiles[0].address)
+		if *relayUpstream != "" {
+			fmt.Printf("Relaying to: %s\n", profiles[0].relayString())
+		} else {
+			fmt.Printf("File directory: %s\n", *fileDir)
+		}
+	} else {
+		for _, p := range profiles {
+			fmt.Printf("Listener %s: %s\n", p.name, p)
+		}
+	}
+	if *acceptors > 1 {
+		fmt.Printf("Acceptors: %d per address (SO_REUSEPORT)\n", *acceptors)
+	}
+	fmt.Printf("Fsync policy: %s\n", fsync)
+	if pacing.Enabled() {
+		fmt.Printf("Download pacing: %s\n", pacing)
+	}
+	if *quota > 0 || *nsQuota > 0 {
+		fmt.Printf("Storage quota: %d bytes total, %d bytes per namespace\n", *quota, *nsQuota)
+	}
+	if *retention > 0 {
+		fmt.Printf("Retention: namespaces purged after %v\n", *retention)
+	}
+	if *listenersFile == "" {
+		fmt.Printf("Socket profile: %s\n", profile)
+		if throttle.enabled() {
+			fmt.Printf("Read throttle: %s\n", throttle)
+		}
+	}
+	if *maxConns > 0 || *maxConnsPerIP > 0 {
+		fmt.Printf("Connection limits: %d total (%s), %d per IP\n", *maxConns, *admissionMode, *maxConnsPerIP)
+		if *maxConns > 0 && *admissionMode == admissionQueue {
+			fmt.Printf("Admission queue: %d connections, timeout %v\n", *queueLen, *queueTimeout)
+		}
+	}
+	if *idleTimeout > 0 || *maxConnDuration > 0 {
+		fmt.Printf("Timeouts: idle %v, max duration %v\n", *idleTimeout, *maxConnDuration)
+	}
 	fmt.Printf("Log directory: %s\n", *logDir)
 
+	srv.serveHTTP(*metricsAddr, *adminAddr)
+
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		fmt.Println("\nShutting down server...")
-		listener.Close()
-		os.Exit(0)
+		slog.Info("shutting down")
+		for _, l := range srv.listeners {
+			l.ln.Close()
+		}
 	}()
 
-	// Accept connections
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Printf("Failed to accept connection: %v\n", err)
-			continue
+	// Accept connections, one loop per listener
+	var wg sync.WaitGroup
+	for _, l := range srv.listeners {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+			srv.acceptLoop(l)
+		}(l)
+	}
+	wg.Wait()
+
+	for _, l := range srv.listeners {
+		slog.Info("listener stopped", "listener", l.name, "connections", l.accepted.Load())
+	}
+}
+
+// server holds the state shared by all connections
+type server struct {
+	logger     *common.Logger
+	admission  *admission
+	timeouts   timeouts
+	interval   time.Duration // TCP_INFO sampling interval, 0 to disable
+	metrics    *metrics
+	conns      *connRegistry
+	listeners  []*listener
+	zeroCopy   bool           // Receive uploads with splice(2) and send downloads with sendfile(2)
+	pacing     *common.Pacing // Default download rate limit
+	nextConnID atomic.Uint64
+}
+
+// serveHTTP starts the metrics and admin HTTP endpoints; both share one
+// listener when given the same address
+func (s *server) serveHTTP(metricsAddr, adminAddr string) {
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
 		}
+		return muxes[addr]
+	}
 
-		// Handle connection concurrently
-		go handleConnection(conn, *fileDir, logger)
+	if metricsAddr != "" {
+		mux(metricsAddr).Handle("/metrics", s.metrics)
+		fmt.Printf("Metrics: http://%s/metrics\n", metricsAddr)
+	}
+	if adminAddr != "" {
+		mux(adminAddr).Handle("/connections", s.conns)
+		mux(adminAddr).Handle("/connections/", s.conns)
+		mux(adminAddr).Handle("/listeners", s)
+		fmt.Printf("Admin API: http://%s/connections\n", adminAddr)
+	}
+
+	for addr, m := range muxes {
+		go func(addr string, m *http.ServeMux) {
+			if err := http.ListenAndServe(addr, m); err != nil {
+				slog.Error("HTTP listener failed", "addr", addr, "err", err)
+			}
+		}(addr, m)
 	}
 }
 
-func handleConnection(conn net.Conn, fileDir string, logger *common.Logger) {
+// handleConnection serves a connection admitted with t, or rejects it with
+// rejectReason as decided by the accept loop
+func (s *server) handleConnection(conn net.Conn, l *listener, t *ticket, rejectReason string) {
 	defer conn.Close()
 	startTime := time.Now()
 	remoteAddr := conn.RemoteAddr().String()
+	connID := fmt.Sprintf("%d", s.nextConnID.Add(1))
+
+	events := slog.With("conn_id", connID, "remote", remoteAddr, "listener", l.name)
+	events.Info("connection accepted")
+
+	if rejectReason == "" {
+		rejectReason = t.wait()
+	}
+	if rejectReason != "" {
+		s.rejectConnection(conn, l, events, connID, startTime, rejectReason)
+		return
+	}
+	defer t.release()
+	s.metrics.connOpened()
+	defer s.metrics.connClosed()
+
+	// Time spent queued for admission does not count against the connection
+	startTime = time.Now()
+	if s.timeouts.total > 0 {
+		conn.SetWriteDeadline(startTime.Add(s.timeouts.total))
+	}
 
-	fmt.Printf("New connection from: %s\n", remoteAddr)
+	profile := l.profile
+	if err := profile.socket.ApplyConn(conn); err != nil {
+		events.Warn("failed to apply socket profile", "err", err)
+	}
+	socketOptions, _ := common.ReadSocketProfile(conn)
+
+	tcpCollector := common.NewTCPInfoCollector()
+	tcpCollector.CollectSample(conn)
+	stopSampling := func() {}
+	if s.interval > 0 {
+		stopSampling = tcpCollector.StartSampling(conn, s.interval, nil)
+	}
+	defer stopSampling()
 
-	var totalBytesSent, totalBytesReceived int64
+	var totalBytesSent int64
 	var lastOperation string = "CONNECT"
+	var fsyncCount int
+	var fsyncTime time.Duration
+	var cpuUser, cpuSystem time.Duration
+	var pacingWait time.Duration // Held back by download pacing
+	var timed *timedUpload       // Latest timed upload (SEND and DATA frames)
+	var checks verification      // Uploads verified against a synthetic payload
+	var closeReason string
+	settings := &connSettings{throttle: profile.throttle, zeroCopy: s.zeroCopy, pacing: s.pacing}
+	in := &connReader{conn: conn, timeouts: s.timeouts, start: startTime, metrics: s.metrics}
+
+	// In relay mode LIST and PUT go to the upstream server instead of local storage
+	var upstream *relay
+	fsyncPolicy := profile.store.policy()
+	if profile.upstream != "" {
+		upstream = newRelay(profile.upstream, profile.upstreamCC, profile.socket, s.interval, events)
+		fsyncPolicy = ""
+	}
+
+	active := &activeConn{id: connID, remote: remoteAddr, start: startTime, conn: conn, in: in, collector: tcpCollector}
+	active.op.Store(lastOperation)
+	s.conns.add(active)
+	defer s.conns.remove(connID)
 
 	for {
-		// Read frame from client
-		frame, err := protocol.ReadFrame(conn)
+		// Read frame header from client; PUT payloads are streamed to storage
+		in.op = ""
+		frame, err := protocol.ReadFrameHeader(in)
 		if err != nil {
-			fmt.Printf("Connection %s closed: %v\n", remoteAddr, err)
+			closeReason = s.timeouts.closeReason(err, startTime)
+			events.Info("connection closed", "op", lastOperation, "reason", closeReason, "err", err)
 			break
 		}
 
-		totalBytesReceived += int64(5 + len(frame.Payload))
+		opStart := time.Now()
+		lastOperation = opName(frame.OpCode)
+		in.op = lastOperation
+		active.op.Store(lastOperation)
+		s.metrics.received(lastOperation, 5)
+
+		if frame.OpCode != protocol.OpPut && frame.OpCode != protocol.OpData {
+			if err := protocol.ReadFramePayload(in, frame); err != nil {
+				closeReason = s.timeouts.closeReason(err, startTime)
+				events.Info("connection closed", "op", lastOperation, "reason", closeReason, "err", err)
+				break
+			}
+		}
 
 		var response *protocol.Frame
+		streamed := false // The handler already sent the response
+		pending := false  // No response is due yet
 
 		switch frame.OpCode {
 		case protocol.OpList:
-			lastOperation = "LIST"
-			response = handleListRequest(fileDir)
+			if upstream != nil {
+				response = upstream.list(settings.namespace)
+			} else {
+				response = handleListRequest(profile.store, settings.namespace)
+			}
 		case protocol.OpPut:
-			lastOperation = "PUT"
-			response = handlePutRequest(frame, fileDir)
+			var result *putResult
+			cpu := common.StartCPUTimer()
+			var verifier *common.Verifier
+			if upstream != nil {
+				response, err = upstream.put(in, frame, settings)
+			} else if settings.payload != nil {
+				response, verifier, err = handleVerifiedPut(in, frame, settings, events)
+			} else {
+				response, result, err = handlePutRequest(in, frame, profile.store, settings, events)
+			}
+			user, system := cpu.Stop()
+			cpuUser += user
+			cpuSystem += system
+			if verifier != nil {
+				checks.add(verifier)
+			}
+			if err != nil {
+				s.metrics.operation(lastOperation, time.Since(opStart), true)
+				closeReason = s.timeouts.closeReason(err, startTime)
+				events.Info("connection closed", "op", lastOperation, "reason", closeReason, "err", err)
+				break
+			}
+			if result != nil {
+				fsyncCount += result.FsyncCount
+				fsyncTime += result.FsyncTime
+			}
+		case protocol.OpGet:
+			if upstream != nil {
+				response = protocol.CreateErrorFrame("GET is not supported in relay mode")
+				break
+			}
+			var sent int64
+			var waited time.Duration
+			cpu := common.StartCPUTimer()
+			response, sent, waited, err = handleGetRequest(conn, frame, profile.store, settings, events)
+			user, system := cpu.Stop()
+			pacingWait += waited
+			cpuUser += user
+			cpuSystem += system
+			totalBytesSent += sent
+			active.sent.Store(totalBytesSent)
+			if err != nil {
+				s.metrics.operation(lastOperation, time.Since(opStart), true)
+				closeReason = s.timeouts.closeReason(err, startTime)
+				events.Info("connection closed", "op", lastOperation, "reason", closeReason, "err", err)
+				break
+			}
+			streamed = response == nil
+		case protocol.OpSend:
+			if upstream != nil {
+				response = protocol.CreateErrorFrame("SEND is not supported in relay mode")
+				break
+			}
+			timed, response = handleSendRequest(in, frame, settings, events)
+			pending = response == nil
+		case protocol.OpData:
+			if timed == nil || !timed.end.IsZero() {
+				// DATA frames without a SEND form an upload of their own, like the
+				// bursts of the client's idle experiment
+				timed = newTimedUpload(common.SteadyWindow{}, in, settings)
+			}
+			response, err = timed.data(frame, events)
+			if err != nil {
+				s.metrics.operation(lastOperation, time.Since(opStart), true)
+				closeReason = s.timeouts.closeReason(err, startTime)
+				events.Info("connection closed", "op", lastOperation, "reason", closeReason, "err", err)
+				break
+			}
+			if response != nil && timed.verifier != nil {
+				checks.add(timed.verifier)
+			}
+			pending = response == nil
+		case protocol.OpPing:
+			// Answered locally in relay mode as well: PING measures this hop
+			response = &protocol.Frame{OpCode: protocol.OpPing, PayloadLen: frame.PayloadLen, Payload: frame.Payload}
+		case protocol.OpOption:
+			response = handleOptionRequest(conn, frame, settings, upstream != nil, events)
 		case protocol.OpQuit:
-			lastOperation = "QUIT"
 			response = &protocol.Frame{OpCode: protocol.OpQuit, PayloadLen: 0}
-			fmt.Printf("Client %s requested quit\n", remoteAddr)
+			events.Info("client requested quit")
 		default:
-			lastOperation = "UNKNOWN"
 			response = protocol.CreateErrorFrame("Unknown operation")
 		}
+		if pending {
+			continue
+		}
+		if streamed {
+			s.metrics.operation(lastOperation, time.Since(opStart), false)
+			continue
+		}
+		if response == nil {
+			break
+		}
 
 		// Send response
-		if err := protocol.WriteFrame(conn, response); err != nil {
-			fmt.Printf("Failed to send response to %s: %v\n", remoteAddr, err)
+		err = protocol.WriteFrame(conn, response)
+		events.Debug("operation handled", "op", lastOperation, "duration", time.Since(opStart), "error", response.OpCode == protocol.OpError)
+		s.metrics.operation(lastOperation, time.Since(opStart), err != nil || response.OpCode == protocol.OpError)
+		if err != nil {
+			closeReason = s.timeouts.closeReason(err, startTime)
+			events.Error("failed to send response", "op", lastOperation, "err", err)
 			break
 		}
 
 		totalBytesSent += int64(5 + len(response.Payload))
+		active.sent.Store(totalBytesSent)
 
 		// If client sent QUIT, close connection
 		if frame.OpCode == protocol.OpQuit {
+			closeReason = closeQuit
 			break
 		}
 	}
 
+	if active.aborted.Load() {
+		closeReason = closeAborted
+	}
+
+	var relayHop *common.RelayHop
+	if upstream != nil {
+		relayHop = upstream.close()
+	}
+
+	stopSampling()
+	tcpCollector.CollectSample(conn)
+	if latest := tcpCollector.Latest(); latest != nil {
+		s.metrics.connTCPInfo(*latest)
+	}
 	endTime := time.Now()
+	congestionControl, _ := common.GetCongestionControl(conn)
+
+	// Paced downloads and throttled or verified uploads go through user space
+	zeroCopy := settings.zeroCopy && upstream == nil
+	if lastOperation == "GET" {
+		zeroCopy = zeroCopy && !settings.pacing.Enabled()
+	} else {
+		zeroCopy = zeroCopy && !settings.throttle.enabled() && settings.payload == nil
+	}
 
 	// Log connection
 	log := &common.ConnectionLog{
-		StartTime:     startTime,
-		EndTime:       endTime,
-		BytesSent:     totalBytesSent,
-		BytesReceived: totalBytesReceived,
-		RemoteAddr:    remoteAddr,
-		Operation:     lastOperation,
-	}
-	logger.LogConnection(log)
-	logger.PrintSummary(log)
+		ConnID:            connID,
+		StartTime:         startTime,
+		EndTime:           endTime,
+		BytesSent:         totalBytesSent,
+		BytesReceived:     in.bytes.Load(),
+		RemoteAddr:        remoteAddr,
+		Operation:         lastOperation,
+		CloseReason:       closeReason,
+		Listener:          l.name,
+		ListenerProfile:   profile.name,
+		CongestionControl: congestionControl,
+		SocketProfile:     profile.socket.Name,
+		SocketOptions:     socketOptions,
+		Namespace:         settings.namespace,
+		ReadThrottle:      settings.throttle.String(),
+		ZeroCopy:          zeroCopy,
+		CPUUserMs:         float64(cpuUser) / float64(time.Millisecond),
+		CPUSystemMs:       float64(cpuSystem) / float64(time.Millisecond),
+		FsyncPolicy:       fsyncPolicy,
+		FsyncCount:        fsyncCount,
+		FsyncTimeMs:       float64(fsyncTime) / float64(time.Millisecond),
+		Relay:             relayHop,
+		TCPSamples:        tcpCollector.GetSamples(),
+	}
+	if timed != nil {
+		timed.steadyState(log, log.TCPSamples)
+	}
+	if settings.pacing.Enabled() {
+		log.Pacing = settings.pacing.String()
+		log.PacingWaitMs = float64(pacingWait) / float64(time.Millisecond)
+	}
+	checks.fill(log, settings.payload)
+	s.logger.LogConnection(log)
+	s.logger.PrintSummary(log)
 }
 
-func handleListRequest(fileDir string) *protocol.Frame {
-	files, err := ioutil.ReadDir(fileDir)
+// rejectConnection answers a connection refused by admission control with an
+// error frame and logs it with the rejection reason
+func (s *server) rejectConnection(conn net.Conn, l *listener, events *slog.Logger, connID string, startTime time.Time, reason string) {
+	events.Warn("connection rejected", "reason", reason)
+	s.metrics.connRejected(reason)
+
+	response := protocol.CreateErrorFrame(fmt.Sprintf("Server busy: %s", reason))
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	var bytesSent int64
+	if err := protocol.WriteFrame(conn, response); err == nil {
+		bytesSent = int64(5 + len(response.Payload))
+	}
+
+	s.logger.LogConnection(&common.ConnectionLog{
+		ConnID:          connID,
+		StartTime:       startTime,
+		EndTime:         time.Now(),
+		BytesSent:       bytesSent,
+		RemoteAddr:      conn.RemoteAddr().String(),
+		Operation:       "REJECTED",
+		CloseReason:     reason,
+		Listener:        l.name,
+		ListenerProfile: l.profile.name,
+	})
+}
+
+func handleListRequest(store backend, namespace string) *protocol.Frame {
+	files, err := store.list(namespace)
 	if err != nil {
 		return protocol.CreateErrorFrame(fmt.Sprintf("Failed to list files: %v", err))
 	}
 
 	var fileList []string
 	for _, file := range files {
-		if !file.IsDir() {
-			fileList = append(fileList, fmt.Sprintf("%s (%d bytes)", file.Name(), file.Size()))
-		}
+		fileList = append(fileList, fmt.Sprintf("%s (%d bytes)", file.Name(), file.Size()))
 	}
 
 	if len(fileList) == 0 {
@@ -165,32 +633,153 @@ func handleListRequest(fileDir string) *protocol.Frame {
 	}
 }
 
-func handlePutRequest(frame *protocol.Frame, fileDir string) *protocol.Frame {
-	filename, fileData, err := protocol.ParsePutFrame(frame)
+// handleOptionRequest applies a connection option requested by the client
+func handleOptionRequest(conn net.Conn, frame *protocol.Frame, settings *connSettings, relayed bool, events *slog.Logger) *protocol.Frame {
+	key, value, err := protocol.ParseOptionFrame(frame)
 	if err != nil {
-		return protocol.CreateErrorFrame(fmt.Sprintf("Invalid PUT request: %v", err))
+		return protocol.CreateErrorFrame(fmt.Sprintf("Invalid OPTION request: %v", err))
 	}
 
-	// Clean filename to prevent directory traversal
-	filename = filepath.Base(filename)
-	filePath := filepath.Join(fileDir, filename)
+	switch key {
+	case protocol.OptCongestionControl:
+		if err := common.SetCongestionControl(conn, value); err != nil {
+			return protocol.CreateErrorFrame(err.Error())
+		}
+		effective, err := common.GetCongestionControl(conn)
+		if err != nil {
+			return protocol.CreateErrorFrame(fmt.Sprintf("Failed to read congestion control: %v", err))
+		}
+		events.Info("option set", "op", "OPTION", "key", key, "value", effective)
+		return protocol.CreateOptionFrame(key, effective)
+	case protocol.OptReadThrottle:
+		t, err := parseReadThrottle(value)
+		if err != nil {
+			return protocol.CreateErrorFrame(err.Error())
+		}
+		settings.throttle = t
+		events.Info("option set", "op", "OPTION", "key", key, "value", t.String())
+		return protocol.CreateOptionFrame(key, t.String())
+	case protocol.OptNamespace:
+		if err := validateNamespace(value); err != nil {
+			return protocol.CreateErrorFrame(err.Error())
+		}
+		settings.namespace = value
+		events.Info("option set", "op", "OPTION", "key", key, "value", value)
+		return protocol.CreateOptionFrame(key, value)
+	case protocol.OptPacing:
+		p, err := common.ParsePacing(value)
+		if err != nil {
+			return protocol.CreateErrorFrame(err.Error())
+		}
+		settings.pacing = p
+		events.Info("option set", "op", "OPTION", "key", key, "value", p.String())
+		return protocol.CreateOptionFrame(key, p.String())
+	case protocol.OptPayload:
+		if relayed {
+			return protocol.CreateErrorFrame("Payload verification is not supported in relay mode")
+		}
+		if value == "" {
+			settings.payload = nil
+			events.Info("option set", "op", "OPTION", "key", key, "value", "")
+			return protocol.CreateOptionFrame(key, "")
+		}
+		p, err := common.ParsePayload(value)
+		if err != nil {
+			return protocol.CreateErrorFrame(err.Error())
+		}
+		settings.payload = p
+		events.Info("option set", "op", "OPTION", "key", key, "value", p.String())
+		return protocol.CreateOptionFrame(key, p.String())
+	default:
+		return protocol.CreateErrorFrame(fmt.Sprintf("Unknown option %q", key))
+	}
+}
 
-	// Check if file already exists
-	if _, err := os.Stat(filePath); err == nil {
-		return protocol.CreateErrorFrame(fmt.Sprintf("File %s already exists", filename))
+// handlePutRequest streams the PUT payload into storage. A non-nil error means the
+// connection can no longer be used because the payload was not fully consumed.
+func handlePutRequest(in *connReader, frame *protocol.Frame, store backend, settings *connSettings, events *slog.Logger) (*protocol.Frame, *putResult, error) {
+	filename, size, err := protocol.ReadPutHeader(in, frame)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PUT request: %w", err)
 	}
 
-	// Write file
-	if err := ioutil.WriteFile(filePath, fileData, 0644); err != nil {
-		return protocol.CreateErrorFrame(fmt.Sprintf("Failed to save file: %v", err))
+	var data io.Reader = io.LimitReader(settings.throttle.wrap(in), size)
+	accounted := func() {}
+	if settings.zeroCopy && !settings.throttle.enabled() {
+		data, accounted = in.direct(size)
+	}
+	defer accounted()
+	result, err := store.put(settings.namespace, filename, data, size)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// Draining would re-arm the idle deadline and hold a stalled uploader's
+		// connection for another timeout
+		return nil, nil, fmt.Errorf("failed to read PUT payload: %w", err)
 	}
 
-	fmt.Printf("File saved: %s (%d bytes)\n", filename, len(fileData))
+	// Drain whatever the failed upload left unread so the next frame stays aligned
+	if _, drainErr := io.Copy(io.Discard, data); drainErr != nil {
+		return nil, nil, fmt.Errorf("failed to read PUT payload: %w", drainErr)
+	}
+	if err != nil {
+		return protocol.CreateErrorFrame(fmt.Sprintf("Failed to save file: %v", err)), nil, nil
+	}
+
+	events.Info("file saved", "op", "PUT", "file", result.Filename, "bytes", result.Bytes,
+		"fsyncs", result.FsyncCount, "fsync_time", result.FsyncTime)
 
-	response := fmt.Sprintf("File %s uploaded successfully (%d bytes)", filename, len(fileData))
+	response := fmt.Sprintf("File %s uploaded successfully (%d bytes)", result.Filename, result.Bytes)
 	return &protocol.Frame{
 		OpCode:     protocol.OpPut,
 		PayloadLen: uint32(len(response)),
 		Payload:    []byte(response),
+	}, result, nil
+}
+
+// handleGetRequest streams a stored file to the client in a GET frame, paced if
+// the connection has a download rate limit. It returns an error frame when the
+// file cannot be served, or nil, the bytes sent and the time pacing held them back
+// once the file went out. A non-nil error means the connection can no longer be used.
+func handleGetRequest(conn net.Conn, frame *protocol.Frame, store backend, settings *connSettings, events *slog.Logger) (*protocol.Frame, int64, time.Duration, error) {
+	filename := string(frame.Payload)
+	f, err := store.get(settings.namespace, filename)
+	if err != nil {
+		return protocol.CreateErrorFrame(fmt.Sprintf("Failed to read file: %v", err)), 0, 0, nil
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return protocol.CreateErrorFrame(fmt.Sprintf("Failed to read file: %v", err)), 0, 0, nil
+	}
+	size := info.Size()
+	if size > 0xFFFFFFFF {
+		return protocol.CreateErrorFrame(fmt.Sprintf("File %s is too large to send (%d bytes)", filename, size)), 0, 0, nil
 	}
+
+	if err := protocol.WriteFrameHeader(conn, protocol.OpGet, size); err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Copying from the file straight to the socket uses sendfile(2); hiding the
+	// socket's ReadFrom forces the copy through a user-space buffer
+	var dst io.Writer = struct{ io.Writer }{conn}
+	var pacer *common.PacedWriter
+	switch {
+	case settings.pacing.Enabled():
+		pacer = settings.pacing.Writer(conn)
+		dst = pacer
+	case settings.zeroCopy:
+		dst = conn
+	}
+	sent, err := io.CopyN(dst, f, size)
+	var waited time.Duration
+	if pacer != nil {
+		waited = pacer.Waited()
+	}
+	if err != nil {
+		return nil, 5 + sent, waited, fmt.Errorf("failed to send file: %w", err)
+	}
+
+	events.Info("file sent", "op", "GET", "file", info.Name(), "bytes", size)
+	return nil, 5 + sent, waited, nil
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+// Histogram bucket upper bounds
+var (
+	durationBuckets = []float64{0.001, 0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+	rttBuckets      = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	cwndBuckets     = []float64{1, 2, 4, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+)
+
+// histogram is a cumulative Prometheus histogram
+type histogram struct {
+	bounds []float64
+	counts []uint64 // One per bound, plus +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// write emits the histogram series; labels is either empty or a rendered label list
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, bound, cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)]
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, cumulative)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// metrics collects server-wide counters exposed in the Prometheus text format
+type metrics struct {
+	mu                sync.Mutex
+	activeConns       int64
+	acceptedConns     map[string]uint64 // By listener
+	rejectedConns     map[string]uint64 // By reason
+	bytesReceived     map[string]uint64 // By operation
+	operations        map[string]uint64
+	operationErrors   map[string]uint64
+	transferDurations map[string]*histogram
+	rtt               *histogram
+	cwnd              *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		acceptedConns:     make(map[string]uint64),
+		rejectedConns:     make(map[string]uint64),
+		bytesReceived:     make(map[string]uint64),
+		operations:        make(map[string]uint64),
+		operationErrors:   make(map[string]uint64),
+		transferDurations: make(map[string]*histogram),
+		rtt:               newHistogram(rttBuckets),
+		cwnd:              newHistogram(cwndBuckets),
+	}
+}
+
+// connOpened and connClosed track the number of admitted connections
+func (m *metrics) connOpened() {
+	m.mu.Lock()
+	m.activeConns++
+	m.mu.Unlock()
+}
+
+func (m *metrics) connClosed() {
+	m.mu.Lock()
+	m.activeConns--
+	m.mu.Unlock()
+}
+
+func (m *metrics) accepted(listener string) {
+	m.mu.Lock()
+	m.acceptedConns[listener]++
+	m.mu.Unlock()
+}
+
+func (m *metrics) connRejected(reason string) {
+	m.mu.Lock()
+	m.rejectedConns[reason]++
+	m.mu.Unlock()
+}
+
+// received accounts bytes to an operation as they arrive
+func (m *metrics) received(op string, n int64) {
+	m.mu.Lock()
+	m.bytesReceived[op] += uint64(n)
+	m.mu.Unlock()
+}
+
+// operation records one completed operation
+func (m *metrics) operation(op string, duration time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operations[op]++
+	if failed {
+		m.operationErrors[op]++
+	}
+	h := m.transferDurations[op]
+	if h == nil {
+		h = newHistogram(durationBuckets)
+		m.transferDurations[op] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// connTCPInfo records the final TCP_INFO sample of a closed connection, so every
+// connection counts once however long it lived
+func (m *metrics) connTCPInfo(info common.TCPInfo) {
+	m.mu.Lock()
+	m.rtt.observe(float64(info.RTT) / 1e6)
+	m.cwnd.observe(float64(info.SndCwnd))
+	m.mu.Unlock()
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP tcpbench_active_connections Connections currently being served.\n")
+	fmt.Fprintf(w, "# TYPE tcpbench_active_connections gauge\n")
+	fmt.Fprintf(w, "tcpbench_active_connections %d\n", m.activeConns)
+
+	writeCounterVec(w, "tcpbench_listener_connections_total", "Connections accepted, by listener.", "listener", m.acceptedConns)
+	writeCounterVec(w, "tcpbench_rejected_connections_total", "Connections refused by admission control.", "reason", m.rejectedConns)
+	writeCounterVec(w, "tcpbench_received_bytes_total", "Bytes received from clients, by operation.", "op", m.bytesReceived)
+	writeCounterVec(w, "tcpbench_operations_total", "Operations handled, by operation.", "op", m.operations)
+	writeCounterVec(w, "tcpbench_operation_errors_total", "Operations that failed, by operation.", "op", m.operationErrors)
+
+	fmt.Fprintf(w, "# HELP tcpbench_transfer_duration_seconds Time to handle an operation, from frame header to response.\n")
+	fmt.Fprintf(w, "# TYPE tcpbench_transfer_duration_seconds histogram\n")
+	for _, op := range sortedKeys(m.transferDurations) {
+		m.transferDurations[op].write(w, "tcpbench_transfer_duration_seconds", fmt.Sprintf("op=\"%s\"", escapeLabel(op)))
+	}
+
+	fmt.Fprintf(w, "# HELP tcpbench_rtt_seconds Smoothed RTT of each connection when it closed.\n")
+	fmt.Fprintf(w, "# TYPE tcpbench_rtt_seconds histogram\n")
+	m.rtt.write(w, "tcpbench_rtt_seconds", "")
+
+	fmt.Fprintf(w, "# HELP tcpbench_cwnd_segments Congestion window of each connection when it closed.\n")
+	fmt.Fprintf(w, "# TYPE tcpbench_cwnd_segments histogram\n")
+	m.cwnd.write(w, "tcpbench_cwnd_segments", "")
+}
+
+func writeCounterVec(w io.Writer, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(key), values[key])
+	}
+}
+
+// labelEscaper escapes label values as the Prometheus text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
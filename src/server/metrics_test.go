@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	for _, v := range []float64{0.5, 1, 5, 10, 50} {
+		h.observe(v)
+	}
+	var b bytes.Buffer
+	h.write(&b, "x", `op="PUT"`)
+	want := `x_bucket{op="PUT",le="1"} 2
+x_bucket{op="PUT",le="10"} 4
+x_bucket{op="PUT",le="+Inf"} 5
+x_sum{op="PUT"} 66.5
+x_count{op="PUT"} 5
+`
+	if b.String() != want {
+		t.Errorf("write() =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	newHistogram([]float64{1}).write(&b, "y", "")
+	want = `y_bucket{le="1"} 0
+y_bucket{le="+Inf"} 0
+y_sum 0
+y_count 0
+`
+	if b.String() != want {
+		t.Errorf("write() of an empty histogram =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := newMetrics()
+	m.connOpened()
+	m.connOpened()
+	m.connClosed()
+	m.connRejected("connection limit reached (1)")
+	m.received("PUT", 1000)
+	m.received("PUT", 24)
+	m.operation("PUT", 2*time.Second, false)
+	m.operation("GET", 5*time.Millisecond, true)
+	m.connTCPInfo(common.TCPInfo{RTT: 20000, SndCwnd: 10})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, line := range []string{
+		"tcpbench_active_connections 1",
+		`tcpbench_rejected_connections_total{reason="connection limit reached (1)"} 1`,
+		`tcpbench_received_bytes_total{op="PUT"} 1024`,
+		`tcpbench_operations_total{op="GET"} 1`,
+		`tcpbench_operations_total{op="PUT"} 1`,
+		`tcpbench_operation_errors_total{op="GET"} 1`,
+		`tcpbench_transfer_duration_seconds_bucket{op="PUT",le="2.5"} 1`,
+		`tcpbench_transfer_duration_seconds_bucket{op="PUT",le="1"} 0`,
+		`tcpbench_transfer_duration_seconds_count{op="GET"} 1`,
+		`tcpbench_rtt_seconds_bucket{le="0.025"} 1`,
+		`tcpbench_rtt_seconds_bucket{le="0.01"} 0`,
+		`tcpbench_cwnd_segments_bucket{le="10"} 1`,
+		"tcpbench_cwnd_segments_count 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+	if strings.Contains(body, `tcpbench_operation_errors_total{op="PUT"}`) {
+		t.Errorf("metrics report PUT errors")
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got, want := escapeLabel("a\"b\\c\nd"), `a\"b\\c\nd`; got != want {
+		t.Errorf("escapeLabel() = %q, want %q", got, want)
+	}
+}
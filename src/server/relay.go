@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+// relayBufferSize is the chunk size uploads are forwarded in
+const relayBufferSize = 64 * 1024
+
+// relayTimeout bounds every read and write on the upstream connection, so a
+// stuck upstream cannot hold the downstream connection forever
+const relayTimeout = 60 * time.Second
+
+// relay forwards the operations of one downstream connection to an upstream
+// server over a connection of its own, dialed on first use
+type relay struct {
+	address  string
+	cc       string // Congestion control of the upstream socket, empty for the profile's
+	profile  *common.SocketProfile
+	interval time.Duration
+	events   *slog.Logger
+
+	conn         net.Conn
+	collector    *common.TCPInfoCollector
+	stopSampling func()
+	namespace    string // Namespace selected on the upstream connection
+	broken       error  // Set once the upstream connection is out of sync
+
+	start         time.Time
+	bytesSent     int64
+	bytesReceived int64
+	readWait      time.Duration
+	writeWait     time.Duration
+}
+
+func newRelay(address, cc string, profile *common.SocketProfile, interval time.Duration, events *slog.Logger) *relay {
+	return &relay{
+		address:      address,
+		cc:           cc,
+		profile:      profile,
+		interval:     interval,
+		events:       events.With("upstream", address),
+		stopSampling: func() {},
+	}
+}
+
+// connect dials the upstream server unless already connected
+func (r *relay) connect() error {
+	if r.broken != nil {
+		return r.broken
+	}
+	if r.conn != nil {
+		return nil
+	}
+
+	dialer := net.Dialer{Control: r.profile.Control, Timeout: 10 * time.Second}
+	conn, err := dialer.Dial("tcp", r.address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to upstream %s: %v", r.address, err)
+	}
+	if err := r.profile.ApplyConn(conn); err != nil {
+		r.events.Warn("failed to apply socket profile upstream", "err", err)
+	}
+	if r.cc != "" {
+		if err := common.SetCongestionControl(conn, r.cc); err != nil {
+			conn.Close()
+			return fmt.Errorf("upstream %s: %v", r.address, err)
+		}
+	}
+
+	r.conn = conn
+	r.events.Info("upstream connected")
+	r.start = time.Now()
+	r.collector = common.NewTCPInfoCollector()
+	r.collector.CollectSample(conn)
+	if r.interval > 0 {
+		r.stopSampling = r.collector.StartSampling(conn, r.interval, nil)
+	}
+	return nil
+}
+
+// roundTrip sends a request upstream and returns the response
+func (r *relay) roundTrip(request *protocol.Frame) (*protocol.Frame, error) {
+	r.conn.SetWriteDeadline(time.Now().Add(relayTimeout))
+	if err := protocol.WriteFrame(r.conn, request); err != nil {
+		return nil, r.fail(err)
+	}
+	r.bytesSent += int64(5 + len(request.Payload))
+	return r.readResponse()
+}
+
+func (r *relay) readResponse() (*protocol.Frame, error) {
+	r.conn.SetReadDeadline(time.Now().Add(relayTimeout))
+	response, err := protocol.ReadFrame(r.conn)
+	if err != nil {
+		return nil, r.fail(err)
+	}
+	r.bytesReceived += int64(5 + len(response.Payload))
+	return response, nil
+}
+
+// fail marks the upstream connection unusable
+func (r *relay) fail(err error) error {
+	r.broken = fmt.Errorf("upstream %s failed: %v", r.address, err)
+	return r.broken
+}
+
+// selectNamespace switches the upstream connection to the downstream namespace
+func (r *relay) selectNamespace(ns string) error {
+	if ns == r.namespace {
+		return nil
+	}
+	response, err := r.roundTrip(protocol.CreateOptionFrame(protocol.OptNamespace, ns))
+	if err != nil {
+		return err
+	}
+	if response.OpCode == protocol.OpError {
+		return fmt.Errorf("upstream rejected namespace: %s", response.Payload)
+	}
+	r.namespace = ns
+	return nil
+}
+
+// list forwards a LIST request
+func (r *relay) list(ns string) *protocol.Frame {
+	if err := r.connect(); err != nil {
+		return protocol.CreateErrorFrame(err.Error())
+	}
+	if err := r.selectNamespace(ns); err != nil {
+		return protocol.CreateErrorFrame(err.Error())
+	}
+	response, err := r.roundTrip(protocol.CreateListFrame())
+	if err != nil {
+		return protocol.CreateErrorFrame(err.Error())
+	}
+	return response
+}
+
+// put streams a PUT payload from downstream to upstream and returns the upstream
+// response. Like handlePutRequest, a non-nil error means the downstream
+// connection can no longer be used.
+func (r *relay) put(body io.Reader, frame *protocol.Frame, settings *connSettings) (*protocol.Frame, error) {
+	filename, size, err := protocol.ReadPutHeader(body, frame)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PUT request: %w", err)
+	}
+	data := io.LimitReader(settings.throttle.wrap(body), size)
+
+	upstreamErr := r.connect()
+	if upstreamErr == nil {
+		upstreamErr = r.selectNamespace(settings.namespace)
+	}
+	if upstreamErr == nil {
+		r.conn.SetWriteDeadline(time.Now().Add(relayTimeout))
+		if err := protocol.WritePutHeader(r.conn, filename, size); err != nil {
+			upstreamErr = r.fail(err)
+		} else {
+			r.bytesSent += int64(9 + len(filename))
+			upstreamErr = r.forward(data, size)
+		}
+	}
+
+	// Drain whatever was not forwarded so the next frame stays aligned
+	if _, drainErr := io.Copy(io.Discard, data); drainErr != nil {
+		return nil, fmt.Errorf("failed to read PUT payload: %w", drainErr)
+	}
+	if upstreamErr != nil {
+		var readErr *downstreamError
+		if errors.As(upstreamErr, &readErr) {
+			return nil, fmt.Errorf("failed to read PUT payload: %w", readErr.err)
+		}
+		return protocol.CreateErrorFrame(fmt.Sprintf("Failed to relay file: %v", upstreamErr)), nil
+	}
+
+	response, err := r.readResponse()
+	if err != nil {
+		return protocol.CreateErrorFrame(err.Error()), nil
+	}
+	r.events.Info("file relayed", "op", "PUT", "file", filename, "bytes", size)
+	return response, nil
+}
+
+// downstreamError wraps a read error from the downstream connection
+type downstreamError struct{ err error }
+
+func (e *downstreamError) Error() string { return e.err.Error() }
+
+// forward copies size bytes of data upstream, timing how long the relay waits on
+// each side. Data ending early is a downstream error: the upstream PUT is left
+// incomplete, so the upstream connection cannot be reused either.
+func (r *relay) forward(data io.Reader, size int64) error {
+	buf := make([]byte, relayBufferSize)
+	var forwarded int64
+	for {
+		readStart := time.Now()
+		n, err := data.Read(buf)
+		r.readWait += time.Since(readStart)
+
+		if n > 0 {
+			writeStart := time.Now()
+			r.conn.SetWriteDeadline(writeStart.Add(relayTimeout))
+			_, writeErr := r.conn.Write(buf[:n])
+			r.writeWait += time.Since(writeStart)
+			if writeErr != nil {
+				// The upstream PUT is incomplete, so the connection cannot be reused
+				return r.fail(writeErr)
+			}
+			r.bytesSent += int64(n)
+			forwarded += int64(n)
+		}
+		if err == io.EOF {
+			if forwarded < size {
+				r.fail(io.ErrUnexpectedEOF)
+				return &downstreamError{io.ErrUnexpectedEOF}
+			}
+			return nil
+		}
+		if err != nil {
+			r.fail(err)
+			return &downstreamError{err}
+		}
+	}
+}
+
+// close ends the upstream connection and returns its hop for the connection log,
+// or nil if the relay never connected
+func (r *relay) close() *common.RelayHop {
+	if r.conn == nil {
+		return nil
+	}
+	if r.broken == nil {
+		r.roundTrip(protocol.CreateQuitFrame())
+	}
+
+	r.stopSampling()
+	r.collector.CollectSample(r.conn)
+	congestionControl, _ := common.GetCongestionControl(r.conn)
+	r.conn.Close()
+
+	return &common.RelayHop{
+		Upstream:          r.address,
+		StartTime:         r.start,
+		EndTime:           time.Now(),
+		BytesSent:         r.bytesSent,
+		BytesReceived:     r.bytesReceived,
+		CongestionControl: congestionControl,
+		ReadWaitMs:        float64(r.readWait) / float64(time.Millisecond),
+		WriteWaitMs:       float64(r.writeWait) / float64(time.Millisecond),
+		Bottleneck:        bottleneck(r.readWait, r.writeWait),
+		TCPSamples:        r.collector.GetSamples(),
+	}
+}
+
+// bottleneck decides which hop held the transfer back. A relay that mostly waits
+// to read is starved by the downstream hop; one that mostly waits to write is
+// held back by the upstream hop. Within 20% of each other neither dominates.
+func bottleneck(readWait, writeWait time.Duration) string {
+	switch {
+	case readWait > writeWait*12/10:
+		return common.BottleneckDownstream
+	case writeWait > readWait*12/10:
+		return common.BottleneckUpstream
+	default:
+		return common.BottleneckBalanced
+	}
+}
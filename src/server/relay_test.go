@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+)
+
+func TestBottleneck(t *testing.T) {
+	tests := []struct {
+		readWait, writeWait time.Duration
+		want                string
+	}{
+		{0, 0, common.BottleneckBalanced},
+		{time.Second, time.Second, common.BottleneckBalanced},
+		{1200 * time.Millisecond, time.Second, common.BottleneckBalanced},
+		{1201 * time.Millisecond, time.Second, common.BottleneckDownstream},
+		{time.Second, 1200 * time.Millisecond, common.BottleneckBalanced},
+		{time.Second, 1201 * time.Millisecond, common.BottleneckUpstream},
+		{time.Millisecond, 0, common.BottleneckDownstream},
+		{0, time.Millisecond, common.BottleneckUpstream},
+	}
+	for _, tt := range tests {
+		if got := bottleneck(tt.readWait, tt.writeWait); got != tt.want {
+			t.Errorf("bottleneck(%v, %v) = %q, want %q", tt.readWait, tt.writeWait, got, tt.want)
+		}
+	}
+}
+
+func TestRelayForward(t *testing.T) {
+	tests := []struct {
+		data       string
+		size       int64
+		downstream bool // Whether the upload fails on the downstream side
+	}{
+		{data: "hello world", size: 11},
+		{data: "hello", size: 11, downstream: true},
+	}
+	for _, tt := range tests {
+		conn, upstream := net.Pipe()
+		received := make(chan string)
+		go func() {
+			b, _ := io.ReadAll(upstream)
+			received <- string(b)
+		}()
+
+		r := newRelay("upstream:9000", "", &common.SocketProfile{}, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		r.conn = conn
+		err := r.forward(strings.NewReader(tt.data), tt.size)
+		conn.Close()
+		if got := <-received; got != tt.data {
+			t.Errorf("forward(%q, %d) sent %q upstream", tt.data, tt.size, got)
+		}
+
+		var downstreamErr *downstreamError
+		if tt.downstream {
+			if !errors.As(err, &downstreamErr) || r.broken == nil {
+				t.Errorf("forward(%q, %d) = %v, broken %v, want a downstream error and a broken upstream", tt.data, tt.size, err, r.broken)
+			}
+			continue
+		}
+		if err != nil || r.bytesSent != tt.size {
+			t.Errorf("forward(%q, %d) = %v after %d bytes, want success", tt.data, tt.size, err, r.bytesSent)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fsync modes
+const (
+	fsyncNone    = "none"
+	fsyncOnClose = "on-close"
+	fsyncEveryN  = "every-n"
+)
+
+// tempMarker is part of the name of every in-progress upload
+const tempMarker = ".tmp-"
+
+// fsyncPolicy controls when uploaded data is flushed to stable storage
+type fsyncPolicy struct {
+	Mode  string
+	Bytes int64 // Flush interval for fsyncEveryN
+}
+
+// parseFsyncPolicy validates the fsync mode and interval flags
+func parseFsyncPolicy(mode string, bytes int64) (fsyncPolicy, error) {
+	switch mode {
+	case fsyncNone, fsyncOnClose:
+		return fsyncPolicy{Mode: mode}, nil
+	case fsyncEveryN:
+		if bytes <= 0 {
+			return fsyncPolicy{}, fmt.Errorf("fsync interval must be positive, got %d", bytes)
+		}
+		return fsyncPolicy{Mode: mode, Bytes: bytes}, nil
+	default:
+		return fsyncPolicy{}, fmt.Errorf("unknown fsync mode %q (want %s, %s or %s)", mode, fsyncNone, fsyncOnClose, fsyncEveryN)
+	}
+}
+
+// String describes the policy for logs
+func (p fsyncPolicy) String() string {
+	if p.Mode == fsyncEveryN {
+		return fmt.Sprintf("%s:%d", p.Mode, p.Bytes)
+	}
+	return p.Mode
+}
+
+// Storage backends a listener can use
+const (
+	backendDisk    = "disk"
+	backendDiscard = "discard"
+)
+
+// backend is where a listener puts uploads
+type backend interface {
+	list(ns string) ([]os.FileInfo, error)
+	put(ns, filename string, r io.Reader, size int64) (*putResult, error)
+	get(ns, filename string) (*os.File, error)
+	policy() string // Fsync policy for logs, empty when nothing is written
+	String() string
+}
+
+// storage stores uploaded files in a directory. Clients may pick a namespace,
+// a subdirectory of their own, to keep runs from colliding on filenames.
+type storage struct {
+	dir     string
+	fsync   fsyncPolicy
+	quota   int64 // Total bytes across all namespaces, 0 for unlimited
+	nsQuota int64 // Bytes per namespace, 0 for unlimited
+
+	mu       sync.Mutex
+	usage    map[string]int64 // Committed and reserved bytes per namespace
+	inflight map[string]int   // Uploads in progress per namespace
+}
+
+// putResult describes a completed upload
+type putResult struct {
+	Filename   string
+	Bytes      int64
+	FsyncCount int
+	FsyncTime  time.Duration
+}
+
+// newStorage creates the storage directory, removes uploads left behind by a crash
+// and measures the space already used by each namespace
+func newStorage(dir string, fsync fsyncPolicy, quota, nsQuota int64) (*storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &storage{
+		dir:      dir,
+		fsync:    fsync,
+		quota:    quota,
+		nsQuota:  nsQuota,
+		usage:    make(map[string]int64),
+		inflight: make(map[string]int),
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && strings.Contains(d.Name(), tempMarker) {
+			os.Remove(path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, filepath.Dir(path))
+		if rel == "." {
+			rel = ""
+		}
+		s.usage[rel] += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *storage) policy() string {
+	return s.fsync.String()
+}
+
+func (s *storage) String() string {
+	return fmt.Sprintf("%s:%s (fsync %s)", backendDisk, s.dir, s.fsync)
+}
+
+// copyN copies n bytes from r to f like io.CopyN. When r is an io.LimitedReader
+// its limit is applied in place rather than wrapping it again, so a socket
+// underneath is spliced straight into the file.
+func copyN(f *os.File, r io.Reader, n int64) (int64, error) {
+	lr, ok := r.(*io.LimitedReader)
+	if !ok {
+		return io.CopyN(f, r, n)
+	}
+
+	limit := n
+	if limit > lr.N {
+		limit = lr.N
+	}
+	written, err := f.ReadFrom(&io.LimitedReader{R: lr.R, N: limit})
+	lr.N -= written
+	if err == nil && written < n {
+		err = io.EOF
+	}
+	return written, err
+}
+
+// discardStorage reads uploads and throws them away, taking disk speed out of the
+// measurement
+type discardStorage struct{}
+
+func (discardStorage) list(ns string) ([]os.FileInfo, error) {
+	return nil, validateNamespace(ns)
+}
+
+func (discardStorage) put(ns, filename string, r io.Reader, size int64) (*putResult, error) {
+	if err := validateNamespace(ns); err != nil {
+		return nil, err
+	}
+	n, err := io.CopyN(io.Discard, r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return &putResult{Filename: filepath.Base(filename), Bytes: n}, nil
+}
+
+func (discardStorage) get(ns, filename string) (*os.File, error) {
+	return nil, fmt.Errorf("file %s not found: the discard backend keeps no files", filepath.Base(filename))
+}
+
+func (discardStorage) policy() string { return "" }
+
+func (discardStorage) String() string { return backendDiscard }
+
+// validateNamespace checks that a client-chosen namespace is a plain directory name
+func validateNamespace(ns string) error {
+	if ns == "" {
+		return nil
+	}
+	if len(ns) > 128 || strings.HasPrefix(ns, ".") {
+		return fmt.Errorf("invalid namespace %q", ns)
+	}
+	for _, r := range ns {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("invalid namespace %q: only letters, digits, '-', '_' and '.' are allowed", ns)
+		}
+	}
+	return nil
+}
+
+// nsDir returns the directory holding a namespace
+func (s *storage) nsDir(ns string) string {
+	return filepath.Join(s.dir, ns)
+}
+
+// list returns the committed files in a namespace
+func (s *storage) list(ns string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(s.nsDir(ns))
+	if os.IsNotExist(err) && ns != "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		// Skip directories and in-progress uploads
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// get opens a committed file in a namespace for reading
+func (s *storage) get(ns, filename string) (*os.File, error) {
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) || strings.HasPrefix(filename, ".") {
+		return nil, fmt.Errorf("invalid filename %q", filename)
+	}
+	if err := validateNamespace(ns); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(s.nsDir(ns), filename))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("file %s not found", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("file %s not found", filename)
+	}
+	return f, nil
+}
+
+// reserve claims size bytes in a namespace, failing if a quota would be exceeded
+func (s *storage) reserve(ns string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nsQuota > 0 && s.usage[ns]+size > s.nsQuota {
+		return fmt.Errorf("namespace quota exceeded: %q uses %d of %d bytes, upload needs %d", ns, s.usage[ns], s.nsQuota, size)
+	}
+	if s.quota > 0 {
+		var total int64
+		for _, used := range s.usage {
+			total += used
+		}
+		if total+size > s.quota {
+			return fmt.Errorf("storage quota exceeded: %d of %d bytes used, upload needs %d", total, s.quota, size)
+		}
+	}
+
+	s.usage[ns] += size
+	s.inflight[ns]++
+	return nil
+}
+
+// finish ends an upload started with reserve, returning the space if it was not committed
+func (s *storage) finish(ns string, size int64, committed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !committed {
+		s.usage[ns] -= size
+	}
+	if s.inflight[ns]--; s.inflight[ns] <= 0 {
+		delete(s.inflight, ns)
+	}
+}
+
+// purgeOlderThan removes namespaces whose newest file is older than maxAge.
+// Namespaces with uploads in progress are kept.
+func (s *storage) purgeOlderThan(maxAge time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	var purged []string
+	for _, entry := range entries {
+		ns := entry.Name()
+		if !entry.IsDir() || validateNamespace(ns) != nil {
+			continue
+		}
+
+		newest, err := newestModTime(s.nsDir(ns))
+		if err != nil || newest.After(cutoff) {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.inflight[ns] > 0 {
+			s.mu.Unlock()
+			continue
+		}
+		err = os.RemoveAll(s.nsDir(ns))
+		if err == nil {
+			delete(s.usage, ns)
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge namespace %q: %v", ns, err)
+		}
+		purged = append(purged, ns)
+	}
+	return purged, nil
+}
+
+// newestModTime returns the latest modification time of a directory or anything in it
+func newestModTime(dir string) (time.Time, error) {
+	var newest time.Time
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest, err
+}
+
+// retain purges namespaces older than maxAge every interval, forever
+func (s *storage) retain(maxAge, interval time.Duration) {
+	for {
+		purged, err := s.purgeOlderThan(maxAge)
+		if err != nil {
+			slog.Error("retention failed", "err", err)
+		}
+		for _, ns := range purged {
+			slog.Info("namespace purged", "namespace", ns, "max_age", maxAge)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// put writes size bytes from r to a temporary file in namespace ns and commits it
+// under filename. A failed upload never leaves a partial file at the final path.
+func (s *storage) put(ns, filename string, r io.Reader, size int64) (*putResult, error) {
+	// Clean filename to prevent directory traversal
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) || strings.HasPrefix(filename, ".") {
+		return nil, fmt.Errorf("invalid filename %q", filename)
+	}
+	if err := validateNamespace(ns); err != nil {
+		return nil, err
+	}
+	dir := s.nsDir(ns)
+	filePath := filepath.Join(dir, filename)
+
+	// Check if file already exists
+	if _, err := os.Stat(filePath); err == nil {
+		return nil, fmt.Errorf("file %s already exists", filename)
+	}
+
+	if err := s.reserve(ns, size); err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() { s.finish(ns, size, committed) }()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create namespace directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filename+tempMarker+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	tmpPath := tmp.Name()
+	// CreateTemp uses 0600; keep the permissions uploads had before
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to set file mode: %v", err)
+	}
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	result := &putResult{Filename: filename}
+	syncFile := func(f *os.File) error {
+		start := time.Now()
+		err := f.Sync()
+		result.FsyncTime += time.Since(start)
+		result.FsyncCount++
+		return err
+	}
+
+	// Copy in fsync-sized chunks so every-n flushes happen at byte boundaries
+	chunk := size
+	if s.fsync.Mode == fsyncEveryN {
+		chunk = s.fsync.Bytes
+	}
+	for result.Bytes < size {
+		n := chunk
+		if remaining := size - result.Bytes; n > remaining {
+			n = remaining
+		}
+		written, err := copyN(tmp, r, n)
+		result.Bytes += written
+		if err != nil {
+			return nil, fmt.Errorf("failed to write file: %w", err)
+		}
+		if s.fsync.Mode == fsyncEveryN && result.Bytes < size {
+			if err := syncFile(tmp); err != nil {
+				return nil, fmt.Errorf("failed to sync file: %v", err)
+			}
+		}
+	}
+
+	if s.fsync.Mode != fsyncNone {
+		if err := syncFile(tmp); err != nil {
+			return nil, fmt.Errorf("failed to sync file: %v", err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close file: %v", err)
+	}
+
+	// Rename within the directory is atomic. The existence check is repeated under
+	// the lock so a concurrent upload of the same name is never clobbered.
+	s.mu.Lock()
+	if _, err := os.Stat(filePath); err == nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("file %s already exists", filename)
+	}
+	err = os.Rename(tmpPath, filePath)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit file: %v", err)
+	}
+	committed = true
+
+	// Persist the directory entry as well. It is left out of the fsync count and
+	// time, which describe the syncs of the file data the policy asks for.
+	if s.fsync.Mode != fsyncNone {
+		d, err := os.Open(dir)
+		if err == nil {
+			d.Sync()
+			d.Close()
+		}
+	}
+
+	return result, nil
+}
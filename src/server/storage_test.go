@@ -0,0 +1,426 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFsyncPolicy(t *testing.T) {
+	tests := []struct {
+		mode    string
+		bytes   int64
+		want    fsyncPolicy
+		wantErr bool
+	}{
+		{mode: fsyncNone, want: fsyncPolicy{Mode: fsyncNone}},
+		{mode: fsyncOnClose, bytes: 100, want: fsyncPolicy{Mode: fsyncOnClose}},
+		{mode: fsyncEveryN, bytes: 1 << 20, want: fsyncPolicy{Mode: fsyncEveryN, Bytes: 1 << 20}},
+		{mode: fsyncEveryN, bytes: 0, wantErr: true},
+		{mode: "always", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFsyncPolicy(tt.mode, tt.bytes)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseFsyncPolicy(%q, %d) = %+v, want error", tt.mode, tt.bytes, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseFsyncPolicy(%q, %d) = %+v, %v, want %+v", tt.mode, tt.bytes, got, err, tt.want)
+		}
+	}
+}
+
+// dirEntries returns the names in dir, in-progress uploads included
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestStoragePut(t *testing.T) {
+	for _, mode := range []string{fsyncNone, fsyncOnClose, fsyncEveryN} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			policy, _ := parseFsyncPolicy(mode, 4)
+			s, err := newStorage(dir, policy, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := s.put("", "a.bin", strings.NewReader("hello world"), 11)
+			if err != nil {
+				t.Fatalf("put() failed: %v", err)
+			}
+			if result.Filename != "a.bin" || result.Bytes != 11 {
+				t.Errorf("put() = %+v", result)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "a.bin"))
+			if err != nil || string(data) != "hello world" {
+				t.Errorf("stored file = %q, %v", data, err)
+			}
+			if names := dirEntries(t, dir); len(names) != 1 {
+				t.Errorf("storage holds %v, want only a.bin", names)
+			}
+
+			// An existing file is never replaced
+			if _, err := s.put("", "a.bin", strings.NewReader("other"), 5); err == nil {
+				t.Errorf("put() over an existing file succeeded")
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "a.bin")); string(data) != "hello world" {
+				t.Errorf("existing file changed to %q", data)
+			}
+			if names := dirEntries(t, dir); len(names) != 1 {
+				t.Errorf("rejected upload left %v behind", names)
+			}
+		})
+	}
+}
+
+func TestStoragePutFsyncCount(t *testing.T) {
+	tests := []struct {
+		mode  string
+		bytes int64
+		data  string
+		want  int
+	}{
+		{mode: fsyncNone, data: "hello world", want: 0},
+		{mode: fsyncOnClose, data: "hello world", want: 1},
+		{mode: fsyncOnClose, data: "", want: 1},
+		// Syncs after bytes 4 and 8, then once more at the end
+		{mode: fsyncEveryN, bytes: 4, data: "hello world", want: 3},
+		// The last chunk ends the file, so its sync is the final one
+		{mode: fsyncEveryN, bytes: 4, data: "hello wo", want: 2},
+		{mode: fsyncEveryN, bytes: 100, data: "hello world", want: 1},
+	}
+	for _, tt := range tests {
+		policy, _ := parseFsyncPolicy(tt.mode, tt.bytes)
+		s, err := newStorage(t.TempDir(), policy, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := s.put("", "a.bin", strings.NewReader(tt.data), int64(len(tt.data)))
+		if err != nil {
+			t.Fatalf("put() with %s failed: %v", policy, err)
+		}
+		if result.FsyncCount != tt.want {
+			t.Errorf("put() of %d bytes with %s made %d fsyncs, want %d", len(tt.data), policy, result.FsyncCount, tt.want)
+		}
+	}
+}
+
+func TestStoragePutFailure(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newStorage(dir, fsyncPolicy{Mode: fsyncOnClose}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection ends before the announced size
+	if _, err := s.put("", "short.bin", strings.NewReader("abc"), 10); err == nil {
+		t.Errorf("put() of a truncated upload succeeded")
+	}
+	for _, name := range []string{".hidden", "..", "/"} {
+		if _, err := s.put("", name, strings.NewReader("abc"), 3); err == nil {
+			t.Errorf("put(%q) succeeded", name)
+		}
+	}
+	if names := dirEntries(t, dir); len(names) != 0 {
+		t.Errorf("failed uploads left %v behind", names)
+	}
+
+	// Directory components are stripped
+	if result, err := s.put("", "../../escape.bin", strings.NewReader("abc"), 3); err != nil || result.Filename != "escape.bin" {
+		t.Errorf("put() = %+v, %v, want escape.bin stored", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.bin")); err != nil {
+		t.Errorf("escape.bin not stored in the storage directory: %v", err)
+	}
+}
+
+func TestNewStorageRemovesStaleUploads(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"kept.bin", ".a.bin" + tempMarker + "123"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := newStorage(dir, fsyncPolicy{Mode: fsyncNone}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 || names[0] != "kept.bin" {
+		t.Errorf("storage holds %v, want only kept.bin", names)
+	}
+	files, err := s.list("")
+	if err != nil || len(files) != 1 || files[0].Name() != "kept.bin" {
+		t.Errorf("list() = %v, %v", files, err)
+	}
+}
+
+func TestValidateNamespace(t *testing.T) {
+	for _, ns := range []string{"", "run-1", "Run_2.a", strings.Repeat("x", 128)} {
+		if err := validateNamespace(ns); err != nil {
+			t.Errorf("validateNamespace(%q) = %v", ns, err)
+		}
+	}
+	for _, ns := range []string{".", "..", ".hidden", "a/b", "../x", "a b", strings.Repeat("x", 129)} {
+		if err := validateNamespace(ns); err == nil {
+			t.Errorf("validateNamespace(%q) succeeded", ns)
+		}
+	}
+}
+
+func TestStorageNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newStorage(dir, fsyncPolicy{Mode: fsyncNone}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ns := range []string{"", "a", "b"} {
+		if _, err := s.put(ns, "same.bin", strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("put(%q) failed: %v", ns, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "same.bin")); err != nil {
+		t.Errorf("namespace file not in its directory: %v", err)
+	}
+	if _, err := s.put("../a", "x.bin", strings.NewReader("data"), 4); err == nil {
+		t.Errorf("put() into an invalid namespace succeeded")
+	}
+
+	files, err := s.list("a")
+	if err != nil || len(files) != 1 || files[0].Name() != "same.bin" {
+		t.Errorf("list(a) = %v, %v", files, err)
+	}
+	// The default namespace lists its own files, not other namespaces
+	if files, err := s.list(""); err != nil || len(files) != 1 {
+		t.Errorf("list() = %v, %v", files, err)
+	}
+	if files, err := s.list("unused"); err != nil || len(files) != 0 {
+		t.Errorf("list(unused) = %v, %v", files, err)
+	}
+}
+
+func TestStorageQuota(t *testing.T) {
+	type upload struct {
+		ns   string
+		size int64
+	}
+	tests := []struct {
+		name           string
+		quota, nsQuota int64
+		uploads        []upload
+		wantFail       []bool
+	}{
+		{
+			name:     "namespace quota",
+			nsQuota:  10,
+			uploads:  []upload{{"a", 6}, {"a", 5}, {"b", 10}, {"a", 4}},
+			wantFail: []bool{false, true, false, false},
+		},
+		{
+			name:     "total quota",
+			quota:    10,
+			uploads:  []upload{{"a", 6}, {"b", 5}, {"b", 4}, {"", 1}},
+			wantFail: []bool{false, true, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newStorage(t.TempDir(), fsyncPolicy{Mode: fsyncNone}, tt.quota, tt.nsQuota)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, u := range tt.uploads {
+				name := string(rune('a'+i)) + ".bin"
+				_, err := s.put(u.ns, name, strings.NewReader(strings.Repeat("x", int(u.size))), u.size)
+				if (err != nil) != tt.wantFail[i] {
+					t.Errorf("upload %d of %d bytes to %q: %v, want failure %t", i, u.size, u.ns, err, tt.wantFail[i])
+				}
+			}
+		})
+	}
+
+	// A failed upload gives its reservation back
+	s, err := newStorage(t.TempDir(), fsyncPolicy{Mode: fsyncNone}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.put("a", "short.bin", strings.NewReader("abc"), 10); err == nil {
+		t.Fatalf("put() of a truncated upload succeeded")
+	}
+	if _, err := s.put("a", "full.bin", strings.NewReader(strings.Repeat("x", 10)), 10); err != nil {
+		t.Errorf("put() after a failed upload: %v", err)
+	}
+	if len(s.inflight) != 0 {
+		t.Errorf("uploads still in flight: %v", s.inflight)
+	}
+}
+
+func TestNewStorageMeasuresUsage(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newStorage(dir, fsyncPolicy{Mode: fsyncNone}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.put("", "a.bin", strings.NewReader("12345"), 5)
+	s.put("ns", "b.bin", strings.NewReader("123"), 3)
+
+	// A restarted server finds the same usage on disk
+	s, err = newStorage(dir, fsyncPolicy{Mode: fsyncNone}, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.usage[""] != 5 || s.usage["ns"] != 3 {
+		t.Errorf("usage = %v, want 5 bytes in the default namespace and 3 in ns", s.usage)
+	}
+	if _, err := s.put("ns", "c.bin", strings.NewReader("12"), 2); err == nil {
+		t.Errorf("put() over the namespace quota succeeded after a restart")
+	}
+}
+
+func TestStoragePurgeOlderThan(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newStorage(dir, fsyncPolicy{Mode: fsyncNone}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ns := range []string{"", "old", "new", "busy"} {
+		if _, err := s.put(ns, "f.bin", strings.NewReader("x"), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{"f.bin", "old", "old/f.bin", "busy", "busy/f.bin"} {
+		os.Chtimes(filepath.Join(dir, path), old, old)
+	}
+	s.inflight["busy"] = 1
+
+	purged, err := s.purgeOlderThan(time.Hour)
+	if err != nil || len(purged) != 1 || purged[0] != "old" {
+		t.Fatalf("purgeOlderThan() = %v, %v, want [old]", purged, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Errorf("purged namespace still on disk: %v", err)
+	}
+	// The default namespace is never purged
+	if _, err := os.Stat(filepath.Join(dir, "f.bin")); err != nil {
+		t.Errorf("default namespace file purged: %v", err)
+	}
+	if _, ok := s.usage["old"]; ok {
+		t.Errorf("purged namespace still accounted: %v", s.usage)
+	}
+}
+
+func TestDiscardStorage(t *testing.T) {
+	var d discardStorage
+	r := strings.NewReader("hello world")
+	result, err := d.put("ns", "dir/a.bin", r, 5)
+	if err != nil || result.Filename != "a.bin" || result.Bytes != 5 {
+		t.Errorf("put() = %+v, %v", result, err)
+	}
+	if r.Len() != 6 {
+		t.Errorf("put() read %d bytes, want 5", 11-r.Len())
+	}
+	if _, err := d.put("ns", "a.bin", strings.NewReader("abc"), 10); err == nil {
+		t.Errorf("put() of a truncated upload succeeded")
+	}
+	if _, err := d.put("../x", "a.bin", strings.NewReader("abc"), 3); err == nil {
+		t.Errorf("put() into an invalid namespace succeeded")
+	}
+	if files, err := d.list(""); err != nil || len(files) != 0 {
+		t.Errorf("list() = %v, %v", files, err)
+	}
+}
+
+func TestCopyN(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		limit   int64 // Limit of an io.LimitedReader around the data, 0 for none
+		n       int64
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", data: "abcdef", n: 4, want: "abcd"},
+		{name: "plain short", data: "ab", n: 4, want: "ab", wantErr: true},
+		{name: "limited", data: "abcdef", limit: 5, n: 3, want: "abc"},
+		// The reader's own limit ends the copy early
+		{name: "limited short", data: "abcdef", limit: 2, n: 3, want: "ab", wantErr: true},
+	}
+	for _, tt := range tests {
+		f, err := os.CreateTemp(t.TempDir(), "copy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = strings.NewReader(tt.data)
+		lr := &io.LimitedReader{R: r, N: tt.limit}
+		if tt.limit > 0 {
+			r = lr
+		}
+		n, err := copyN(f, r, tt.n)
+		f.Close()
+		if (err != nil) != tt.wantErr || n != int64(len(tt.want)) {
+			t.Errorf("%s: copyN() = %d, %v, want %d bytes, error %t", tt.name, n, err, len(tt.want), tt.wantErr)
+		}
+		if data, _ := os.ReadFile(f.Name()); string(data) != tt.want {
+			t.Errorf("%s: copied %q, want %q", tt.name, data, tt.want)
+		}
+		if tt.limit > 0 && lr.N != tt.limit-n {
+			t.Errorf("%s: limit left at %d after copying %d of %d bytes", tt.name, lr.N, n, tt.limit)
+		}
+	}
+}
+
+func TestStoragePutFromSocket(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	data := strings.Repeat("0123456789", 100000)
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, data+"trailing")
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	dir := t.TempDir()
+	s, err := newStorage(dir, fsyncPolicy{Mode: fsyncEveryN, Bytes: 300000}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The spliced upload stops at its size, leaving the rest on the socket
+	lr := &io.LimitedReader{R: conn, N: int64(len(data))}
+	if _, err := s.put("", "spliced.bin", lr, int64(len(data))); err != nil {
+		t.Fatalf("put() failed: %v", err)
+	}
+	if stored, _ := os.ReadFile(filepath.Join(dir, "spliced.bin")); string(stored) != data {
+		t.Errorf("stored %d bytes, want the %d sent", len(stored), len(data))
+	}
+	if rest, _ := io.ReadAll(conn); string(rest) != "trailing" {
+		t.Errorf("left on the socket: %q", rest)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stall is a one-off pause in reading, relative to the first read of a transfer
+type stall struct {
+	At  time.Duration
+	For time.Duration
+}
+
+// readThrottle limits how fast the server drains a connection, so that the
+// sender becomes receive-window limited
+type readThrottle struct {
+	Rate       int64 // Bytes per second, 0 for unlimited
+	Stalls     []stall
+	PauseEvery time.Duration // Read for PauseEvery, then pause for PauseFor
+	PauseFor   time.Duration
+}
+
+// parseReadThrottle parses a throttle spec such as
+// "rate=1048576,stall=2s:500ms,stall=5s:1s,pause=1s/200ms". An empty spec disables throttling.
+func parseReadThrottle(spec string) (readThrottle, error) {
+	var t readThrottle
+	if strings.TrimSpace(spec) == "" {
+		return t, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return t, fmt.Errorf("invalid read throttle entry %q", part)
+		}
+
+		switch key {
+		case "rate":
+			rate, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || rate < 0 {
+				return t, fmt.Errorf("invalid read rate %q", value)
+			}
+			t.Rate = rate
+		case "stall":
+			at, dur, ok := strings.Cut(value, ":")
+			if !ok {
+				return t, fmt.Errorf("invalid stall %q (want <at>:<duration>)", value)
+			}
+			var s stall
+			var err error
+			if s.At, err = time.ParseDuration(at); err != nil || s.At < 0 {
+				return t, fmt.Errorf("invalid stall start %q", at)
+			}
+			if s.For, err = time.ParseDuration(dur); err != nil || s.For < 0 {
+				return t, fmt.Errorf("invalid stall duration %q", dur)
+			}
+			t.Stalls = append(t.Stalls, s)
+		case "pause":
+			every, dur, ok := strings.Cut(value, "/")
+			if !ok {
+				return t, fmt.Errorf("invalid pause %q (want <every>/<duration>)", value)
+			}
+			var err error
+			if t.PauseEvery, err = time.ParseDuration(every); err != nil || t.PauseEvery <= 0 {
+				return t, fmt.Errorf("invalid pause interval %q", every)
+			}
+			if t.PauseFor, err = time.ParseDuration(dur); err != nil || t.PauseFor < 0 {
+				return t, fmt.Errorf("invalid pause duration %q", dur)
+			}
+		default:
+			return t, fmt.Errorf("unknown read throttle key %q", key)
+		}
+	}
+
+	return t, nil
+}
+
+// enabled reports whether the throttle slows reading at all
+func (t readThrottle) enabled() bool {
+	return t.Rate > 0 || len(t.Stalls) > 0 || (t.PauseEvery > 0 && t.PauseFor > 0)
+}
+
+// String describes the throttle for logs
+func (t readThrottle) String() string {
+	if !t.enabled() {
+		return ""
+	}
+	var parts []string
+	if t.Rate > 0 {
+		parts = append(parts, fmt.Sprintf("rate=%d", t.Rate))
+	}
+	for _, s := range t.Stalls {
+		parts = append(parts, fmt.Sprintf("stall=%v:%v", s.At, s.For))
+	}
+	if t.PauseEvery > 0 && t.PauseFor > 0 {
+		parts = append(parts, fmt.Sprintf("pause=%v/%v", t.PauseEvery, t.PauseFor))
+	}
+	return strings.Join(parts, ",")
+}
+
+// wrap returns a reader that drains r according to the throttle
+func (t readThrottle) wrap(r io.Reader) io.Reader {
+	if !t.enabled() {
+		return r
+	}
+	return &throttledReader{r: r, t: t}
+}
+
+// throttledReadChunk bounds each read so the throttle stays smooth
+const throttledReadChunk = 16 * 1024
+
+type throttledReader struct {
+	r      io.Reader
+	t      readThrottle
+	start  time.Time
+	total  int64
+	paused time.Duration // Time spent in stalls and pauses, excluded from the rate
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if tr.start.IsZero() {
+		tr.start = time.Now()
+	}
+	tr.wait()
+
+	if len(p) > throttledReadChunk {
+		p = p[:throttledReadChunk]
+	}
+	n, err := tr.r.Read(p)
+	tr.total += int64(n)
+	return n, err
+}
+
+// wait sleeps through stalls and pauses, and until the rate allows more data
+func (tr *throttledReader) wait() {
+	pause := func(d time.Duration) {
+		time.Sleep(d)
+		tr.paused += d
+	}
+	elapsed := time.Since(tr.start)
+
+	for _, s := range tr.t.Stalls {
+		if elapsed >= s.At && elapsed < s.At+s.For {
+			pause(s.At + s.For - elapsed)
+			elapsed = time.Since(tr.start)
+		}
+	}
+
+	if tr.t.PauseEvery > 0 && tr.t.PauseFor > 0 {
+		cycle := tr.t.PauseEvery + tr.t.PauseFor
+		if pos := elapsed % cycle; pos >= tr.t.PauseEvery {
+			pause(cycle - pos)
+		}
+	}
+
+	if tr.t.Rate > 0 {
+		due := tr.paused + time.Duration(float64(tr.total)/float64(tr.t.Rate)*float64(time.Second))
+		if ahead := due - time.Since(tr.start); ahead > 0 {
+			time.Sleep(ahead)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseReadThrottle(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    readThrottle
+		wantErr bool
+	}{
+		{spec: "", want: readThrottle{}},
+		{spec: "rate=1048576", want: readThrottle{Rate: 1048576}},
+		{
+			spec: "rate=1000, stall=2s:500ms,stall=5s:1s,pause=1s/200ms",
+			want: readThrottle{
+				Rate:       1000,
+				Stalls:     []stall{{At: 2 * time.Second, For: 500 * time.Millisecond}, {At: 5 * time.Second, For: time.Second}},
+				PauseEvery: time.Second,
+				PauseFor:   200 * time.Millisecond,
+			},
+		},
+		{spec: "stall=0s:0s,pause=1s/0s", want: readThrottle{Stalls: []stall{{}}, PauseEvery: time.Second}},
+		{spec: "rate=-1", wantErr: true},
+		{spec: "rate=fast", wantErr: true},
+		{spec: "stall=2s", wantErr: true},
+		{spec: "stall=x:1s", wantErr: true},
+		{spec: "stall=1s:x", wantErr: true},
+		{spec: "stall=-1s:1s", wantErr: true},
+		{spec: "stall=1s:-1s", wantErr: true},
+		{spec: "pause=1s", wantErr: true},
+		{spec: "pause=0s/1s", wantErr: true},
+		{spec: "pause=1s/x", wantErr: true},
+		{spec: "pause=1s/-1s", wantErr: true},
+		{spec: "pause=-1s/1s", wantErr: true},
+		{spec: "rate", wantErr: true},
+		{spec: "speed=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseReadThrottle(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseReadThrottle(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseReadThrottle(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestReadThrottleString(t *testing.T) {
+	for _, spec := range []string{"", "rate=1000", "stall=1s:500ms", "rate=5,stall=1s:1s,stall=3s:2s,pause=1s/100ms"} {
+		th, err := parseReadThrottle(spec)
+		if err != nil {
+			t.Fatalf("parseReadThrottle(%q) failed: %v", spec, err)
+		}
+		if got := th.String(); got != spec {
+			t.Errorf("parseReadThrottle(%q).String() = %q", spec, got)
+		}
+	}
+
+	// A pause of nothing does not throttle
+	th, _ := parseReadThrottle("pause=1s/0s")
+	if th.enabled() || th.String() != "" {
+		t.Errorf("zero-length pause is enabled: %q", th.String())
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	tests := []struct {
+		spec    string
+		size    int
+		minTime time.Duration
+		maxTime time.Duration
+	}{
+		{spec: "", size: 1 << 20, maxTime: 50 * time.Millisecond},
+		{spec: "rate=1048576", size: 256 << 10, minTime: 200 * time.Millisecond, maxTime: time.Second},
+		{spec: "stall=0s:200ms", size: 64 << 10, minTime: 200 * time.Millisecond, maxTime: time.Second},
+		// Later stalls never fire on a transfer this short
+		{spec: "stall=10s:1s", size: 64 << 10, maxTime: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		th, err := parseReadThrottle(tt.spec)
+		if err != nil {
+			t.Fatalf("parseReadThrottle(%q) failed: %v", tt.spec, err)
+		}
+		data := bytes.Repeat([]byte{'x'}, tt.size)
+
+		start := time.Now()
+		got, err := io.ReadAll(th.wrap(bytes.NewReader(data)))
+		elapsed := time.Since(start)
+
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%q: read %d bytes, %v, want %d", tt.spec, len(got), err, tt.size)
+		}
+		if elapsed < tt.minTime || elapsed > tt.maxTime {
+			t.Errorf("%q: reading took %v, want between %v and %v", tt.spec, elapsed, tt.minTime, tt.maxTime)
+		}
+	}
+}
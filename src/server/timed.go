@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+// timedUpload is a duration-based upload: a SEND frame starts it, DATA frames
+// carry chunks that are read and dropped, and an empty DATA frame ends it
+type timedUpload struct {
+	window   common.SteadyWindow
+	in       io.Reader // The connection, wrapped once in its read throttle so the throttle spans the whole upload
+	start    time.Time
+	end      time.Time // Zero while chunks are still arriving
+	bytes    int64
+	verifier *common.Verifier // Checks the chunks against the connection's payload, if any
+}
+
+// newTimedUpload starts a timed upload whose chunks are read from in under the
+// connection's settings
+func newTimedUpload(window common.SteadyWindow, in io.Reader, settings *connSettings) *timedUpload {
+	t := &timedUpload{window: window, in: settings.throttle.wrap(in), start: time.Now()}
+	if settings.payload != nil {
+		t.verifier = common.NewVerifier(settings.payload)
+	}
+	return t
+}
+
+// handleSendRequest starts a timed upload. Nothing is sent back unless the window
+// is invalid; the client streams its chunks right away.
+func handleSendRequest(in io.Reader, frame *protocol.Frame, settings *connSettings, events *slog.Logger) (*timedUpload, *protocol.Frame) {
+	window, err := common.ParseSteadyWindow(string(frame.Payload))
+	if err != nil {
+		return nil, protocol.CreateErrorFrame(fmt.Sprintf("Invalid SEND request: %v", err))
+	}
+	events.Info("timed upload started", "op", "SEND", "window", window.String())
+	return newTimedUpload(window, in, settings), nil
+}
+
+// data consumes a DATA frame and returns the response once the empty frame ends
+// the upload. A non-nil error means the connection can no longer be used.
+func (t *timedUpload) data(frame *protocol.Frame, events *slog.Logger) (*protocol.Frame, error) {
+	if frame.PayloadLen > 0 {
+		var dst io.Writer = io.Discard
+		if t.verifier != nil {
+			dst = t.verifier
+		}
+		n, err := io.CopyN(dst, t.in, int64(frame.PayloadLen))
+		t.bytes += n
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DATA payload: %w", err)
+		}
+		return nil, nil
+	}
+
+	t.end = time.Now()
+	elapsed := t.end.Sub(t.start)
+	events.Info("timed upload finished", "op", "DATA", "bytes", t.bytes, "duration", elapsed)
+
+	response := fmt.Sprintf("Received %d bytes in %.2f seconds", t.bytes, elapsed.Seconds())
+	if t.verifier != nil {
+		if err := t.verifier.Err(); err != nil {
+			events.Warn("payload verification failed", "op", "DATA", "bytes", t.bytes, "err", err)
+			return protocol.CreateErrorFrame(fmt.Sprintf("%s, %v", response, err)), nil
+		}
+		response += fmt.Sprintf(", %d bytes verified", t.verifier.Verified())
+	}
+	return &protocol.Frame{
+		OpCode:     protocol.OpSend,
+		PayloadLen: uint32(len(response)),
+		Payload:    []byte(response),
+	}, nil
+}
+
+// steadyState fills in the timed upload's window and steady-state throughput,
+// measured from the bytes_received counter of the connection's samples. DATA
+// frames sent without a SEND have no window and are left alone.
+func (t *timedUpload) steadyState(log *common.ConnectionLog, samples []common.TCPInfo) {
+	if t.window.Duration == 0 {
+		return
+	}
+	end := t.end
+	if end.IsZero() {
+		end = log.EndTime
+	}
+	from, to := t.window.Bounds(t.start, end)
+	log.Warmup = t.window.Warmup.Seconds()
+	log.Cooldown = t.window.Cooldown.Seconds()
+	log.SteadyStateThroughput = common.SteadyStateRate(samples, from, to, func(s common.TCPInfo) uint64 { return s.BytesReceived })
+}
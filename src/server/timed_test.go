@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+func TestHandleSendRequest(t *testing.T) {
+	events := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	upload, response := handleSendRequest(strings.NewReader(""), protocol.CreateSendFrame("warmup=1s"), &connSettings{}, events)
+	if response != nil {
+		t.Fatalf("handleSendRequest() response = %q, want none", response.Payload)
+	}
+	if upload.window.Warmup.Seconds() != 1 {
+		t.Errorf("handleSendRequest() warmup = %v, want 1s", upload.window.Warmup)
+	}
+
+	upload, response = handleSendRequest(strings.NewReader(""), protocol.CreateSendFrame("warmup=x"), &connSettings{}, events)
+	if upload != nil || response == nil || response.OpCode != protocol.OpError {
+		t.Errorf("handleSendRequest(warmup=x) = %v, %v, want an ERROR frame", upload, response)
+	}
+}
+
+func TestTimedUploadData(t *testing.T) {
+	events := slog.New(slog.NewTextHandler(io.Discard, nil))
+	in := strings.NewReader("0123456789abc")
+	upload, _ := handleSendRequest(in, protocol.CreateSendFrame(""), &connSettings{}, events)
+
+	for _, n := range []uint32{4, 6} {
+		response, err := upload.data(&protocol.Frame{OpCode: protocol.OpData, PayloadLen: n}, events)
+		if response != nil || err != nil {
+			t.Fatalf("data(%d) = %v, %v, want nothing", n, response, err)
+		}
+	}
+	if in.Len() != 3 {
+		t.Errorf("data() left %d bytes, want 3", in.Len())
+	}
+
+	response, err := upload.data(&protocol.Frame{OpCode: protocol.OpData}, events)
+	if err != nil || response == nil || response.OpCode != protocol.OpSend {
+		t.Fatalf("data(0) = %v, %v, want a SEND frame", response, err)
+	}
+	if !strings.HasPrefix(string(response.Payload), "Received 10 bytes") {
+		t.Errorf("data(0) response = %q, want 10 bytes received", response.Payload)
+	}
+	if upload.end.IsZero() {
+		t.Error("data(0) did not end the upload")
+	}
+
+	// A truncated chunk fails the connection but still counts what arrived
+	upload = newTimedUpload(common.SteadyWindow{}, strings.NewReader("xy"), &connSettings{})
+	if _, err := upload.data(&protocol.Frame{OpCode: protocol.OpData, PayloadLen: 5}, events); err == nil {
+		t.Error("data() on a truncated chunk succeeded")
+	}
+	if upload.bytes != 2 {
+		t.Errorf("data() on a truncated chunk counted %d bytes, want 2", upload.bytes)
+	}
+}
+
+func TestTimedUploadVerify(t *testing.T) {
+	events := slog.New(slog.NewTextHandler(io.Discard, nil))
+	payload, err := common.ParsePayload("zeros")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data   string
+		wantOp byte
+	}{
+		{data: "\x00\x00\x00\x00", wantOp: protocol.OpSend},
+		{data: "\x00\x00x\x00", wantOp: protocol.OpError},
+	}
+	for _, tt := range tests {
+		// DATA frames without a SEND are verified as well
+		upload := newTimedUpload(common.SteadyWindow{}, strings.NewReader(tt.data), &connSettings{payload: payload})
+		frame := &protocol.Frame{OpCode: protocol.OpData, PayloadLen: uint32(len(tt.data))}
+		if _, err := upload.data(frame, events); err != nil {
+			t.Fatalf("data(%q) failed: %v", tt.data, err)
+		}
+		response, err := upload.data(&protocol.Frame{OpCode: protocol.OpData}, events)
+		if err != nil || response.OpCode != tt.wantOp {
+			t.Errorf("data(%q) response = %v, %v, want opcode %d", tt.data, response, err, tt.wantOp)
+		}
+	}
+}
+
+func TestTimedUploadThrottle(t *testing.T) {
+	events := slog.New(slog.NewTextHandler(io.Discard, nil))
+	throttle, err := parseReadThrottle("rate=1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := newTimedUpload(common.SteadyWindow{}, strings.NewReader(strings.Repeat("x", 300)), &connSettings{throttle: throttle})
+
+	// The rate holds across frames: a reader wrapped per frame would start its
+	// budget afresh and let every chunk through at once
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := upload.data(&protocol.Frame{OpCode: protocol.OpData, PayloadLen: 100}, events); err != nil {
+			t.Fatalf("data() failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("300 bytes at 1000 bytes/sec took %v, want about 200ms", elapsed)
+	}
+}
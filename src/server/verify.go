@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"tcp-congestion-benchmark/src/common"
+	"tcp-congestion-benchmark/src/protocol"
+)
+
+// verification totals the payload checks of a connection's uploads
+type verification struct {
+	bytes int64
+	err   error // First mismatch
+}
+
+func (v *verification) add(verifier *common.Verifier) {
+	v.bytes += verifier.Verified()
+	if v.err == nil {
+		v.err = verifier.Err()
+	}
+}
+
+// fill records the checks in the connection log
+func (v *verification) fill(log *common.ConnectionLog, payload *common.Payload) {
+	if payload != nil {
+		log.Payload = payload.String()
+	}
+	log.VerifiedBytes = v.bytes
+	if v.err != nil {
+		log.VerifyError = v.err.Error()
+	}
+}
+
+// handleVerifiedPut checks a PUT payload against the connection's synthetic
+// payload, regenerated from its seed, and drops it instead of storing it. A
+// non-nil error means the connection can no longer be used.
+func handleVerifiedPut(in *connReader, frame *protocol.Frame, settings *connSettings, events *slog.Logger) (*protocol.Frame, *common.Verifier, error) {
+	filename, size, err := protocol.ReadPutHeader(in, frame)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PUT request: %w", err)
+	}
+
+	verifier := common.NewVerifier(settings.payload)
+	if _, err := io.CopyN(verifier, settings.throttle.wrap(in), size); err != nil {
+		return nil, verifier, fmt.Errorf("failed to read PUT payload: %w", err)
+	}
+	if err := verifier.Err(); err != nil {
+		events.Warn("payload verification failed", "op", "PUT", "file", filename, "bytes", size, "err", err)
+		return protocol.CreateErrorFrame(fmt.Sprintf("File %s failed verification: %v", filename, err)), verifier, nil
+	}
+
+	events.Info("payload verified", "op", "PUT", "file", filename, "bytes", size)
+	response := fmt.Sprintf("File %s verified (%d bytes), not stored", filename, size)
+	return &protocol.Frame{
+		OpCode:     protocol.OpPut,
+		PayloadLen: uint32(len(response)),
+		Payload:    []byte(response),
+	}, verifier, nil
+}